@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// shutdownTimeout is how long servers get to finish their requests on exit. Watches never finish, so their
+// connections are closed after it.
+const shutdownTimeout = 5 * time.Second
+
+// exitHandler shuts the servers down and removes the converted copies of the dump, which can contain Secrets in
+// plain text, on every exit. Signals and l.Fatal skip deferred functions, so exits must go through it.
+type exitHandler struct {
+	lock     sync.Mutex
+	exited   bool
+	cleanups []func()
+	servers  []*http.Server
+}
+
+// addCleanup registers a function to run on exit. If the exit already happened, it runs right away.
+func (e *exitHandler) addCleanup(cleanup func()) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.exited {
+		cleanup()
+		return
+	}
+	e.cleanups = append(e.cleanups, cleanup)
+}
+
+// addServer registers a server to shut down on exit. It returns false if the exit already happened, the server
+// must not be started then.
+func (e *exitHandler) addServer(server *http.Server) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.exited {
+		return false
+	}
+	e.servers = append(e.servers, server)
+	return true
+}
+
+// exit shuts the servers down and then runs the cleanups in reverse order of their registration. Only the first
+// call does anything, later ones wait for it to finish.
+func (e *exitHandler) exit(l *zap.Logger) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.exited {
+		return
+	}
+	e.exited = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, server := range e.servers {
+		server := server
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				l.Warn("failed to shut down server gracefully, closing it", zap.Error(err))
+				server.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	for i := len(e.cleanups) - 1; i >= 0; i-- {
+		e.cleanups[i]()
+	}
+}
+
+// fatal exits like l.Fatal after shutting down the servers and running the cleanups.
+func (e *exitHandler) fatal(l *zap.Logger, msg string, fields ...zap.Field) {
+	e.exit(l)
+	l.WithOptions(zap.AddCallerSkip(1)).Fatal(msg, fields...)
+}
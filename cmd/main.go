@@ -1,39 +1,75 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
-	"io/fs"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"k8s.io/apimachinery/pkg/util/sets"
 	clientcmd "k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 
+	"github.com/alvaroaleman/static-kas/pkg/authentication"
+	"github.com/alvaroaleman/static-kas/pkg/etcd"
 	"github.com/alvaroaleman/static-kas/pkg/handler"
+	"github.com/alvaroaleman/static-kas/pkg/hypershift"
+	"github.com/alvaroaleman/static-kas/pkg/layout"
+	"github.com/alvaroaleman/static-kas/pkg/redact"
 )
 
 const Port string = "8080"
 
 type options struct {
-	baseDir string
-	kubeCfg string
+	baseDir        string
+	kubeCfg        string
+	execCommands   string
+	tokenAuthFile  string
+	basicAuthFile  string
+	clientCertAuth bool
+	redact         bool
+	redactionRules string
+	etcdSnapshot   string
+	auditReplay    bool
+	bindAddress    string
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "who-can" {
+		if err := whoCan(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		if err := validate(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	o := options{}
-	flag.StringVar(&o.baseDir, "base-dir", "", "The basedir of the cluster dump")
+	flag.StringVar(&o.baseDir, "base-dir", "", "The basedir of the cluster dump. Can also be a file or a directory with manifests of any kind, for example from kubectl get -o yaml")
 	flag.StringVar(&o.kubeCfg, "kubeconfig", "", "Path to a kubeconfig file. If set, --base-dir will be searched for multiple dumps and a kubeconfig with a context for each of them will be generated")
+	flag.StringVar(&o.execCommands, "exec-commands", "", "Comma-separated list of read-only commands (cat, head, tail, ls) that can be executed in containers. They operate on the files the dump contains for the container")
+	flag.StringVar(&o.tokenAuthFile, "token-auth-file", "", "If set, requests must authenticate with a bearer token from this csv file in the format token,user,uid,\"group1,group2\"")
+	flag.StringVar(&o.basicAuthFile, "basic-auth-file", "", "If set, requests must authenticate with basic auth credentials from this csv file in the format password,user,uid,\"group1,group2\"")
+	flag.BoolVar(&o.clientCertAuth, "client-cert-auth", false, "If set, serve with TLS and authenticate requests with client certificates. A CA and a client certificate are generated and written to the --kubeconfig, which is mandatory then. Requests without a client certificate must authenticate through --token-auth-file or --basic-auth-file")
+	flag.BoolVar(&o.redact, "redact", false, "If set, Secret data, credential env vars, kubeconfigs in ConfigMaps and last-applied-configuration annotations are redacted in all responses")
+	flag.StringVar(&o.redactionRules, "redaction-rules", "", "Path to a YAML file with redaction rules, implies --redact")
+	flag.BoolVar(&o.auditReplay, "replay-audit-logs", false, "If set, watches play back the changes recorded in the audit logs of the dump before ending in the state of the dump")
+	flag.StringVar(&o.bindAddress, "bind-address", "127.0.0.1", "The address to listen on. Set it to 0.0.0.0 to listen on all interfaces")
+	flag.StringVar(&o.etcdSnapshot, "etcd-snapshot", "", "Path to an etcd snapshot or bbolt database file to serve instead of a dump. Mutually exclusive with --base-dir")
 	flag.Parse()
 
 	lCfg := zap.NewProductionConfig()
@@ -45,80 +81,199 @@ func main() {
 	}
 	defer l.Sync()
 
+	// Signals are handled from the start, so the converted copies of the dump are removed no matter when they arrive
+	e := &exitHandler{}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	exited := make(chan struct{})
+	go func() {
+		sig := <-signals
+		l.Info("Received signal, shutting down", zap.String("signal", sig.String()))
+		e.exit(l)
+		close(exited)
+	}()
+
+	if o.etcdSnapshot != "" {
+		if o.baseDir != "" {
+			e.fatal(l, "--base-dir and --etcd-snapshot are mutually exclusive")
+		}
+		objects, err := etcd.Read(l, o.etcdSnapshot)
+		if err != nil {
+			e.fatal(l, "failed to read etcd snapshot", zap.Error(err))
+		}
+		baseDir, cleanup, err := layout.FromObjects(objects)
+		if err != nil {
+			e.fatal(l, "failed to convert etcd snapshot", zap.Error(err))
+		}
+		e.addCleanup(cleanup)
+		l.Info("Serving etcd snapshot from a converted copy", zap.Int("objects", len(objects)), zap.String("path", baseDir))
+		o.baseDir = baseDir
+	}
 	if o.baseDir == "" {
-		l.Fatal("--base-dir is mandatory")
+		e.fatal(l, "--base-dir or --etcd-snapshot is mandatory")
+	}
+	// The context of a dump that is served from a normalized copy is named after the input
+	input := o.baseDir
+	baseDir, cleanup, err := layout.Normalize(o.baseDir)
+	if err != nil {
+		e.fatal(l, "failed to read dump", zap.Error(err))
+	}
+	e.addCleanup(cleanup)
+	if baseDir != o.baseDir {
+		l.Info("Dump is not in the must-gather layout, serving it from a normalized copy", zap.String("path", baseDir))
+		o.baseDir = baseDir
 	}
 
-	if o.kubeCfg == "" {
-		router, err := handler.New(l, o.baseDir, Port)
-		if err != nil {
-			l.Fatal("failed to construct server", zap.Error(err))
+	var handlerOpts []handler.Option
+	if o.execCommands != "" {
+		handlerOpts = append(handlerOpts, handler.WithExecCommands(strings.Split(o.execCommands, ",")...))
+	}
+
+	if o.auditReplay {
+		handlerOpts = append(handlerOpts, handler.WithAuditReplay())
+	}
+
+	if o.redact || o.redactionRules != "" {
+		rules := redact.DefaultRules()
+		if o.redactionRules != "" {
+			if rules, err = redact.LoadRules(o.redactionRules); err != nil {
+				e.fatal(l, "failed to load redaction rules", zap.Error(err))
+			}
 		}
-		if err := http.ListenAndServe(fmt.Sprintf(":%s", Port), router); err != nil {
-			l.Error("server ended", zap.Error(err))
+		redactor, err := redact.New(rules)
+		if err != nil {
+			e.fatal(l, "failed to construct redactor", zap.Error(err))
 		}
+		handlerOpts = append(handlerOpts, handler.WithRedactor(redactor))
+	}
 
-	} else {
-		baseDirs := sets.NewString(o.baseDir)
+	// Listening on all interfaces, clients connect through the host name or localhost
+	allInterfaces := o.bindAddress == "" || net.ParseIP(o.bindAddress).IsUnspecified()
 
-		if err := filepath.Walk(o.baseDir, func(path string, info fs.FileInfo, err error) error {
+	authOpts := authentication.Options{TokenFile: o.tokenAuthFile, BasicAuthFile: o.basicAuthFile}
+	var certs *authentication.Certificates
+	var tlsConfig *tls.Config
+	// Credentials must not be sent in cleartext, so authentication is always served with TLS
+	if authOpts.Enabled() || o.clientCertAuth {
+		if o.kubeCfg == "" {
+			e.fatal(l, "authentication is served with TLS and requires --kubeconfig to write the CA of the generated serving certificate to")
+		}
+		hosts := []string{o.bindAddress}
+		if allInterfaces {
+			hosts = nil
+			if hostname, err := os.Hostname(); err == nil {
+				hosts = append(hosts, hostname)
+			}
+		}
+		certs, err = authentication.GenerateCertificates("static-kas-admin", []string{"system:masters"}, hosts...)
+		if err != nil {
+			e.fatal(l, "failed to generate certificates", zap.Error(err))
+		}
+		tlsConfig, err = certs.ServingTLSConfig()
+		if err != nil {
+			e.fatal(l, "failed to construct tls config", zap.Error(err))
+		}
+		if o.clientCertAuth {
+			authOpts.ClientCA = tlsConfig.ClientCAs
+		}
+	}
+	if authOpts.Enabled() {
+		authenticator, err := authentication.New(authOpts)
+		if err != nil {
+			e.fatal(l, "failed to construct authenticator", zap.Error(err))
+		}
+		handlerOpts = append(handlerOpts, handler.WithAuthenticator(authenticator))
+	}
+
+	serve := func(l *zap.Logger, port string, newHandler func() (http.Handler, error)) int {
+		listener, err := net.Listen("tcp", net.JoinHostPort(o.bindAddress, port))
+		if err != nil {
+			e.fatal(l, "failed to construct listener", zap.Error(err))
+		}
+		go func() {
+			router, err := newHandler()
 			if err != nil {
-				return err
+				e.fatal(l, "failed to construct handler", zap.Error(err))
 			}
-			if !info.IsDir() {
-				return nil
+			server := &http.Server{Handler: router, TLSConfig: tlsConfig}
+			if !e.addServer(server) {
+				listener.Close()
+				return
 			}
-			if filepath.Base(path) != "namespaces" {
-				return nil
+			if tlsConfig != nil {
+				err = server.ServeTLS(listener, "", "")
+			} else {
+				err = server.Serve(listener)
 			}
-			l.Info("Found dump", zap.String("path", filepath.Dir(path)))
-			baseDirs.Insert(filepath.Dir(path))
-			return nil
-		}); err != nil {
-			l.Fatal("failed to walk to find additional dumps", zap.Error(err))
-		}
-
-		baseDirPortMapping := make(map[string]int, len(baseDirs))
-		for _, baseDir := range baseDirs.List() {
-			baseDir := baseDir
-			l := l.With(zap.String("baseDir", baseDir))
-			listener, err := net.Listen("tcp", "")
-			if err != nil {
-				l.Fatal("failed to construct listener", zap.Error(err))
+			if !errors.Is(err, http.ErrServerClosed) {
+				e.fatal(l, "server ended unexpectedly", zap.Error(err))
+			}
+		}()
+		return listener.Addr().(*net.TCPAddr).Port
+	}
+
+	if o.kubeCfg == "" {
+		if hostedClusters, err := hypershift.HostedClusters(o.baseDir); err != nil {
+			l.Warn("failed to find hosted clusters", zap.Error(err))
+		} else if len(hostedClusters) > 0 {
+			l.Info("Dump contains hosted clusters, use --kubeconfig to serve each of them with its own context", zap.Int("count", len(hostedClusters)))
+		}
+		serve(l, Port, func() (http.Handler, error) {
+			return handler.New(l, o.baseDir, handlerOpts...)
+		})
+
+	} else {
+		servers, err := handler.Servers(l, o.baseDir, handlerOpts...)
+		if err != nil {
+			e.fatal(l, "failed to find dumps", zap.Error(err))
+		}
+		for i := range servers {
+			if servers[i].Name == o.baseDir {
+				servers[i].Name = input
 			}
-			baseDirPortMapping[baseDir] = listener.Addr().(*net.TCPAddr).Port
-			go func() {
-				router, err := handler.New(l, baseDir, strconv.Itoa(baseDirPortMapping[baseDir]))
-				if err != nil {
-					l.Fatal("failed to construct handler", zap.Error(err))
-				}
-				server := &http.Server{Handler: router}
-				if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
-					l.Fatal("server ended unexpectedly", zap.Error(err))
-				}
-			}()
 		}
 		kubeCfg := clientcmdapi.Config{
 			Kind:           "Config",
 			APIVersion:     "v1",
 			Clusters:       map[string]*clientcmdapi.Cluster{},
 			Contexts:       map[string]*clientcmdapi.Context{},
-			CurrentContext: o.baseDir,
+			AuthInfos:      map[string]*clientcmdapi.AuthInfo{},
+			CurrentContext: input,
+		}
+		scheme, authInfo := "http", ""
+		if certs != nil {
+			scheme = "https"
+		}
+		if o.clientCertAuth {
+			authInfo = "static-kas-admin"
+			kubeCfg.AuthInfos[authInfo] = &clientcmdapi.AuthInfo{ClientCertificateData: certs.ClientCert, ClientKeyData: certs.ClientKey}
+		}
+		host := o.bindAddress
+		if allInterfaces {
+			host = "127.0.0.1"
+		}
+		for _, server := range servers {
+			port := serve(l.With(zap.String("server", server.Name)), "0", server.New)
+			kubeCfg.Clusters[server.Name] = &clientcmdapi.Cluster{Server: scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port))}
+			if certs != nil {
+				kubeCfg.Clusters[server.Name].CertificateAuthorityData = certs.CACert
+			}
+			for _, context := range append([]string{server.Name}, server.Aliases...) {
+				kubeCfg.Contexts[context] = &clientcmdapi.Context{Cluster: server.Name, AuthInfo: authInfo}
+			}
 		}
-		for baseDir, port := range baseDirPortMapping {
-			kubeCfg.Clusters[baseDir] = &clientcmdapi.Cluster{Server: "http://127.0.0.1:" + strconv.Itoa(port)}
-			kubeCfg.Contexts[baseDir] = &clientcmdapi.Context{Cluster: baseDir}
+		// The base dir itself is not a dump if it only contains others
+		if _, found := kubeCfg.Contexts[kubeCfg.CurrentContext]; !found {
+			kubeCfg.CurrentContext = servers[0].Name
 		}
 		serialized, err := clientcmd.Write(kubeCfg)
 		if err != nil {
-			l.Fatal("Failed to serialize kubeconfig", zap.Error(err))
+			e.fatal(l, "Failed to serialize kubeconfig", zap.Error(err))
 		}
-		if err := os.WriteFile(o.kubeCfg, serialized, 0644); err != nil {
-			l.Fatal("Failed to write kubeconfig", zap.Error(err))
+		if err := os.WriteFile(o.kubeCfg, serialized, 0600); err != nil {
+			e.fatal(l, "Failed to write kubeconfig", zap.Error(err))
 		}
 	}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
+	<-exited
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/alvaroaleman/static-kas/pkg/layout"
+)
+
+const validateUsage = `Usage: static-kas validate --base-dir <dir> [-o json]
+
+Reports problems in a dump that prevent parts of it from being served or make them being served differently than
+expected: unreadable files, parse errors, unknown kinds, files whose resource can not be derived from their path,
+empty files and lists, duplicate objects and objects whose namespace disagrees with the directory they are in. Exits
+non-zero if any problem of severity Error is found.
+
+Flags:
+`
+
+// errValidationFailed is returned by validate if the dump has errors, after they were printed.
+var errValidationFailed = errors.New("the dump has errors")
+
+// validate implements the validate subcommand.
+func validate(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), validateUsage)
+		fs.PrintDefaults()
+	}
+	var baseDir, output string
+	fs.StringVar(&baseDir, "base-dir", "", "The basedir of the cluster dump. Can also be a file or a directory with manifests of any kind, whose problems are reported for the files themselves")
+	fs.StringVar(&output, "o", "", "Output format, either empty for a table or json")
+	fs.Parse(args)
+
+	if baseDir == "" {
+		return errors.New("--base-dir is mandatory")
+	}
+	if output != "" && output != "json" {
+		return fmt.Errorf("unsupported output format %q", output)
+	}
+
+	report, err := layout.Diagnose(baseDir)
+	if err != nil {
+		return err
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SEVERITY\tTYPE\tPATH\tMESSAGE")
+		for _, problem := range report.Problems {
+			path := problem.Path
+			if problem.Line > 0 {
+				path += ":" + strconv.Itoa(problem.Line)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", problem.Severity, problem.Type, path, problem.Message)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if report.HasErrors() {
+		return errValidationFailed
+	}
+	return nil
+}
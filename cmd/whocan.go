@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+
+	"github.com/alvaroaleman/static-kas/pkg/layout"
+	"github.com/alvaroaleman/static-kas/pkg/rbac"
+)
+
+const whoCanUsage = `Usage: static-kas who-can --base-dir <dir> [-n <namespace>] [-o json] VERB RESOURCE[.GROUP][/SUBRESOURCE] [NAME]
+       static-kas who-can --base-dir <dir> [-o json] VERB /NON-RESOURCE-URL
+
+Lists all users, groups and ServiceAccounts the RBAC objects in the dump allow to do something, together with
+the binding, role and rule that allows it.
+
+Flags:
+`
+
+// whoCan implements the who-can subcommand.
+func whoCan(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("who-can", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), whoCanUsage)
+		fs.PrintDefaults()
+	}
+	var baseDir, namespace, output string
+	fs.StringVar(&baseDir, "base-dir", "", "The basedir of the cluster dump")
+	fs.StringVar(&namespace, "namespace", "", "The namespace, if unset only ClusterRoleBindings are considered")
+	fs.StringVar(&namespace, "n", "", "Shorthand for --namespace")
+	fs.StringVar(&output, "o", "", "Output format, either empty for a table or json")
+	positionalArgs := parseInterspersed(fs, args)
+
+	if baseDir == "" {
+		return errors.New("--base-dir is mandatory")
+	}
+	if output != "" && output != "json" {
+		return fmt.Errorf("unsupported output format %q", output)
+	}
+	attributes, err := whoCanAttributes(positionalArgs, namespace)
+	if err != nil {
+		fs.Usage()
+		return err
+	}
+
+	baseDir, cleanup, err := layout.Normalize(baseDir)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	a, err := rbac.NewAuthorizer(baseDir, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: encountered errors reading rbac, results might be incomplete: %v\n", err)
+	}
+	grants := a.WhoCan(attributes)
+
+	if output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(grants)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAMESPACE\tNAME\tBINDING\tROLE")
+	for _, grant := range grants {
+		binding, role := "<privileged group>", ""
+		if grant.Binding != nil {
+			binding = grant.Binding.Kind + "/" + grant.Binding.Name
+			role = grant.Role.Kind + "/" + grant.Role.Name
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", grant.Subject.Kind, grant.Subject.Namespace, grant.Subject.Name, binding, role)
+	}
+
+	return tw.Flush()
+}
+
+// parseInterspersed parses args with fs and returns the positional arguments. Unlike fs.Parse, it does not stop at
+// the first positional argument, so flags can be passed after them like in kubectl. Everything after a "--" is
+// positional.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		remaining := fs.Args()
+		if consumed := len(args) - len(remaining); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, remaining...)
+		}
+		if len(remaining) == 0 {
+			return positional
+		}
+		positional = append(positional, remaining[0])
+		args = remaining[1:]
+	}
+}
+
+// whoCanAttributes parses the arguments of who-can in the same format kubectl auth can-i uses.
+func whoCanAttributes(args []string, namespace string) (authorizer.AttributesRecord, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return authorizer.AttributesRecord{}, errors.New("expected a verb, a resource or non-resource URL and optionally a name")
+	}
+	attributes := authorizer.AttributesRecord{Verb: args[0]}
+	if strings.HasPrefix(args[1], "/") {
+		if len(args) == 3 {
+			return authorizer.AttributesRecord{}, errors.New("a name can not be passed for non-resource URLs")
+		}
+		attributes.Path = args[1]
+		return attributes, nil
+	}
+
+	attributes.ResourceRequest = true
+	attributes.Namespace = namespace
+	resource := args[1]
+	if split := strings.SplitN(resource, "/", 2); len(split) == 2 {
+		resource, attributes.Subresource = split[0], split[1]
+	}
+	if split := strings.SplitN(resource, ".", 2); len(split) == 2 {
+		resource, attributes.APIGroup = split[0], split[1]
+	}
+	attributes.Resource = resource
+	if len(args) == 3 {
+		attributes.Name = args[2]
+	}
+
+	return attributes, nil
+}
@@ -0,0 +1,369 @@
+// builtin-resources generates the table of built-in resources pkg/discovery uses to advertise resources and to
+// map kinds to resources. Everything is read from the REST storage the kube-apiserver, the kube-aggregator and the
+// apiextensions-apiserver serve the resources with, using the same storage providers they use. It is a module of its
+// own, so static-kas doesn't have to depend on the REST storage.
+//
+// Usage: go run . <output file>
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	apiextensionsinstall "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/install"
+	crdstore "k8s.io/apiextensions-apiserver/pkg/registry/customresourcedefinition"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/registry/rest"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/client-go/tools/cache"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	apiservicerest "k8s.io/kube-aggregator/pkg/registry/apiservice/rest"
+	"k8s.io/kubernetes/pkg/api/legacyscheme"
+	api "k8s.io/kubernetes/pkg/apis/core"
+	kubeletclient "k8s.io/kubernetes/pkg/kubelet/client"
+	admissionregistrationrest "k8s.io/kubernetes/pkg/registry/admissionregistration/rest"
+	apiserverinternalrest "k8s.io/kubernetes/pkg/registry/apiserverinternal/rest"
+	appsrest "k8s.io/kubernetes/pkg/registry/apps/rest"
+	autoscalingrest "k8s.io/kubernetes/pkg/registry/autoscaling/rest"
+	batchrest "k8s.io/kubernetes/pkg/registry/batch/rest"
+	certificatesrest "k8s.io/kubernetes/pkg/registry/certificates/rest"
+	coordinationrest "k8s.io/kubernetes/pkg/registry/coordination/rest"
+	"k8s.io/kubernetes/pkg/registry/core/componentstatus"
+	configmapstore "k8s.io/kubernetes/pkg/registry/core/configmap/storage"
+	endpointstore "k8s.io/kubernetes/pkg/registry/core/endpoint/storage"
+	eventstore "k8s.io/kubernetes/pkg/registry/core/event/storage"
+	limitrangestore "k8s.io/kubernetes/pkg/registry/core/limitrange/storage"
+	namespacestore "k8s.io/kubernetes/pkg/registry/core/namespace/storage"
+	nodestore "k8s.io/kubernetes/pkg/registry/core/node/storage"
+	persistentvolumestore "k8s.io/kubernetes/pkg/registry/core/persistentvolume/storage"
+	persistentvolumeclaimstore "k8s.io/kubernetes/pkg/registry/core/persistentvolumeclaim/storage"
+	podstore "k8s.io/kubernetes/pkg/registry/core/pod/storage"
+	podtemplatestore "k8s.io/kubernetes/pkg/registry/core/podtemplate/storage"
+	replicationcontrollerstore "k8s.io/kubernetes/pkg/registry/core/replicationcontroller/storage"
+	resourcequotastore "k8s.io/kubernetes/pkg/registry/core/resourcequota/storage"
+	secretstore "k8s.io/kubernetes/pkg/registry/core/secret/storage"
+	servicestore "k8s.io/kubernetes/pkg/registry/core/service/storage"
+	serviceaccountstore "k8s.io/kubernetes/pkg/registry/core/serviceaccount/storage"
+	discoveryrest "k8s.io/kubernetes/pkg/registry/discovery/rest"
+	eventsrest "k8s.io/kubernetes/pkg/registry/events/rest"
+	flowcontrolrest "k8s.io/kubernetes/pkg/registry/flowcontrol/rest"
+	networkingrest "k8s.io/kubernetes/pkg/registry/networking/rest"
+	noderest "k8s.io/kubernetes/pkg/registry/node/rest"
+	policyrest "k8s.io/kubernetes/pkg/registry/policy/rest"
+	rbacrest "k8s.io/kubernetes/pkg/registry/rbac/rest"
+	resourcerest "k8s.io/kubernetes/pkg/registry/resource/rest"
+	schedulingrest "k8s.io/kubernetes/pkg/registry/scheduling/rest"
+	storagerest "k8s.io/kubernetes/pkg/registry/storage/rest"
+
+	// The same api groups pkg/controlplane installs
+	_ "k8s.io/kubernetes/pkg/apis/admission/install"
+	_ "k8s.io/kubernetes/pkg/apis/admissionregistration/install"
+	_ "k8s.io/kubernetes/pkg/apis/apiserverinternal/install"
+	_ "k8s.io/kubernetes/pkg/apis/apps/install"
+	_ "k8s.io/kubernetes/pkg/apis/authentication/install"
+	_ "k8s.io/kubernetes/pkg/apis/authorization/install"
+	_ "k8s.io/kubernetes/pkg/apis/autoscaling/install"
+	_ "k8s.io/kubernetes/pkg/apis/batch/install"
+	_ "k8s.io/kubernetes/pkg/apis/certificates/install"
+	_ "k8s.io/kubernetes/pkg/apis/coordination/install"
+	_ "k8s.io/kubernetes/pkg/apis/core/install"
+	_ "k8s.io/kubernetes/pkg/apis/discovery/install"
+	_ "k8s.io/kubernetes/pkg/apis/events/install"
+	_ "k8s.io/kubernetes/pkg/apis/extensions/install"
+	_ "k8s.io/kubernetes/pkg/apis/flowcontrol/install"
+	_ "k8s.io/kubernetes/pkg/apis/imagepolicy/install"
+	_ "k8s.io/kubernetes/pkg/apis/networking/install"
+	_ "k8s.io/kubernetes/pkg/apis/node/install"
+	_ "k8s.io/kubernetes/pkg/apis/policy/install"
+	_ "k8s.io/kubernetes/pkg/apis/rbac/install"
+	_ "k8s.io/kubernetes/pkg/apis/resource/install"
+	_ "k8s.io/kubernetes/pkg/apis/scheduling/install"
+	_ "k8s.io/kubernetes/pkg/apis/storage/install"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: go run . <output file>")
+		os.Exit(1)
+	}
+	if err := generate(os.Args[1]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// resource is an entry of the generated table.
+type resource struct {
+	GroupVersion string
+	Name         string
+	SingularName string
+	Kind         string
+	Namespaced   bool
+	ShortNames   []string
+	Categories   []string
+	Verbs        []string
+}
+
+func generate(outputFile string) error {
+	storageMaps, err := storageByGroupVersion()
+	if err != nil {
+		return err
+	}
+
+	var resources []resource
+	for groupVersion, storageMap := range storageMaps {
+		for name, storage := range storageMap {
+			// Subresources are not part of the table and resources that can not be listed can not be in a dump
+			if strings.Contains(name, "/") {
+				continue
+			}
+			if _, isLister := storage.(rest.Lister); !isLister {
+				continue
+			}
+			resource, err := resourceFor(groupVersion, name, storage)
+			if err != nil {
+				return err
+			}
+			resources = append(resources, resource)
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].GroupVersion != resources[j].GroupVersion {
+			return resources[i].GroupVersion < resources[j].GroupVersion
+		}
+		return resources[i].Name < resources[j].Name
+	})
+
+	buf := &bytes.Buffer{}
+	if err := tableTemplate.Execute(buf, resources); err != nil {
+		return fmt.Errorf("failed to render table: %w", err)
+	}
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format table: %w", err)
+	}
+
+	return os.WriteFile(outputFile, formatted, 0644)
+}
+
+func resourceFor(groupVersion schema.GroupVersion, name string, storage rest.Storage) (resource, error) {
+	scoper, ok := storage.(rest.Scoper)
+	if !ok {
+		return resource{}, fmt.Errorf("storage of %s in %s doesn't know its scope", name, groupVersion)
+	}
+	result := resource{
+		GroupVersion: groupVersion.String(),
+		Name:         name,
+		Kind:         reflect.TypeOf(storage.New()).Elem().Name(),
+		Namespaced:   scoper.NamespaceScoped(),
+	}
+	if provider, ok := storage.(rest.GroupVersionKindProvider); ok {
+		result.Kind = provider.GroupVersionKind(groupVersion).Kind
+	}
+	result.SingularName = strings.ToLower(result.Kind)
+	if provider, ok := storage.(rest.SingularNameProvider); ok {
+		result.SingularName = provider.GetSingularName()
+	}
+	if provider, ok := storage.(rest.ShortNamesProvider); ok {
+		result.ShortNames = provider.ShortNames()
+	}
+	if provider, ok := storage.(rest.CategoriesProvider); ok {
+		result.Categories = provider.Categories()
+	}
+	if _, ok := storage.(rest.Getter); ok {
+		result.Verbs = append(result.Verbs, "get")
+	}
+	result.Verbs = append(result.Verbs, "list")
+	if _, ok := storage.(rest.Watcher); ok {
+		result.Verbs = append(result.Verbs, "watch")
+	}
+
+	return result, nil
+}
+
+// storageByGroupVersion returns the REST storage of all resources in all versions, including the ones that are
+// disabled by default or behind a feature gate.
+func storageByGroupVersion() (map[schema.GroupVersion]map[string]rest.Storage, error) {
+	// Some storage providers skip resources whose feature gate is disabled
+	if err := utilfeature.DefaultMutableFeatureGate.Set("AllAlpha=true,AllBeta=true"); err != nil {
+		return nil, fmt.Errorf("failed to enable all feature gates: %w", err)
+	}
+	resourceConfig := serverstorage.NewResourceConfig()
+	resourceConfig.EnableVersions(legacyscheme.Scheme.PrioritizedVersionsAllGroups()...)
+	resourceConfig.EnableVersions(apiregistrationv1.SchemeGroupVersion)
+	optsGetter := restOptionsGetter{}
+
+	result := map[schema.GroupVersion]map[string]rest.Storage{}
+	// Same providers as in pkg/controlplane, minus the ones that need a running apiserver
+	providers := []restStorageProvider{
+		apiserverinternalrest.StorageProvider{},
+		autoscalingrest.RESTStorageProvider{},
+		batchrest.RESTStorageProvider{},
+		certificatesrest.RESTStorageProvider{},
+		coordinationrest.RESTStorageProvider{},
+		discoveryrest.StorageProvider{},
+		networkingrest.RESTStorageProvider{},
+		noderest.RESTStorageProvider{},
+		policyrest.RESTStorageProvider{},
+		rbacrest.RESTStorageProvider{},
+		schedulingrest.RESTStorageProvider{},
+		storagerest.RESTStorageProvider{},
+		flowcontrolrest.RESTStorageProvider{},
+		appsrest.StorageProvider{},
+		admissionregistrationrest.RESTStorageProvider{},
+		eventsrest.RESTStorageProvider{},
+		resourcerest.RESTStorageProvider{},
+	}
+	for _, provider := range providers {
+		apiGroupInfo, err := provider.NewRESTStorage(resourceConfig, optsGetter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct storage of %s: %w", provider.GroupName(), err)
+		}
+		addAPIGroupInfo(result, provider.GroupName(), apiGroupInfo)
+	}
+	addAPIGroupInfo(result, "apiregistration.k8s.io", apiservicerest.NewRESTStorage(resourceConfig, optsGetter, true))
+
+	apiextensionsScheme := runtime.NewScheme()
+	apiextensionsinstall.Install(apiextensionsScheme)
+	crdStorage, err := crdstore.NewREST(apiextensionsScheme, optsGetter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct customresourcedefinition storage: %w", err)
+	}
+	result[schema.GroupVersion{Group: "apiextensions.k8s.io", Version: "v1"}] = map[string]rest.Storage{"customresourcedefinitions": crdStorage}
+
+	coreStorage, err := coreStorage(optsGetter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct core storage: %w", err)
+	}
+	result[schema.GroupVersion{Version: "v1"}] = coreStorage
+
+	return result, nil
+}
+
+// restStorageProvider is the RESTStorageProvider of pkg/controlplane.
+type restStorageProvider interface {
+	GroupName() string
+	NewRESTStorage(serverstorage.APIResourceConfigSource, generic.RESTOptionsGetter) (genericapiserver.APIGroupInfo, error)
+}
+
+func addAPIGroupInfo(result map[schema.GroupVersion]map[string]rest.Storage, group string, apiGroupInfo genericapiserver.APIGroupInfo) {
+	for version, storageMap := range apiGroupInfo.VersionedResourcesStorageMap {
+		result[schema.GroupVersion{Group: group, Version: version}] = storageMap
+	}
+}
+
+// coreStorage returns the storage of the listable core resources. The LegacyRESTStorageProvider of the kube-apiserver
+// can not be used, because it connects to etcd for the allocation of service IPs and node ports.
+func coreStorage(optsGetter generic.RESTOptionsGetter) (map[string]rest.Storage, error) {
+	result := map[string]rest.Storage{
+		"componentstatuses": componentstatus.NewStorage(nil),
+	}
+	var err error
+	if result["configmaps"], err = configmapstore.NewREST(optsGetter); err != nil {
+		return nil, err
+	}
+	if result["endpoints"], err = endpointstore.NewREST(optsGetter); err != nil {
+		return nil, err
+	}
+	if result["events"], err = eventstore.NewREST(optsGetter, 0); err != nil {
+		return nil, err
+	}
+	if result["limitranges"], err = limitrangestore.NewREST(optsGetter); err != nil {
+		return nil, err
+	}
+	if result["namespaces"], _, _, err = namespacestore.NewREST(optsGetter); err != nil {
+		return nil, err
+	}
+	nodeStorage, err := nodestore.NewStorage(optsGetter, kubeletclient.KubeletClientConfig{}, http.DefaultTransport)
+	if err != nil {
+		return nil, err
+	}
+	result["nodes"] = nodeStorage.Node
+	if result["persistentvolumes"], _, err = persistentvolumestore.NewREST(optsGetter); err != nil {
+		return nil, err
+	}
+	if result["persistentvolumeclaims"], _, err = persistentvolumeclaimstore.NewREST(optsGetter); err != nil {
+		return nil, err
+	}
+	podStorage, err := podstore.NewStorage(optsGetter, nil, http.DefaultTransport, nil)
+	if err != nil {
+		return nil, err
+	}
+	result["pods"] = podStorage.Pod
+	if result["podtemplates"], err = podtemplatestore.NewREST(optsGetter); err != nil {
+		return nil, err
+	}
+	controllerStorage, err := replicationcontrollerstore.NewStorage(optsGetter)
+	if err != nil {
+		return nil, err
+	}
+	result["replicationcontrollers"] = controllerStorage.Controller
+	if result["resourcequotas"], _, err = resourcequotastore.NewREST(optsGetter); err != nil {
+		return nil, err
+	}
+	if result["secrets"], err = secretstore.NewREST(optsGetter); err != nil {
+		return nil, err
+	}
+	if result["serviceaccounts"], err = serviceaccountstore.NewREST(optsGetter, nil, nil, 0, nil, nil, false); err != nil {
+		return nil, err
+	}
+	if result["services"], _, _, err = servicestore.NewREST(optsGetter, api.IPv4Protocol, nil, nil, nil, nil, http.DefaultTransport); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// restOptionsGetter returns options whose storage is never connected to anything, the generator only reads the
+// metadata of the REST storage.
+type restOptionsGetter struct{}
+
+func (restOptionsGetter) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	return generic.RESTOptions{
+		StorageConfig:  &storagebackend.ConfigForResource{GroupResource: resource},
+		Decorator:      unconnectedStorage,
+		ResourcePrefix: resource.Group + "/" + resource.Resource,
+	}, nil
+}
+
+func unconnectedStorage(
+	_ *storagebackend.ConfigForResource,
+	_ string,
+	_ func(obj runtime.Object) (string, error),
+	_ func() runtime.Object,
+	_ func() runtime.Object,
+	_ storage.AttrFunc,
+	_ storage.IndexerFuncs,
+	_ *cache.Indexers,
+) (storage.Interface, factory.DestroyFunc, error) {
+	return nil, func() {}, nil
+}
+
+var tableTemplate = template.Must(template.New("table").Parse(`// Code generated by hack/builtin-resources. DO NOT EDIT.
+
+package discovery
+
+// kubeResources are the listable resources of the kube-apiserver, the kube-aggregator and the
+// apiextensions-apiserver in all versions they can serve.
+var kubeResources = []builtinResource{
+{{- range . }}
+	{groupVersion: {{ printf "%q" .GroupVersion }}, name: {{ printf "%q" .Name }}, singularName: {{ printf "%q" .SingularName }}, kind: {{ printf "%q" .Kind }}, namespaced: {{ .Namespaced }}
+	{{- with .ShortNames }}, shortNames: {{ printf "%#v" . }}{{ end }}
+	{{- with .Categories }}, categories: {{ printf "%#v" . }}{{ end }}, verbs: {{ printf "%#v" .Verbs }}},
+{{- end }}
+}
+`))
@@ -0,0 +1,302 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// maxEventSize is the maximum size of a single line in an audit log. Events with the RequestResponse level contain
+// the whole object, so they can be big.
+const maxEventSize = 16 * 1024 * 1024
+
+// maxObjectsPerKey is the number of states that are kept for each object. Older changes are still listed, but
+// without their object, so objects that are updated all the time like leases don't fill up the memory.
+const maxObjectsPerKey = 100
+
+// mutatingVerbs are the verbs that change an object.
+var mutatingVerbs = map[string]bool{"create": true, "update": true, "patch": true, "delete": true}
+
+// Change is a successful mutation of an object that was recorded in an audit log.
+type Change struct {
+	Timestamp   metav1.MicroTime `json:"timestamp"`
+	Verb        string           `json:"verb"`
+	User        string           `json:"user"`
+	Subresource string           `json:"subresource,omitempty"`
+	AuditID     string           `json:"auditID"`
+	// Object is the object after the change. It is only set if the change was logged with the RequestResponse level.
+	Object *unstructured.Unstructured `json:"object,omitempty"`
+}
+
+// ObjectKey identifies an object in the audit logs. Group is empty for the core group.
+type ObjectKey struct {
+	Group     string
+	Resource  string
+	Namespace string
+	Name      string
+}
+
+// History contains all changes of all objects that were recorded in the audit logs of a dump.
+type History struct {
+	changes map[ObjectKey][]Change
+	// objects is the number of changes of each object that have an object.
+	objects map[ObjectKey]int
+}
+
+// Load reads all audit logs below the audit_logs folder of a dump. Logs may be gzipped. A dump without audit logs
+// results in an empty history. Logs that can't be read completely, for example because they were cut off when the
+// dump was taken, are logged and contribute the events that could be read.
+func Load(l *zap.Logger, baseDir string) (*History, error) {
+	h := &History{changes: map[ObjectKey][]Change{}, objects: map[ObjectKey]int{}}
+	auditDir := filepath.Join(baseDir, "audit_logs")
+	if _, err := os.Stat(auditDir); os.IsNotExist(err) {
+		return h, nil
+	}
+	err := filepath.WalkDir(auditDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !(strings.HasSuffix(d.Name(), ".log") || strings.HasSuffix(d.Name(), ".log.gz")) {
+			return nil
+		}
+		if err := h.readLog(l, path); err != nil {
+			l.Warn("failed to read audit log completely", zap.String("path", path), zap.Error(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit logs: %w", err)
+	}
+	for key := range h.changes {
+		h.trim(key)
+	}
+
+	return h, nil
+}
+
+// trim sorts the changes of an object and drops the objects of all but the last maxObjectsPerKey changes.
+func (h *History) trim(key ObjectKey) {
+	changes := h.changes[key]
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Timestamp.Before(&changes[j].Timestamp)
+	})
+	kept := 0
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].Object == nil {
+			continue
+		}
+		if kept == maxObjectsPerKey {
+			changes[i].Object = nil
+			continue
+		}
+		kept++
+	}
+	h.objects[key] = kept
+}
+
+func (h *History) readLog(l *zap.Logger, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to decompress %s: %w", path, err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	bufferedReader := bufio.NewReaderSize(reader, 64*1024)
+	skipped := 0
+	for {
+		line, tooLong, err := readLine(bufferedReader)
+		if tooLong {
+			skipped++
+		} else if len(line) > 0 {
+			event := &auditv1.Event{}
+			// Logs that were cut off when the dump was taken end in a partial line
+			if json.Unmarshal(line, event) == nil {
+				h.add(event)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	if skipped > 0 {
+		l.Warn("skipped audit events that exceed the maximum size", zap.String("path", path), zap.Int("count", skipped), zap.Int("maxSize", maxEventSize))
+	}
+
+	return nil
+}
+
+// readLine reads the next line. Lines longer than maxEventSize are discarded and reported as tooLong.
+func readLine(r *bufio.Reader) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if !tooLong {
+			if len(line)+len(chunk) > maxEventSize {
+				line, tooLong = nil, true
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if err != nil || !isPrefix {
+			return line, tooLong, err
+		}
+	}
+}
+
+func (h *History) add(event *auditv1.Event) {
+	if event.Stage != auditv1.StageResponseComplete || !mutatingVerbs[event.Verb] || event.ObjectRef == nil {
+		return
+	}
+	if event.ResponseStatus != nil && (event.ResponseStatus.Code < 200 || event.ResponseStatus.Code > 299) {
+		return
+	}
+
+	change := Change{
+		Timestamp:   event.StageTimestamp,
+		Verb:        event.Verb,
+		User:        event.User.Username,
+		Subresource: event.ObjectRef.Subresource,
+		AuditID:     string(event.AuditID),
+	}
+	if event.ResponseObject != nil && len(event.ResponseObject.Raw) > 0 {
+		object := &unstructured.Unstructured{}
+		// Deletions respond with a Status rather than the object, unless they only set the deletionTimestamp
+		if err := object.UnmarshalJSON(event.ResponseObject.Raw); err == nil && object.GetKind() != "Status" {
+			change.Object = object
+		}
+	}
+
+	key := ObjectKey{
+		Group:     event.ObjectRef.APIGroup,
+		Resource:  event.ObjectRef.Resource,
+		Namespace: event.ObjectRef.Namespace,
+		Name:      event.ObjectRef.Name,
+	}
+	// The name of created objects is often only in the response
+	if key.Name == "" && change.Object != nil {
+		key.Name = change.Object.GetName()
+	}
+	if key.Name == "" {
+		return
+	}
+	h.changes[key] = append(h.changes[key], change)
+	if change.Object != nil {
+		h.objects[key]++
+		// Logs are not necessarily read in order, so the objects are only dropped once there are clearly too many
+		if h.objects[key] > 2*maxObjectsPerKey {
+			h.trim(key)
+		}
+	}
+}
+
+// For returns the changes of an object, oldest first.
+func (h *History) For(key ObjectKey) []Change {
+	return h.changes[key]
+}
+
+// Objects returns the keys of all objects of a resource that have changes. If namespace is empty, objects in all
+// namespaces are returned.
+func (h *History) Objects(group, resource, namespace string) []ObjectKey {
+	var result []ObjectKey
+	for key := range h.changes {
+		if key.Group == group && key.Resource == resource && (namespace == "" || key.Namespace == namespace) {
+			result = append(result, key)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// Event is a watch event that replays a change.
+type Event struct {
+	Type      string
+	Timestamp metav1.MicroTime
+	Object    *unstructured.Unstructured
+}
+
+// Replay returns the watch events that play back the changes of an object, oldest first. Changes without an object
+// are skipped. current is the object from the dump or nil if it isn't part of the dump. The events end in the state
+// of the dump, so a client that processes all of them ends up with the same object as one that lists it.
+func (h *History) Replay(key ObjectKey, current *unstructured.Unstructured) []Event {
+	var result []Event
+	var last *unstructured.Unstructured
+	var lastTimestamp metav1.MicroTime
+	for _, change := range h.changes[key] {
+		lastTimestamp = change.Timestamp
+		// Deletions of objects with finalizers only set the deletionTimestamp
+		if change.Verb == "delete" && (change.Object == nil || change.Object.GetDeletionTimestamp() == nil) {
+			if last == nil {
+				continue
+			}
+			object := last
+			if change.Object != nil {
+				object = change.Object
+			}
+			result = append(result, Event{Type: "DELETED", Timestamp: change.Timestamp, Object: object.DeepCopy()})
+			last = nil
+			continue
+		}
+		if change.Object == nil {
+			continue
+		}
+		eventType := "MODIFIED"
+		if last == nil {
+			eventType = "ADDED"
+		}
+		last = change.Object
+		result = append(result, Event{Type: eventType, Timestamp: change.Timestamp, Object: change.Object.DeepCopy()})
+	}
+
+	switch {
+	case current != nil && last == nil:
+		result = append(result, Event{Type: "ADDED", Timestamp: lastTimestamp, Object: current})
+	case current != nil && last.GetResourceVersion() != current.GetResourceVersion():
+		result = append(result, Event{Type: "MODIFIED", Timestamp: lastTimestamp, Object: current})
+	case current == nil && last != nil:
+		// Deleted after the last change that was recorded
+		result = append(result, Event{Type: "DELETED", Timestamp: lastTimestamp, Object: last.DeepCopy()})
+	}
+
+	return result
+}
+
+// LastObject returns the last state of an object that was recorded, even if it was deleted afterwards.
+func (h *History) LastObject(key ObjectKey) *unstructured.Unstructured {
+	changes := h.changes[key]
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].Object != nil {
+			return changes[i].Object
+		}
+	}
+	return nil
+}
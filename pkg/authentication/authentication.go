@@ -0,0 +1,54 @@
+package authentication
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/request/bearertoken"
+	"k8s.io/apiserver/pkg/authentication/request/union"
+	x509request "k8s.io/apiserver/pkg/authentication/request/x509"
+	"k8s.io/apiserver/pkg/authentication/token/tokenfile"
+)
+
+// Options configures which authentication methods are enabled.
+type Options struct {
+	// TokenFile is a csv file in the format of the --token-auth-file of the kube-apiserver: token,user,uid,"group1,group2"
+	TokenFile string
+	// BasicAuthFile is a csv file in the format password,user,uid,"group1,group2"
+	BasicAuthFile string
+	// ClientCA enables client certificate authentication for certificates signed by it. The common name is used
+	// as user name and the organizations as groups.
+	ClientCA *x509.CertPool
+}
+
+// Enabled returns if any authentication method is enabled.
+func (o Options) Enabled() bool {
+	return o.TokenFile != "" || o.BasicAuthFile != "" || o.ClientCA != nil
+}
+
+// New constructs an authenticator that accepts requests any of the enabled authentication methods accepts.
+func New(o Options) (authenticator.Request, error) {
+	var authenticators []authenticator.Request
+	if o.ClientCA != nil {
+		verifyOpts := x509request.DefaultVerifyOptions()
+		verifyOpts.Roots = o.ClientCA
+		authenticators = append(authenticators, x509request.New(verifyOpts, x509request.CommonNameUserConversion))
+	}
+	if o.TokenFile != "" {
+		tokenAuthenticator, err := tokenfile.NewCSV(o.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token file %s: %w", o.TokenFile, err)
+		}
+		authenticators = append(authenticators, bearertoken.New(tokenAuthenticator))
+	}
+	if o.BasicAuthFile != "" {
+		basicAuthenticator, err := newBasicAuthenticator(o.BasicAuthFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read basic auth file %s: %w", o.BasicAuthFile, err)
+		}
+		authenticators = append(authenticators, basicAuthenticator)
+	}
+
+	return union.New(authenticators...), nil
+}
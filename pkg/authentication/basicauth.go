@@ -0,0 +1,75 @@
+package authentication
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+type basicAuthUser struct {
+	password string
+	info     *user.DefaultInfo
+}
+
+// basicAuthenticator authenticates requests with basic auth against a csv file. It uses the format the kube-apiserver
+// used for its --basic-auth-file before it was removed.
+type basicAuthenticator struct {
+	users map[string]basicAuthUser
+}
+
+func newBasicAuthenticator(path string) (*basicAuthenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := map[string]basicAuthUser{}
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d: expected at least three columns (password,user,uid), got %d", line, len(record))
+		}
+		info := &user.DefaultInfo{Name: record[1], UID: record[2]}
+		if len(record) >= 4 && record[3] != "" {
+			info.Groups = strings.Split(record[3], ",")
+		}
+		if _, exists := users[info.Name]; exists {
+			return nil, fmt.Errorf("line %d: duplicate user %q", line, info.Name)
+		}
+		users[info.Name] = basicAuthUser{password: record[0], info: info}
+	}
+
+	return &basicAuthenticator{users: users}, nil
+}
+
+func (a *basicAuthenticator) AuthenticateRequest(r *http.Request) (*authenticator.Response, bool, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, false, nil
+	}
+	u, found := a.users[username]
+	if !found || subtle.ConstantTimeCompare([]byte(u.password), []byte(password)) != 1 {
+		return nil, false, errors.New("invalid username or password")
+	}
+
+	// Don't pass the credentials on
+	r.Header.Del("Authorization")
+	return &authenticator.Response{User: u.info}, true, nil
+}
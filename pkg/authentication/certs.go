@@ -0,0 +1,122 @@
+package authentication
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"time"
+
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+)
+
+const certificateValidity = 365 * 24 * time.Hour
+
+// Certificates contains a CA and a serving and a client certificate signed by it, all PEM encoded.
+type Certificates struct {
+	CACert     []byte
+	ServerCert []byte
+	ServerKey  []byte
+	ClientCert []byte
+	ClientKey  []byte
+}
+
+// GenerateCertificates generates a CA, a serving certificate for localhost and the given host names and IPs and a
+// client certificate for the given user and groups.
+func GenerateCertificates(clientUser string, clientGroups []string, hosts ...string) (*Certificates, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ca key: %w", err)
+	}
+	caCert, err := cert.NewSelfSignedCACert(cert.Config{CommonName: "static-kas-ca"}, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ca certificate: %w", err)
+	}
+
+	ips, dnsNames := []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback}, []string{"localhost"}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			dnsNames = append(dnsNames, host)
+		}
+	}
+	result := &Certificates{CACert: encodeCertificate(caCert.Raw)}
+	result.ServerCert, result.ServerKey, err = signedCertificate(caCert, caKey, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "static-kas"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses: ips,
+		DNSNames:    dnsNames,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serving certificate: %w", err)
+	}
+	result.ClientCert, result.ClientKey, err = signedCertificate(caCert, caKey, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: clientUser, Organization: clientGroups},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate client certificate: %w", err)
+	}
+
+	return result, nil
+}
+
+// ServingTLSConfig returns a TLS config that serves the serving certificate and verifies client certificates
+// against the CA. A client certificate is not required, so clients can still use a token or basic auth instead.
+func (c *Certificates) ServingTLSConfig() (*tls.Config, error) {
+	servingCert, err := tls.X509KeyPair(c.ServerCert, c.ServerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load serving certificate: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(c.CACert) {
+		return nil, errors.New("failed to load ca certificate")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{servingCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}, nil
+}
+
+func signedCertificate(caCert *x509.Certificate, caKey crypto.Signer, template *x509.Certificate) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial: %w", err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = caCert.NotBefore
+	template.NotAfter = time.Now().Add(certificateValidity)
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	return encodeCertificate(der), keyPEM, nil
+}
+
+func encodeCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: cert.CertificateBlockType, Bytes: der})
+}
@@ -0,0 +1,78 @@
+package discovery
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+//go:generate go run -C ../../hack/builtin-resources . ../../pkg/discovery/zz_generated.builtin.go
+
+// builtinResource is a resource that is served without a CRD. The kubeResources are generated from the REST storage
+// of the kube-apiserver, the openshiftResources are maintained by hand.
+type builtinResource struct {
+	groupVersion string
+	name         string
+	singularName string
+	kind         string
+	namespaced   bool
+	shortNames   []string
+	categories   []string
+	verbs        []string
+}
+
+var (
+	// builtinResourcesByGroupVersion contains the built-in resources of each group version, sorted by name.
+	builtinResourcesByGroupVersion = map[string][]builtinResource{}
+	// builtinResourcesByResourceGroup contains the built-in resources keyed by resource and group the way it is used
+	// in crd names. The names and verbs of a resource are the same in all versions.
+	builtinResourcesByResourceGroup = map[string]builtinResource{}
+	// builtinRESTMapper maps all built-in kinds to their resource and scope.
+	builtinRESTMapper meta.RESTMapper
+)
+
+func init() {
+	var groupVersions []schema.GroupVersion
+	for _, resource := range append(kubeResources, openshiftResources...) {
+		if _, found := builtinResourcesByGroupVersion[resource.groupVersion]; !found {
+			groupVersion, _ := schema.ParseGroupVersion(resource.groupVersion)
+			groupVersions = append(groupVersions, groupVersion)
+		}
+		builtinResourcesByGroupVersion[resource.groupVersion] = append(builtinResourcesByGroupVersion[resource.groupVersion], resource)
+		resourceGroup, _ := splitGroupVersion(resource.name, resource.groupVersion)
+		if _, found := builtinResourcesByResourceGroup[resourceGroup]; !found {
+			builtinResourcesByResourceGroup[resourceGroup] = resource
+		}
+	}
+
+	mapper := meta.NewDefaultRESTMapper(groupVersions)
+	for _, groupVersion := range groupVersions {
+		for _, resource := range builtinResourcesByGroupVersion[groupVersion.String()] {
+			scope := meta.RESTScopeNamespace
+			if !resource.namespaced {
+				scope = meta.RESTScopeRoot
+			}
+			mapper.AddSpecific(
+				groupVersion.WithKind(resource.kind),
+				groupVersion.WithResource(resource.name),
+				groupVersion.WithResource(resource.singularName),
+				scope,
+			)
+		}
+	}
+	builtinRESTMapper = mapper
+}
+
+// builtinResourcesFor returns the built-in resources of a group version sorted by name.
+func builtinResourcesFor(groupVersion schema.GroupVersion) []metav1.APIResource {
+	var result []metav1.APIResource
+	for _, resource := range builtinResourcesByGroupVersion[groupVersion.String()] {
+		result = append(result, metav1.APIResource{
+			Name:       resource.name,
+			Kind:       resource.kind,
+			Namespaced: resource.namespaced,
+		})
+	}
+
+	return result
+}
@@ -1,12 +1,67 @@
 package discovery
 
-// TODO: Import this somehow
-var shortNameMapping = map[string][]string{
-	"pods":              {"po"},
-	"services":          {"svc"},
-	"statefulsets.apps": {"sts"},
-	"deployments.apps":  {"dep"},
-	"daemonsets.apps":   {"ds"},
-	"replicasets.apps":  {"rs"},
-	"customresourcedefinitions.apiextensions.k8s.io": {"crd", "crds"},
+import (
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/utils/pointer"
+)
+
+// openshiftResources are the listable resources of OpenShift whose CRDs are usually not part of a dump. They can not
+// be generated like the kubeResources, because the OpenShift REST storage can not be constructed without a cluster.
+// They are taken from the REST storage in pkg/<group>/apiserver/registry of github.com/openshift/openshift-apiserver
+// and github.com/openshift/oauth-apiserver (oauth.openshift.io, user.openshift.io) as of OpenShift 4.13. The
+// network.openshift.io resources and securitycontextconstraints are served from CRDs, their entries are taken from the
+// CRD manifests in github.com/openshift/api and only used if the dump doesn't contain the CRD.
+var openshiftResources = []builtinResource{
+	{groupVersion: "apps.openshift.io/v1", name: "deploymentconfigs", singularName: "deploymentconfig", kind: "DeploymentConfig", namespaced: true, shortNames: []string{"dc"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "authorization.openshift.io/v1", name: "clusterrolebindings", singularName: "clusterrolebinding", kind: "ClusterRoleBinding", namespaced: false, verbs: []string{"get", "list"}},
+	{groupVersion: "authorization.openshift.io/v1", name: "clusterroles", singularName: "clusterrole", kind: "ClusterRole", namespaced: false, verbs: []string{"get", "list"}},
+	{groupVersion: "authorization.openshift.io/v1", name: "rolebindingrestrictions", singularName: "rolebindingrestriction", kind: "RoleBindingRestriction", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "authorization.openshift.io/v1", name: "rolebindings", singularName: "rolebinding", kind: "RoleBinding", namespaced: true, verbs: []string{"get", "list"}},
+	{groupVersion: "authorization.openshift.io/v1", name: "roles", singularName: "role", kind: "Role", namespaced: true, verbs: []string{"get", "list"}},
+	{groupVersion: "build.openshift.io/v1", name: "buildconfigs", singularName: "buildconfig", kind: "BuildConfig", namespaced: true, shortNames: []string{"bc"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "build.openshift.io/v1", name: "builds", singularName: "build", kind: "Build", namespaced: true, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "image.openshift.io/v1", name: "images", singularName: "image", kind: "Image", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "image.openshift.io/v1", name: "imagestreams", singularName: "imagestream", kind: "ImageStream", namespaced: true, shortNames: []string{"is"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "image.openshift.io/v1", name: "imagestreamtags", singularName: "imagestreamtag", kind: "ImageStreamTag", namespaced: true, shortNames: []string{"istag"}, verbs: []string{"get", "list"}},
+	{groupVersion: "image.openshift.io/v1", name: "imagetags", singularName: "imagetag", kind: "ImageTag", namespaced: true, shortNames: []string{"itag"}, verbs: []string{"get", "list"}},
+	{groupVersion: "network.openshift.io/v1", name: "clusternetworks", singularName: "clusternetwork", kind: "ClusterNetwork", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "network.openshift.io/v1", name: "egressnetworkpolicies", singularName: "egressnetworkpolicy", kind: "EgressNetworkPolicy", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "network.openshift.io/v1", name: "hostsubnets", singularName: "hostsubnet", kind: "HostSubnet", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "network.openshift.io/v1", name: "netnamespaces", singularName: "netnamespace", kind: "NetNamespace", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "oauth.openshift.io/v1", name: "oauthaccesstokens", singularName: "oauthaccesstoken", kind: "OAuthAccessToken", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "oauth.openshift.io/v1", name: "oauthauthorizetokens", singularName: "oauthauthorizetoken", kind: "OAuthAuthorizeToken", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "oauth.openshift.io/v1", name: "oauthclientauthorizations", singularName: "oauthclientauthorization", kind: "OAuthClientAuthorization", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "oauth.openshift.io/v1", name: "oauthclients", singularName: "oauthclient", kind: "OAuthClient", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "oauth.openshift.io/v1", name: "useroauthaccesstokens", singularName: "useroauthaccesstoken", kind: "UserOAuthAccessToken", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "project.openshift.io/v1", name: "projects", singularName: "project", kind: "Project", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "quota.openshift.io/v1", name: "appliedclusterresourcequotas", singularName: "appliedclusterresourcequota", kind: "AppliedClusterResourceQuota", namespaced: true, verbs: []string{"get", "list"}},
+	{groupVersion: "quota.openshift.io/v1", name: "clusterresourcequotas", singularName: "clusterresourcequota", kind: "ClusterResourceQuota", namespaced: false, shortNames: []string{"clusterquota"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "route.openshift.io/v1", name: "routes", singularName: "route", kind: "Route", namespaced: true, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "security.openshift.io/v1", name: "rangeallocations", singularName: "rangeallocation", kind: "RangeAllocation", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "security.openshift.io/v1", name: "securitycontextconstraints", singularName: "securitycontextconstraints", kind: "SecurityContextConstraints", namespaced: false, shortNames: []string{"scc"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "template.openshift.io/v1", name: "brokertemplateinstances", singularName: "brokertemplateinstance", kind: "BrokerTemplateInstance", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "template.openshift.io/v1", name: "templateinstances", singularName: "templateinstance", kind: "TemplateInstance", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "template.openshift.io/v1", name: "templates", singularName: "template", kind: "Template", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "user.openshift.io/v1", name: "groups", singularName: "group", kind: "Group", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "user.openshift.io/v1", name: "identities", singularName: "identity", kind: "Identity", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "user.openshift.io/v1", name: "users", singularName: "user", kind: "User", namespaced: false, verbs: []string{"get", "list", "watch"}},
+}
+
+// SelfSubjectReviewVersions are the versions of authentication.k8s.io in which SelfSubjectReviews are served
+var SelfSubjectReviewVersions = []string{"v1", "v1beta1", "v1alpha1"}
+
+// scaleSubresourceMapping contains the in-tree resources that have a scale subresource
+var scaleSubresourceMapping = map[string]*apiextensionsv1.CustomResourceSubresourceScale{
+	"deployments.apps":       specReplicasScale(),
+	"replicasets.apps":       specReplicasScale(),
+	"statefulsets.apps":      specReplicasScale(),
+	"replicationcontrollers": specReplicasScale(),
+}
+
+func specReplicasScale() *apiextensionsv1.CustomResourceSubresourceScale {
+	return &apiextensionsv1.CustomResourceSubresourceScale{
+		SpecReplicasPath:   ".spec.replicas",
+		StatusReplicasPath: ".status.replicas",
+		LabelSelectorPath:  pointer.String(".spec.selector"),
+	}
 }
@@ -0,0 +1,305 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/alvaroaleman/static-kas/pkg/response"
+)
+
+// ProblemType is the type of a problem found in a dump.
+type ProblemType string
+
+const (
+	ProblemUnreadableFile    ProblemType = "UnreadableFile"
+	ProblemParseError        ProblemType = "ParseError"
+	ProblemUnknownKind       ProblemType = "UnknownKind"
+	ProblemEmptyFile         ProblemType = "EmptyFile"
+	ProblemEmptyList         ProblemType = "EmptyList"
+	ProblemDuplicateObject   ProblemType = "DuplicateObject"
+	ProblemNamespaceMismatch ProblemType = "NamespaceMismatch"
+	// ProblemUnknownResourceName is reported for files with a single object if the resource can not be derived
+	// from the directories the file is in.
+	ProblemUnknownResourceName ProblemType = "UnknownResourceName"
+)
+
+// Severity is the severity of a problem. Errors mean that content of the dump can not be served, warnings that
+// it might be served differently than expected.
+type Severity string
+
+const (
+	SeverityError   Severity = "Error"
+	SeverityWarning Severity = "Warning"
+)
+
+// Problem is a problem found in a dump.
+type Problem struct {
+	Type     ProblemType `json:"type"`
+	Severity Severity    `json:"severity"`
+	// Path is relative to the base dir of the dump.
+	Path string `json:"path"`
+	// Line is only set for parse errors that have one.
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// Report is the result of diagnosing a dump.
+type Report struct {
+	Problems []Problem `json:"problems"`
+}
+
+// HasErrors returns true if the report contains at least one problem of severity error.
+func (r *Report) HasErrors() bool {
+	for _, problem := range r.Problems {
+		if problem.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Diagnose walks a dump and reports everything that prevents it from being served or makes it being served
+// differently than one would expect from looking at the files. namespaceDirs maps namespaces that are served from
+// outside of the dump to their directory.
+func Diagnose(basePath string, namespaceDirs map[string]string) (*Report, error) {
+	if _, err := os.Stat(basePath); err != nil && len(namespaceDirs) == 0 {
+		return nil, fmt.Errorf("failed to stat %s: %w", basePath, err)
+	}
+	_, _, _, problems := discover(zap.NewNop(), basePath, namespaceDirs)
+
+	return problems.report, nil
+}
+
+// DiagnoseManifests reports the problems of files with objects of any kind that are not in the must-gather layout,
+// with paths relative to basePath. read returns the objects of a file, its errors are reported as parse errors with
+// the line they name unless the file can not be read at all.
+func DiagnoseManifests(basePath string, files []string, read func(path string) ([]unstructured.Unstructured, error)) *Report {
+	problems := newProblemRecorder()
+	crds := map[string]*apiextensionsv1.CustomResourceDefinition{}
+	for _, file := range files {
+		relativePath, err := filepath.Rel(basePath, file)
+		if err != nil {
+			relativePath = file
+		}
+		objects, err := read(file)
+		if err != nil {
+			problemType := ProblemParseError
+			if errors.As(err, new(*fs.PathError)) {
+				problemType = ProblemUnreadableFile
+			}
+			problems.fail(problemType, relativePath, err)
+			continue
+		}
+		if len(objects) == 0 {
+			problems.add(ProblemEmptyFile, SeverityWarning, relativePath, 0, "file contains no objects")
+			continue
+		}
+		for _, object := range objects {
+			if object.GroupVersionKind() != apiextensionsv1.SchemeGroupVersion.WithKind("CustomResourceDefinition") {
+				continue
+			}
+			crd := &apiextensionsv1.CustomResourceDefinition{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, crd); err != nil {
+				problems.fail(ProblemParseError, relativePath, fmt.Errorf("failed to convert crd %s: %w", object.GetName(), err))
+				continue
+			}
+			crds[crd.Name] = crd
+		}
+		problems.addObjects(relativePath, objects)
+	}
+	problems.finish(crds)
+
+	return problems.report
+}
+
+var yamlLineRegex = regexp.MustCompile(`line (\d+):`)
+
+// problemRecorder collects the problems discover finds in a dump. Problems are recorded concurrently, the checks
+// that depend on the order of the objects are done by finish once all files were read.
+type problemRecorder struct {
+	lock   sync.Mutex
+	report *Report
+	// errs are the problems that make the discovery fail
+	errs []error
+	// objects are all objects of the dump in the order they appear in their file
+	objects []objectRef
+}
+
+// objectRef is what is needed to tell duplicate objects apart without holding them in memory.
+type objectRef struct {
+	path            string
+	gvk             schema.GroupVersionKind
+	namespace       string
+	name            string
+	uid             types.UID
+	created         metav1.Time
+	resourceVersion string
+}
+
+func newProblemRecorder() *problemRecorder {
+	return &problemRecorder{report: &Report{Problems: []Problem{}}}
+}
+
+// add records a problem that doesn't make the discovery fail.
+func (r *problemRecorder) add(problemType ProblemType, severity Severity, path string, line int, message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.report.add(problemType, severity, path, line, message)
+}
+
+// fail records a problem of severity error that makes the discovery fail.
+func (r *problemRecorder) fail(problemType ProblemType, path string, err error) {
+	var line int
+	if problemType == ProblemParseError {
+		if match := yamlLineRegex.FindStringSubmatch(err.Error()); match != nil {
+			line, _ = strconv.Atoi(match[1])
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.report.add(problemType, SeverityError, path, line, err.Error())
+	r.errs = append(r.errs, err)
+}
+
+// addObjects checks the objects of a file whose checks don't depend on other files and remembers them for finish.
+func (r *problemRecorder) addObjects(path string, objects []unstructured.Unstructured) {
+	directoryNamespace := namespaceFromPath(path)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, object := range objects {
+		if directoryNamespace != "" && object.GetNamespace() != "" && object.GetNamespace() != directoryNamespace {
+			r.report.add(ProblemNamespaceMismatch, SeverityError, path, 0,
+				fmt.Sprintf("%s %s has namespace %s but is in the directory of namespace %s", object.GetKind(), objectName(object), object.GetNamespace(), directoryNamespace))
+		}
+		r.objects = append(r.objects, objectRef{
+			path:            path,
+			gvk:             object.GroupVersionKind(),
+			namespace:       object.GetNamespace(),
+			name:            object.GetName(),
+			uid:             object.GetUID(),
+			created:         object.GetCreationTimestamp(),
+			resourceVersion: object.GetResourceVersion(),
+		})
+	}
+}
+
+// finish reports unknown kinds and duplicate objects and sorts the problems. Files are processed in the order of
+// their path, so the result doesn't depend on the order in which they were read.
+func (r *problemRecorder) finish(crds map[string]*apiextensionsv1.CustomResourceDefinition) {
+	sort.SliceStable(r.objects, func(i, j int) bool {
+		return r.objects[i].path < r.objects[j].path
+	})
+
+	// objectKey -> the newest representation of the object seen so far
+	seenObjects := map[string]objectRef{}
+	unknownKinds := map[schema.GroupVersionKind]bool{}
+	for _, object := range r.objects {
+		if object.gvk.Kind == "" {
+			r.report.add(ProblemUnknownKind, SeverityWarning, object.path, 0, fmt.Sprintf("object %s has no kind", object.displayName()))
+		} else if !unknownKinds[object.gvk] && !isKnownKind(object.gvk, crds) {
+			unknownKinds[object.gvk] = true
+			r.report.add(ProblemUnknownKind, SeverityWarning, object.path, 0, fmt.Sprintf("%s is neither a built-in kind nor defined by a CRD in the dump", object.gvk.String()))
+		}
+
+		key := strings.Join([]string{object.gvk.Group, object.gvk.Kind, object.namespace, object.name}, "/")
+		first, duplicate := seenObjects[key]
+		if !duplicate {
+			seenObjects[key] = object
+			continue
+		}
+		location := "more than once in this file"
+		if first.path != object.path {
+			location = "also in " + first.path
+		}
+		served := first
+		if object.isNewer(first) {
+			served = object
+			seenObjects[key] = object
+		}
+		message := fmt.Sprintf("%s %s is contained %s, the one with resourceVersion %q is served", object.gvk.Kind, object.displayName(), location, served.resourceVersion)
+		if object.uid != first.uid {
+			message += ", they have different UIDs"
+		}
+		r.report.add(ProblemDuplicateObject, SeverityWarning, object.path, 0, message)
+	}
+	r.objects = nil
+
+	sort.SliceStable(r.report.Problems, func(i, j int) bool {
+		return r.report.Problems[i].Path < r.report.Problems[j].Path
+	})
+}
+
+func (o objectRef) displayName() string {
+	if o.namespace == "" {
+		return o.name
+	}
+
+	return o.namespace + "/" + o.name
+}
+
+func (o objectRef) isNewer(other objectRef) bool {
+	return response.IsNewer(o.metadata(), other.metadata())
+}
+
+// metadata returns an object with the fields response.IsNewer compares.
+func (o objectRef) metadata() *unstructured.Unstructured {
+	result := &unstructured.Unstructured{}
+	result.SetUID(o.uid)
+	result.SetCreationTimestamp(o.created)
+	result.SetResourceVersion(o.resourceVersion)
+
+	return result
+}
+
+func (r *Report) add(problemType ProblemType, severity Severity, path string, line int, message string) {
+	r.Problems = append(r.Problems, Problem{Type: problemType, Severity: severity, Path: path, Line: line, Message: message})
+}
+
+// namespaceFromPath returns the namespace of the directory a file is in or an empty string if it is not in a
+// namespace directory.
+func namespaceFromPath(relativePath string) string {
+	split := strings.Split(relativePath, string(filepath.Separator))
+	if len(split) < 3 || split[0] != "namespaces" {
+		return ""
+	}
+
+	return split[1]
+}
+
+func isKnownKind(gvk schema.GroupVersionKind, crds map[string]*apiextensionsv1.CustomResourceDefinition) bool {
+	if _, err := builtinRESTMapper.RESTMapping(gvk.GroupKind(), gvk.Version); err == nil {
+		return true
+	}
+	for _, crd := range crds {
+		if crd.Spec.Group == gvk.Group && crd.Spec.Names.Kind == gvk.Kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+func objectName(object unstructured.Unstructured) string {
+	if object.GetNamespace() == "" {
+		return object.GetName()
+	}
+
+	return object.GetNamespace() + "/" + object.GetName()
+}
@@ -5,157 +5,212 @@ import (
 	"fmt"
 	"io/fs"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 
 	"go.uber.org/zap"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/yaml"
 
 	"github.com/alvaroaleman/static-kas/pkg/response"
 )
 
-func Discover(l *zap.Logger, basePath string) (map[string]*metav1.APIResourceList, map[GroupVersionResource]metav1.APIResource, map[string]*apiextensionsv1.CustomResourceDefinition, error) {
+// Discover discovers the api resources of the dump in basePath. namespaceDirs maps namespaces that are served from
+// outside of the dump to their directory.
+// Unreadable and unparsable files make it fail, Diagnose reports all problems.
+func Discover(l *zap.Logger, basePath string, namespaceDirs map[string]string) (map[string]*metav1.APIResourceList, map[GroupVersionResource]metav1.APIResource, map[string]*apiextensionsv1.CustomResourceDefinition, error) {
+	result, apiResources, crdMap, problems := discover(l, basePath, namespaceDirs)
+	return result, apiResources, crdMap, utilerrors.NewAggregate(problems.errs)
+}
+
+// discover discovers the api resources of a dump and records the problems it finds on the way.
+func discover(l *zap.Logger, basePath string, namespaceDirs map[string]string) (map[string]*metav1.APIResourceList, map[GroupVersionResource]metav1.APIResource, map[string]*apiextensionsv1.CustomResourceDefinition, *problemRecorder) {
+	problems := newProblemRecorder()
 	// explicitly read crds first, so we can insert the shortnames we find there into discovery
 	crdMap, err := getCRDs(basePath)
 	if err != nil {
 		// This shouldn't make us fail
 		l.Warn("encountered errors reading crds", zap.Error(err))
+		problems.add(ProblemParseError, SeverityError, filepath.Join("cluster-scoped-resources", "apiextensions.k8s.io", "customresourcedefinitions"), 0, err.Error())
 	}
-	errs := errorGroup{}
 	result := map[string]*metav1.APIResourceList{}
 	apiResources := map[GroupVersionResource]metav1.APIResource{}
+	scopeConflicts := sets.NewString()
 	lock := sync.Mutex{}
 	wg := sync.WaitGroup{}
 
 	// Limit the concurency somewhat to avoid hitting the open files ulimit
 	concurency := make(chan struct{}, 500)
 
-	filepath.WalkDir(basePath, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			errs.add(fmt.Errorf("error walking at %s: %w", path, err))
-			return nil
-		}
-		if d.IsDir() || !strings.HasSuffix(d.Name(), ".yaml") {
-			return nil
+	// root dir -> path of the root dir relative to the base path
+	roots := map[string]string{basePath: ""}
+	for namespace, dir := range namespaceDirs {
+		roots[dir] = filepath.Join("namespaces", namespace)
+	}
+	for root, relativeRoot := range roots {
+		if _, err := os.Stat(root); os.IsNotExist(err) && root == basePath && len(namespaceDirs) > 0 {
+			// A hosted cluster of which only the control plane was dumped
+			continue
 		}
-		wg.Add(1)
-		// TODO: Optimize by stopping here if the group and object are already discovered
-		// this likely requires to key the map by group and not by groupVersion
-		go func() {
-			concurency <- struct{}{}
-			defer wg.Done()
-			defer func() { <-concurency }()
-			raw, err := ioutil.ReadFile(path)
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			relativePath, relErr := filepath.Rel(root, path)
+			if relErr != nil {
+				problems.fail(ProblemUnreadableFile, path, fmt.Errorf("failed to get path of %s relative to %s: %w", path, root, relErr))
+				return nil
+			}
+			relativePath = filepath.Join(relativeRoot, relativePath)
 			if err != nil {
-				errs.add(fmt.Errorf("failed to read file %s: %w", path, err))
-				return
+				problems.fail(ProblemUnreadableFile, relativePath, fmt.Errorf("error walking at %s: %w", path, err))
+				return nil
 			}
-
-			if len(raw) == 0 {
-				return
+			if d.IsDir() && isNestedDump(root, path) {
+				return filepath.SkipDir
 			}
-
-			u := &unstructured.Unstructured{}
-			if err := yaml.Unmarshal(raw, u); err != nil {
-				errs.add(fmt.Errorf("failed to decode %s into an unstructured: %w", path, err))
-				return
+			if d.IsDir() || !strings.HasSuffix(d.Name(), ".yaml") {
+				return nil
 			}
-			items, found, err := unstructured.NestedSlice(u.Object, "items")
-			if err != nil {
-				// It's a list but has no entries
-				if err.Error() == ".items accessor error: <nil> is of the type <nil>, expected []interface{}" {
+			wg.Add(1)
+			// TODO: Optimize by stopping here if the group and object are already discovered
+			// this likely requires to key the map by group and not by groupVersion
+			go func() {
+				concurency <- struct{}{}
+				defer wg.Done()
+				defer func() { <-concurency }()
+				raw, err := ioutil.ReadFile(path)
+				if err != nil {
+					problems.fail(ProblemUnreadableFile, relativePath, fmt.Errorf("failed to read file %s: %w", path, err))
 					return
 				}
-				errs.add(fmt.Errorf("items field for file %s was not a slice: %w", path, err))
-				return
-			}
 
-			var name, kind, groupVersion string
-			if found {
-				if len(items) < 1 {
+				if len(raw) == 0 {
+					problems.add(ProblemEmptyFile, SeverityWarning, relativePath, 0, "file is empty")
 					return
 				}
-				// If we find a list, the resouce name is simply the filename without the yaml suffix
-				name = strings.TrimSuffix(d.Name(), ".yaml")
-				kind, _, _ = unstructured.NestedString(items[0].(map[string]interface{}), "kind")
 
-				if kind == "" {
-					kind = strings.TrimSuffix(u.GetKind(), "List")
+				u := &unstructured.Unstructured{}
+				if err := yaml.Unmarshal(raw, u); err != nil {
+					problems.fail(ProblemParseError, relativePath, fmt.Errorf("failed to decode %s into an unstructured: %w", path, err))
+					return
+				}
+				items, found, err := unstructured.NestedSlice(u.Object, "items")
+				if err != nil {
+					// It's a list but has no entries
+					if err.Error() == ".items accessor error: <nil> is of the type <nil>, expected []interface{}" {
+						problems.add(ProblemEmptyList, SeverityWarning, relativePath, 0, "list has no items")
+						return
+					}
+					problems.fail(ProblemParseError, relativePath, fmt.Errorf("items field for file %s was not a slice: %w", path, err))
+					return
 				}
 
-				groupVersion, _, _ = unstructured.NestedString(items[0].(map[string]interface{}), "apiVersion")
-
-				if groupVersion == "" {
+				var name, kind, groupVersion string
+				objects := []unstructured.Unstructured{*u}
+				if found {
+					if len(items) < 1 {
+						problems.add(ProblemEmptyList, SeverityWarning, relativePath, 0, "list has no items")
+						return
+					}
+					objects = objects[:0]
+					for idx, item := range items {
+						object, ok := item.(map[string]interface{})
+						if !ok {
+							problems.fail(ProblemParseError, relativePath, fmt.Errorf("item %d of %s is a %T, not an object", idx, path, item))
+							return
+						}
+						item := unstructured.Unstructured{Object: object}
+						if item.GetKind() == "" {
+							item.SetKind(strings.TrimSuffix(u.GetKind(), "List"))
+						}
+						if item.GetAPIVersion() == "" {
+							item.SetAPIVersion(u.GetAPIVersion())
+						}
+						objects = append(objects, item)
+					}
+					// If we find a list, the resouce name is simply the filename without the yaml suffix
+					name = strings.TrimSuffix(d.Name(), ".yaml")
+					kind = objects[0].GetKind()
+					groupVersion = objects[0].GetAPIVersion()
+				} else {
+					pathElements := strings.Split(relativePath, string(filepath.Separator))
+					fileNameWithoutSuffix := strings.TrimSuffix(d.Name(), ".yaml")
+					// If we find a single object, the resource name is the name of the first parent folder that is not also the name
+					// of the object (pods are nested in a pods/$podname/$podname.yaml structure for some reason)
+					for i := len(pathElements) - 2; i >= 0; i-- {
+						if pathElements[i] != fileNameWithoutSuffix {
+							name = pathElements[i]
+							break
+						}
+					}
+					kind = u.GetKind()
 					groupVersion = u.GetAPIVersion()
 				}
-			} else {
-				pathElements := strings.Split(path, "/")
-				// Should never happen(tm)
-				if len(pathElements) < 2 {
+				if name == "" {
+					l.Error("Couldn't discover resource name for resource in path, ignoring", zap.String("path", path))
+					problems.add(ProblemUnknownResourceName, SeverityError, relativePath, 0, "the resource can not be derived from the directories the file is in, the object is not served")
 					return
 				}
-				fileNameWithoutSuffix := strings.TrimSuffix(d.Name(), ".yaml")
-				// If we find a single object, the resource name is the name of the first parent folder that is not also the name
-				// of the object (pods are nested in a pods/$podname/$podname.yaml structure for some reason)
-				for i := len(pathElements) - 2; i >= 0; i-- {
-					if pathElements[i] != fileNameWithoutSuffix {
-						name = pathElements[i]
-						break
+				problems.addObjects(relativePath, objects)
+
+				// The path is only a heuristic, the scope from the CRD or the scheme is authoritative if we have one
+				namespaced := kind != "Namespace" && strings.HasPrefix(relativePath, "namespaces"+string(filepath.Separator))
+
+				lock.Lock()
+				defer lock.Unlock()
+				if authoritative, known := namespacedFor(name, groupVersion, kind, crdMap); known {
+					if authoritative != namespaced && !scopeConflicts.Has(groupVersion+"/"+name) {
+						scopeConflicts.Insert(groupVersion + "/" + name)
+						l.Warn("Scope from path differs from scope of resource, using scope of resource",
+							zap.String("path", path),
+							zap.String("groupVersion", groupVersion),
+							zap.String("resource", name),
+							zap.Bool("namespaced", authoritative),
+						)
 					}
+					namespaced = authoritative
 				}
-				kind = u.GetKind()
-				groupVersion = u.GetAPIVersion()
-			}
-			namespaced := kind != "Namespace" && strings.Contains(path, "namespaces/")
+				addResource(result, apiResources, groupVersion, name, kind, namespaced, crdMap)
+			}()
 
-			if name == "" {
-				l.Error("Couldn't discover resource name for resource in path, ignoring", zap.String("path", path))
-				return
-			}
+			return nil
+		})
+	}
 
-			lock.Lock()
-			defer lock.Unlock()
+	wg.Wait()
 
-			if _, hasEntry := result[groupVersion]; !hasEntry {
-				result[groupVersion] = &metav1.APIResourceList{
-					GroupVersion: groupVersion,
-				}
-				if groupVersion == "v1" {
-					result[groupVersion].APIResources = append(result[groupVersion].APIResources, metav1.APIResource{
-						Name:       "namespaces",
-						Kind:       "Namespace",
-						Verbs:      []string{"get", "list"},
-						ShortNames: []string{"ns"},
-					})
-				}
+	// Advertise resources that are served but of which no instances were dumped
+	for _, crd := range crdMap {
+		for _, version := range crd.Spec.Versions {
+			if !version.Served {
+				continue
 			}
-			for _, resource := range result[groupVersion].APIResources {
-				// Entry for our resource already exist, nothing to do
-				if resource.Name == name {
-					return
-				}
+			groupVersion := crd.Spec.Group + "/" + version.Name
+			addResource(result, apiResources, groupVersion, crd.Spec.Names.Plural, crd.Spec.Names.Kind, crd.Spec.Scope == apiextensionsv1.NamespaceScoped, crdMap)
+		}
+	}
+	apiServices, err := response.ReadAndDeserializeList(filepath.Join(basePath, "cluster-scoped-resources", "apiregistration.k8s.io"), "apiservices")
+	if err != nil {
+		problems.fail(ProblemParseError, filepath.Join("cluster-scoped-resources", "apiregistration.k8s.io", "apiservices"), fmt.Errorf("failed to read apiservices: %w", err))
+	} else {
+		for _, apiService := range apiServices.Items {
+			group, _, _ := unstructured.NestedString(apiService.Object, "spec", "group")
+			version, _, _ := unstructured.NestedString(apiService.Object, "spec", "version")
+			if group == "" || version == "" {
+				continue
 			}
-
-			resource := metav1.APIResource{
-				Name:       name,
-				Namespaced: namespaced,
-				Kind:       kind,
-				Verbs:      []string{"get", "list", "watch"},
-				ShortNames: shortNamesFor(name, groupVersion, crdMap),
+			groupVersion := group + "/" + version
+			for _, resource := range builtinResourcesFor(schema.GroupVersion{Group: group, Version: version}) {
+				addResource(result, apiResources, groupVersion, resource.Name, resource.Kind, resource.Namespaced, crdMap)
 			}
-			result[groupVersion].APIResources = append(result[groupVersion].APIResources, resource)
-			apiResources[GroupVersionResource{GroupVersion: groupVersion, Resource: name}] = resource
-		}()
-
-		return nil
-	})
-
-	wg.Wait()
+		}
+	}
 
 	if result["authorization.k8s.io/v1"] == nil {
 		result["authorization.k8s.io/v1"] = &metav1.APIResourceList{
@@ -167,19 +222,115 @@ func Discover(l *zap.Logger, basePath string) (map[string]*metav1.APIResourceLis
 		Namespaced: false,
 		Kind:       "SelfSubjectAccessReview",
 		Verbs:      []string{"create"},
+	}, metav1.APIResource{
+		Name:       "selfsubjectrulesreviews",
+		Namespaced: false,
+		Kind:       "SelfSubjectRulesReview",
+		Verbs:      []string{"create"},
+	}, metav1.APIResource{
+		Name:       "subjectaccessreviews",
+		Namespaced: false,
+		Kind:       "SubjectAccessReview",
+		Verbs:      []string{"create"},
+	}, metav1.APIResource{
+		Name:       "localsubjectaccessreviews",
+		Namespaced: true,
+		Kind:       "LocalSubjectAccessReview",
+		Verbs:      []string{"create"},
 	})
-	return result, apiResources, crdMap, utilerrors.NewAggregate(errs.errs)
+	for _, version := range SelfSubjectReviewVersions {
+		groupVersion := "authentication.k8s.io/" + version
+		if result[groupVersion] == nil {
+			result[groupVersion] = &metav1.APIResourceList{
+				GroupVersion: groupVersion,
+			}
+		}
+		result[groupVersion].APIResources = append(result[groupVersion].APIResources, metav1.APIResource{
+			Name:       "selfsubjectreviews",
+			Namespaced: false,
+			Kind:       "SelfSubjectReview",
+			Verbs:      []string{"create"},
+		})
+	}
+	problems.finish(crdMap)
+
+	return result, apiResources, crdMap, problems
 }
 
-type errorGroup struct {
-	errs []error
-	lock sync.Mutex
+// addResource adds a resource including its subresources to discovery unless it is already present.
+func addResource(
+	result map[string]*metav1.APIResourceList,
+	apiResources map[GroupVersionResource]metav1.APIResource,
+	groupVersion string,
+	name string,
+	kind string,
+	namespaced bool,
+	crdMap map[string]*apiextensionsv1.CustomResourceDefinition,
+) {
+	if _, hasEntry := result[groupVersion]; !hasEntry {
+		result[groupVersion] = &metav1.APIResourceList{
+			GroupVersion: groupVersion,
+		}
+		if groupVersion == "v1" {
+			namespaceNames := namesFor("namespaces", groupVersion, "Namespace", crdMap)
+			result[groupVersion].APIResources = append(result[groupVersion].APIResources, metav1.APIResource{
+				Name:         "namespaces",
+				SingularName: namespaceNames.singular,
+				Kind:         "Namespace",
+				Verbs:        verbsFor("namespaces", groupVersion),
+				ShortNames:   namespaceNames.shortNames,
+				Categories:   namespaceNames.categories,
+			}, metav1.APIResource{
+				Name:  "namespaces/status",
+				Kind:  "Namespace",
+				Verbs: []string{"get"},
+			})
+		}
+	}
+	for _, resource := range result[groupVersion].APIResources {
+		// Entry for our resource already exist, nothing to do
+		if resource.Name == name {
+			return
+		}
+	}
+
+	names := namesFor(name, groupVersion, kind, crdMap)
+	resource := metav1.APIResource{
+		Name:         name,
+		SingularName: names.singular,
+		Namespaced:   namespaced,
+		Kind:         kind,
+		Verbs:        verbsFor(name, groupVersion),
+		ShortNames:   names.shortNames,
+		Categories:   names.categories,
+	}
+	result[groupVersion].APIResources = append(result[groupVersion].APIResources, resource, metav1.APIResource{
+		Name:       name + "/status",
+		Namespaced: namespaced,
+		Kind:       kind,
+		Verbs:      []string{"get"},
+	})
+	if _, scalable := ScaleSubresource(name, groupVersion, crdMap); scalable {
+		result[groupVersion].APIResources = append(result[groupVersion].APIResources, metav1.APIResource{
+			Name:       name + "/scale",
+			Namespaced: namespaced,
+			Group:      "autoscaling",
+			Version:    "v1",
+			Kind:       "Scale",
+			Verbs:      []string{"get", "patch", "update"},
+		})
+	}
+	apiResources[GroupVersionResource{GroupVersion: groupVersion, Resource: name}] = resource
 }
 
-func (e *errorGroup) add(err error) {
-	e.lock.Lock()
-	defer e.lock.Unlock()
-	e.errs = append(e.errs, err)
+// isNestedDump returns true if path is a directory directly below the base path of a dump that is a dump on its own,
+// like the dump of a hosted cluster inside the dump of its management cluster.
+func isNestedDump(basePath, path string) bool {
+	if filepath.Dir(path) != filepath.Clean(basePath) {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(path, "namespaces"))
+	return err == nil && info.IsDir()
 }
 
 type GroupVersionResource struct {
@@ -212,24 +363,106 @@ func getCRDs(basePath string) (map[string]*apiextensionsv1.CustomResourceDefinit
 	return result, utilerrors.NewAggregate(errs)
 }
 
-func shortNamesFor(resource string, groupVersion string, crds map[string]*apiextensionsv1.CustomResourceDefinition) []string {
-	var group string
-	if split := strings.Split(groupVersion, "/"); len(split) == 2 {
-		group = split[0]
+// names are the alternative names a resource can be referred to by.
+type names struct {
+	shortNames []string
+	categories []string
+	singular   string
+}
+
+// namesFor returns the short names, categories and singular name of a resource. They are read from the table of
+// built-in resources or from the CRD for custom resources.
+func namesFor(resource, groupVersion, kind string, crds map[string]*apiextensionsv1.CustomResourceDefinition) names {
+	resourceGroup, _ := splitGroupVersion(resource, groupVersion)
+	result := names{singular: strings.ToLower(kind)}
+
+	if crd, found := crds[resourceGroup]; found {
+		result.shortNames, result.categories = crd.Spec.Names.ShortNames, crd.Spec.Names.Categories
+		if crd.Spec.Names.Singular != "" {
+			result.singular = crd.Spec.Names.Singular
+		}
+		return result
+	}
+
+	if builtin, found := builtinResourcesByResourceGroup[resourceGroup]; found {
+		result.shortNames, result.categories, result.singular = builtin.shortNames, builtin.categories, builtin.singularName
+	}
+
+	return result
+}
+
+// namespacedFor returns if a resource is namespaced according to its CRD or the built-in RESTMapper and if
+// either of them knows the resource.
+func namespacedFor(resource, groupVersion, kind string, crds map[string]*apiextensionsv1.CustomResourceDefinition) (namespaced bool, known bool) {
+	resourceGroup, version := splitGroupVersion(resource, groupVersion)
+	if crd, found := crds[resourceGroup]; found {
+		return crd.Spec.Scope == apiextensionsv1.NamespaceScoped, true
+	}
+
+	gv, err := schema.ParseGroupVersion(groupVersion)
+	if err != nil {
+		return false, false
+	}
+	mapping, err := builtinRESTMapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: kind}, version)
+	if err != nil {
+		return false, false
+	}
+
+	return mapping.Scope.Name() == meta.RESTScopeNameNamespace, true
+}
+
+// ResourceFor returns the resource of a kind and whether it is namespaced. Kinds defined by one of the crds or
+// built-in are looked up, for all others the resource is guessed from the kind and known is false.
+func ResourceFor(gvk schema.GroupVersionKind, crds map[string]*apiextensionsv1.CustomResourceDefinition) (resource string, namespaced bool, known bool) {
+	for _, crd := range crds {
+		if crd.Spec.Group == gvk.Group && crd.Spec.Names.Kind == gvk.Kind {
+			return crd.Spec.Names.Plural, crd.Spec.Scope == apiextensionsv1.NamespaceScoped, true
+		}
+	}
+	if mapping, err := builtinRESTMapper.RESTMapping(gvk.GroupKind(), gvk.Version); err == nil {
+		return mapping.Resource.Resource, mapping.Scope.Name() == meta.RESTScopeNameNamespace, true
 	}
-	resourceGroup := resource
-	if group != "" {
-		resourceGroup += "." + group
+	plural, _ := meta.UnsafeGuessKindToResource(gvk)
+
+	return plural.Resource, false, false
+}
+
+// verbsFor returns the verbs we serve for a resource, which are get, list and watch unless the built-in resource
+// doesn't support them.
+func verbsFor(resource, groupVersion string) []string {
+	resourceGroup, _ := splitGroupVersion(resource, groupVersion)
+	if builtin, found := builtinResourcesByResourceGroup[resourceGroup]; found {
+		return builtin.verbs
 	}
 
-	// TODO: We should try to import this from k/k
-	if staticMappingVal, found := shortNameMapping[resourceGroup]; found {
-		return staticMappingVal
+	return []string{"get", "list", "watch"}
+}
+
+// ScaleSubresource returns the paths of the scale subresource of the given resource and if it has one.
+func ScaleSubresource(resource string, groupVersion string, crds map[string]*apiextensionsv1.CustomResourceDefinition) (*apiextensionsv1.CustomResourceSubresourceScale, bool) {
+	resourceGroup, version := splitGroupVersion(resource, groupVersion)
+	if staticMappingVal, found := scaleSubresourceMapping[resourceGroup]; found {
+		return staticMappingVal, true
 	}
 
 	if crd, found := crds[resourceGroup]; found {
-		return crd.Spec.Names.ShortNames
+		for _, crdVersion := range crd.Spec.Versions {
+			if crdVersion.Name == version && crdVersion.Subresources != nil && crdVersion.Subresources.Scale != nil {
+				return crdVersion.Subresources.Scale, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// splitGroupVersion returns the resource qualified by its group the way it is used in crd names and the version.
+func splitGroupVersion(resource string, groupVersion string) (string, string) {
+	version := groupVersion
+	if split := strings.Split(groupVersion, "/"); len(split) == 2 {
+		resource += "." + split[0]
+		version = split[1]
 	}
 
-	return nil
+	return resource, version
 }
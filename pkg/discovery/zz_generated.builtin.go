@@ -0,0 +1,83 @@
+// Code generated by hack/builtin-resources. DO NOT EDIT.
+
+package discovery
+
+// kubeResources are the listable resources of the kube-apiserver, the kube-aggregator and the
+// apiextensions-apiserver in all versions they can serve.
+var kubeResources = []builtinResource{
+	{groupVersion: "admissionregistration.k8s.io/v1", name: "mutatingwebhookconfigurations", singularName: "mutatingwebhookconfiguration", kind: "MutatingWebhookConfiguration", namespaced: false, categories: []string{"api-extensions"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "admissionregistration.k8s.io/v1", name: "validatingwebhookconfigurations", singularName: "validatingwebhookconfiguration", kind: "ValidatingWebhookConfiguration", namespaced: false, categories: []string{"api-extensions"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "admissionregistration.k8s.io/v1alpha1", name: "validatingadmissionpolicies", singularName: "validatingadmissionpolicy", kind: "ValidatingAdmissionPolicy", namespaced: false, categories: []string{"api-extensions"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "admissionregistration.k8s.io/v1alpha1", name: "validatingadmissionpolicybindings", singularName: "validatingadmissionpolicybinding", kind: "ValidatingAdmissionPolicyBinding", namespaced: false, categories: []string{"api-extensions"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "apiextensions.k8s.io/v1", name: "customresourcedefinitions", singularName: "customresourcedefinition", kind: "CustomResourceDefinition", namespaced: false, shortNames: []string{"crd", "crds"}, categories: []string{"api-extensions"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "apiregistration.k8s.io/v1", name: "apiservices", singularName: "apiservice", kind: "APIService", namespaced: false, categories: []string{"api-extensions"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "apps/v1", name: "controllerrevisions", singularName: "controllerrevision", kind: "ControllerRevision", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "apps/v1", name: "daemonsets", singularName: "daemonset", kind: "DaemonSet", namespaced: true, shortNames: []string{"ds"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "apps/v1", name: "deployments", singularName: "deployment", kind: "Deployment", namespaced: true, shortNames: []string{"deploy"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "apps/v1", name: "replicasets", singularName: "replicaset", kind: "ReplicaSet", namespaced: true, shortNames: []string{"rs"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "apps/v1", name: "statefulsets", singularName: "statefulset", kind: "StatefulSet", namespaced: true, shortNames: []string{"sts"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "autoscaling/v1", name: "horizontalpodautoscalers", singularName: "horizontalpodautoscaler", kind: "HorizontalPodAutoscaler", namespaced: true, shortNames: []string{"hpa"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "autoscaling/v2", name: "horizontalpodautoscalers", singularName: "horizontalpodautoscaler", kind: "HorizontalPodAutoscaler", namespaced: true, shortNames: []string{"hpa"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "autoscaling/v2beta1", name: "horizontalpodautoscalers", singularName: "horizontalpodautoscaler", kind: "HorizontalPodAutoscaler", namespaced: true, shortNames: []string{"hpa"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "autoscaling/v2beta2", name: "horizontalpodautoscalers", singularName: "horizontalpodautoscaler", kind: "HorizontalPodAutoscaler", namespaced: true, shortNames: []string{"hpa"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "batch/v1", name: "cronjobs", singularName: "cronjob", kind: "CronJob", namespaced: true, shortNames: []string{"cj"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "batch/v1", name: "jobs", singularName: "job", kind: "Job", namespaced: true, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "batch/v1beta1", name: "cronjobs", singularName: "cronjob", kind: "CronJob", namespaced: true, shortNames: []string{"cj"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "certificates.k8s.io/v1", name: "certificatesigningrequests", singularName: "certificatesigningrequest", kind: "CertificateSigningRequest", namespaced: false, shortNames: []string{"csr"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "certificates.k8s.io/v1alpha1", name: "clustertrustbundles", singularName: "clustertrustbundle", kind: "ClusterTrustBundle", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "coordination.k8s.io/v1", name: "leases", singularName: "lease", kind: "Lease", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "discovery.k8s.io/v1", name: "endpointslices", singularName: "endpointslice", kind: "EndpointSlice", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "discovery.k8s.io/v1beta1", name: "endpointslices", singularName: "endpointslice", kind: "EndpointSlice", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "events.k8s.io/v1", name: "events", singularName: "event", kind: "Event", namespaced: true, shortNames: []string{"ev"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "events.k8s.io/v1beta1", name: "events", singularName: "event", kind: "Event", namespaced: true, shortNames: []string{"ev"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "flowcontrol.apiserver.k8s.io/v1alpha1", name: "flowschemas", singularName: "flowschema", kind: "FlowSchema", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "flowcontrol.apiserver.k8s.io/v1alpha1", name: "prioritylevelconfigurations", singularName: "prioritylevelconfiguration", kind: "PriorityLevelConfiguration", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "flowcontrol.apiserver.k8s.io/v1beta1", name: "flowschemas", singularName: "flowschema", kind: "FlowSchema", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "flowcontrol.apiserver.k8s.io/v1beta1", name: "prioritylevelconfigurations", singularName: "prioritylevelconfiguration", kind: "PriorityLevelConfiguration", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "flowcontrol.apiserver.k8s.io/v1beta2", name: "flowschemas", singularName: "flowschema", kind: "FlowSchema", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "flowcontrol.apiserver.k8s.io/v1beta2", name: "prioritylevelconfigurations", singularName: "prioritylevelconfiguration", kind: "PriorityLevelConfiguration", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "flowcontrol.apiserver.k8s.io/v1beta3", name: "flowschemas", singularName: "flowschema", kind: "FlowSchema", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "flowcontrol.apiserver.k8s.io/v1beta3", name: "prioritylevelconfigurations", singularName: "prioritylevelconfiguration", kind: "PriorityLevelConfiguration", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "internal.apiserver.k8s.io/v1alpha1", name: "storageversions", singularName: "storageversion", kind: "StorageVersion", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "networking.k8s.io/v1", name: "ingressclasses", singularName: "ingressclass", kind: "IngressClass", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "networking.k8s.io/v1", name: "ingresses", singularName: "ingress", kind: "Ingress", namespaced: true, shortNames: []string{"ing"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "networking.k8s.io/v1", name: "networkpolicies", singularName: "networkpolicy", kind: "NetworkPolicy", namespaced: true, shortNames: []string{"netpol"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "networking.k8s.io/v1alpha1", name: "clustercidrs", singularName: "clustercidr", kind: "ClusterCIDR", namespaced: false, shortNames: []string{"cc"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "networking.k8s.io/v1alpha1", name: "ipaddresses", singularName: "ipaddress", kind: "IPAddress", namespaced: false, shortNames: []string{"ip"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "node.k8s.io/v1", name: "runtimeclasses", singularName: "runtimeclass", kind: "RuntimeClass", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "node.k8s.io/v1beta1", name: "runtimeclasses", singularName: "runtimeclass", kind: "RuntimeClass", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "policy/v1", name: "poddisruptionbudgets", singularName: "poddisruptionbudget", kind: "PodDisruptionBudget", namespaced: true, shortNames: []string{"pdb"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "policy/v1beta1", name: "poddisruptionbudgets", singularName: "poddisruptionbudget", kind: "PodDisruptionBudget", namespaced: true, shortNames: []string{"pdb"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "rbac.authorization.k8s.io/v1", name: "clusterrolebindings", singularName: "clusterrolebinding", kind: "ClusterRoleBinding", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "rbac.authorization.k8s.io/v1", name: "clusterroles", singularName: "clusterrole", kind: "ClusterRole", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "rbac.authorization.k8s.io/v1", name: "rolebindings", singularName: "rolebinding", kind: "RoleBinding", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "rbac.authorization.k8s.io/v1", name: "roles", singularName: "role", kind: "Role", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "resource.k8s.io/v1alpha2", name: "podschedulingcontexts", singularName: "podschedulingcontext", kind: "PodSchedulingContext", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "resource.k8s.io/v1alpha2", name: "resourceclaims", singularName: "resourceclaim", kind: "ResourceClaim", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "resource.k8s.io/v1alpha2", name: "resourceclaimtemplates", singularName: "resourceclaimtemplate", kind: "ResourceClaimTemplate", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "resource.k8s.io/v1alpha2", name: "resourceclasses", singularName: "resourceclass", kind: "ResourceClass", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "scheduling.k8s.io/v1", name: "priorityclasses", singularName: "priorityclass", kind: "PriorityClass", namespaced: false, shortNames: []string{"pc"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "storage.k8s.io/v1", name: "csidrivers", singularName: "csidriver", kind: "CSIDriver", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "storage.k8s.io/v1", name: "csinodes", singularName: "csinode", kind: "CSINode", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "storage.k8s.io/v1", name: "csistoragecapacities", singularName: "csistoragecapacity", kind: "CSIStorageCapacity", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "storage.k8s.io/v1", name: "storageclasses", singularName: "storageclass", kind: "StorageClass", namespaced: false, shortNames: []string{"sc"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "storage.k8s.io/v1", name: "volumeattachments", singularName: "volumeattachment", kind: "VolumeAttachment", namespaced: false, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "storage.k8s.io/v1alpha1", name: "csistoragecapacities", singularName: "csistoragecapacity", kind: "CSIStorageCapacity", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "storage.k8s.io/v1beta1", name: "csistoragecapacities", singularName: "csistoragecapacity", kind: "CSIStorageCapacity", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "componentstatuses", singularName: "componentstatus", kind: "ComponentStatus", namespaced: false, shortNames: []string{"cs"}, verbs: []string{"get", "list"}},
+	{groupVersion: "v1", name: "configmaps", singularName: "configmap", kind: "ConfigMap", namespaced: true, shortNames: []string{"cm"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "endpoints", singularName: "endpoints", kind: "Endpoints", namespaced: true, shortNames: []string{"ep"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "events", singularName: "event", kind: "Event", namespaced: true, shortNames: []string{"ev"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "limitranges", singularName: "limitrange", kind: "LimitRange", namespaced: true, shortNames: []string{"limits"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "namespaces", singularName: "namespace", kind: "Namespace", namespaced: false, shortNames: []string{"ns"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "nodes", singularName: "node", kind: "Node", namespaced: false, shortNames: []string{"no"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "persistentvolumeclaims", singularName: "persistentvolumeclaim", kind: "PersistentVolumeClaim", namespaced: true, shortNames: []string{"pvc"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "persistentvolumes", singularName: "persistentvolume", kind: "PersistentVolume", namespaced: false, shortNames: []string{"pv"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "pods", singularName: "pod", kind: "Pod", namespaced: true, shortNames: []string{"po"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "podtemplates", singularName: "podtemplate", kind: "PodTemplate", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "replicationcontrollers", singularName: "replicationcontroller", kind: "ReplicationController", namespaced: true, shortNames: []string{"rc"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "resourcequotas", singularName: "resourcequota", kind: "ResourceQuota", namespaced: true, shortNames: []string{"quota"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "secrets", singularName: "secret", kind: "Secret", namespaced: true, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "serviceaccounts", singularName: "serviceaccount", kind: "ServiceAccount", namespaced: true, shortNames: []string{"sa"}, verbs: []string{"get", "list", "watch"}},
+	{groupVersion: "v1", name: "services", singularName: "service", kind: "Service", namespaced: true, shortNames: []string{"svc"}, categories: []string{"all"}, verbs: []string{"get", "list", "watch"}},
+}
@@ -0,0 +1,167 @@
+package etcd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.uber.org/zap"
+	apiextensionsinstall "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/install"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	apiregistrationinstall "k8s.io/kube-aggregator/pkg/apis/apiregistration/install"
+	"k8s.io/kubernetes/pkg/api/legacyscheme"
+
+	// Register the built-in types that are stored in etcd in the legacyscheme, so protobuf encoded objects can be decoded
+	_ "github.com/openshift/openshift-apiserver/pkg/api/install"
+	_ "k8s.io/kubernetes/pkg/apis/admissionregistration/install"
+	_ "k8s.io/kubernetes/pkg/apis/apiserverinternal/install"
+	_ "k8s.io/kubernetes/pkg/apis/apps/install"
+	_ "k8s.io/kubernetes/pkg/apis/autoscaling/install"
+	_ "k8s.io/kubernetes/pkg/apis/batch/install"
+	_ "k8s.io/kubernetes/pkg/apis/certificates/install"
+	_ "k8s.io/kubernetes/pkg/apis/coordination/install"
+	_ "k8s.io/kubernetes/pkg/apis/core/install"
+	_ "k8s.io/kubernetes/pkg/apis/discovery/install"
+	_ "k8s.io/kubernetes/pkg/apis/events/install"
+	_ "k8s.io/kubernetes/pkg/apis/flowcontrol/install"
+	_ "k8s.io/kubernetes/pkg/apis/networking/install"
+	_ "k8s.io/kubernetes/pkg/apis/node/install"
+	_ "k8s.io/kubernetes/pkg/apis/policy/install"
+	_ "k8s.io/kubernetes/pkg/apis/rbac/install"
+	_ "k8s.io/kubernetes/pkg/apis/resource/install"
+	_ "k8s.io/kubernetes/pkg/apis/scheduling/install"
+	_ "k8s.io/kubernetes/pkg/apis/storage/install"
+)
+
+func init() {
+	// The apiextensions and apiregistration types are not part of the legacyscheme
+	apiextensionsinstall.Install(legacyscheme.Scheme)
+	apiregistrationinstall.Install(legacyscheme.Scheme)
+}
+
+// Prefixes of the keys the kube-apiserver and openshift-apiserver store objects under
+var prefixes = []string{"/kubernetes.io/", "/openshift.io/", "/registry/"}
+
+// keyBucket is the bucket etcd stores all revisions of all keys in. Its keys are the revision, its values the
+// protobuf encoded mvccpb.KeyValue.
+var keyBucket = []byte("key")
+
+// revisionBytesLen is the length of a revision key: An eight byte main revision, a separator and an eight
+// byte sub revision. Tombstones, which mark the deletion of a key, have an additional marker byte.
+const revisionBytesLen = 8 + 1 + 8
+
+// Read reads the latest revision of all objects from an etcd snapshot or bbolt database file. The resourceVersion
+// of the objects is set to their mod revision, like the kube-apiserver does. Objects that can not be decoded,
+// for example because they are encrypted or their kind is unknown, are skipped.
+func Read(l *zap.Logger, path string) ([]unstructured.Unstructured, error) {
+	db, err := bolt.Open(path, 0400, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer db.Close()
+
+	// key -> latest revision of the key
+	latest := map[string]*mvccpb.KeyValue{}
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(keyBucket)
+		if bucket == nil {
+			return fmt.Errorf("%s has no %q bucket, it is not an etcd database", path, keyBucket)
+		}
+		// Revisions are sorted, so later revisions of a key replace earlier ones
+		return bucket.ForEach(func(revision, value []byte) error {
+			kv := &mvccpb.KeyValue{}
+			if err := kv.Unmarshal(value); err != nil {
+				return fmt.Errorf("failed to decode revision %x: %w", revision, err)
+			}
+			if !hasPrefix(kv.Key) {
+				return nil
+			}
+			if len(revision) > revisionBytesLen {
+				delete(latest, string(kv.Key))
+				return nil
+			}
+			latest[string(kv.Key)] = kv
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []unstructured.Unstructured
+	encrypted, undecodable := sets.NewString(), sets.NewString()
+	keys := make([]string, 0, len(latest))
+	for key := range latest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		kv := latest[key]
+		if bytes.HasPrefix(kv.Value, []byte("k8s:enc:")) {
+			encrypted.Insert(resourcePrefix(key))
+			continue
+		}
+		object, err := decode(kv.Value)
+		if err != nil {
+			undecodable.Insert(resourcePrefix(key))
+			l.Debug("Failed to decode object", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		object.SetResourceVersion(strconv.FormatInt(kv.ModRevision, 10))
+		result = append(result, *object)
+	}
+	if encrypted.Len() > 0 {
+		l.Warn("Skipping encrypted objects", zap.Strings("resources", encrypted.List()))
+	}
+	if undecodable.Len() > 0 {
+		l.Warn("Skipping objects that can not be decoded", zap.Strings("resources", undecodable.List()))
+	}
+
+	return result, nil
+}
+
+// decode decodes a protobuf or JSON encoded object.
+func decode(value []byte) (*unstructured.Unstructured, error) {
+	object, gvk, err := legacyscheme.Codecs.UniversalDeserializer().Decode(value, nil, nil)
+	if err != nil {
+		// Custom resources and kinds we don't know are stored as JSON
+		u := &unstructured.Unstructured{}
+		if jsonErr := u.UnmarshalJSON(value); jsonErr != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert %T to unstructured: %w", object, err)
+	}
+	u := &unstructured.Unstructured{Object: raw}
+	u.SetGroupVersionKind(*gvk)
+
+	return u, nil
+}
+
+func hasPrefix(key []byte) bool {
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(key, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resourcePrefix returns the part of a key that identifies the resource, for example /kubernetes.io/secrets.
+func resourcePrefix(key string) string {
+	split := strings.SplitN(key, "/", 4)
+	if len(split) < 3 {
+		return key
+	}
+	return strings.Join(split[:3], "/")
+}
@@ -5,10 +5,12 @@ import (
 	"net/http"
 	"strings"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 )
 
+// Filter filters a list. Errors in the selectors of the request are returned as BadRequest.
 type Filter func(*unstructured.UnstructuredList) (*unstructured.UnstructuredList, error)
 
 func FromRequest(r *http.Request) []Filter {
@@ -31,7 +33,7 @@ func filterForFieldSelector(value []string) Filter {
 		for _, entry := range sanitizedValues {
 			split := strings.Split(entry, "=")
 			if len(split) != 2 {
-				return nil, fmt.Errorf("field selector expression %s split by = doesn't yield exactly two results", entry)
+				return nil, apierrors.NewBadRequest(fmt.Sprintf("field selector expression %s split by = doesn't yield exactly two results", entry))
 			}
 			selectorMap[split[0]] = split[1]
 		}
@@ -65,7 +67,7 @@ func filterForLabels(value []string) Filter {
 		for _, entry := range value {
 			selector, err := labels.Parse(entry)
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse label selector %s: %w", entry, err)
+				return nil, apierrors.NewBadRequest(fmt.Sprintf("failed to parse label selector %s: %v", entry, err))
 			}
 			selectors = append(selectors, selector)
 		}
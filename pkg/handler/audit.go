@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/alvaroaleman/static-kas/pkg/audit"
+	"github.com/alvaroaleman/static-kas/pkg/filter"
+	"github.com/alvaroaleman/static-kas/pkg/redact"
+	"github.com/alvaroaleman/static-kas/pkg/response"
+)
+
+// auditHistory loads the history from the audit logs of a dump on first use, because reading them can take a while.
+type auditHistory struct {
+	l       *zap.Logger
+	baseDir string
+	once    sync.Once
+	history *audit.History
+	err     error
+}
+
+func (a *auditHistory) get() (*audit.History, error) {
+	a.once.Do(func() {
+		a.history, a.err = audit.Load(a.l, a.baseDir)
+	})
+	return a.history, a.err
+}
+
+type historyResponse struct {
+	Changes []audit.Change `json:"changes"`
+}
+
+// historyHandler returns the changes of an object that were recorded in the audit logs. If a redactor is passed, the
+// objects are redacted like in all other responses.
+func historyHandler(l *zap.Logger, history *auditHistory, redactor *redact.Redactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := l.With(zap.String("path", r.URL.Path))
+		h, err := history.get()
+		if err != nil {
+			writeStatus(l, w, r, err)
+			return
+		}
+		vars := mux.Vars(r)
+		group := vars["group"]
+		if group == "core" {
+			group = ""
+		}
+		changes := h.For(audit.ObjectKey{Group: group, Resource: vars["resource"], Namespace: vars["namespace"], Name: vars["name"]})
+		if changes == nil {
+			changes = []audit.Change{}
+		}
+		if redactor != nil {
+			redacted := make([]audit.Change, 0, len(changes))
+			for _, change := range changes {
+				if change.Object != nil {
+					change.Object = change.Object.DeepCopy()
+					redactor.Redact(change.Object)
+				}
+				redacted = append(redacted, change)
+			}
+			changes = redacted
+		}
+
+		serializeAndWrite(l, w, historyResponse{Changes: changes})
+	}
+}
+
+// auditReplayMiddleware makes watches play back the changes recorded in the audit logs before they end in the
+// state of the dump. Watches that already have a replayer, because the dump is served as snapshot of a timeline that
+// plays back the transitions between its snapshots, keep it.
+func auditReplayMiddleware(l *zap.Logger, history *auditHistory) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("watch") != "true" || response.HasWatchReplayer(r.Context()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			vars := mux.Vars(r)
+			replayer := func(objects []runtime.Object) []response.WatchEvent {
+				h, err := history.get()
+				if err != nil {
+					l.Error("failed to load audit logs, not replaying them", zap.Error(err))
+					return addedEvents(objects)
+				}
+				return replay(r, h, vars, objects)
+			}
+			next.ServeHTTP(w, r.WithContext(response.WithWatchReplayer(r.Context(), replayer)))
+		})
+	}
+}
+
+// replay returns the events that play back the history of the watched objects and of the objects that only exist
+// in the history, ordered by the time of the change.
+func replay(r *http.Request, h *audit.History, vars map[string]string, objects []runtime.Object) []response.WatchEvent {
+	var events []audit.Event
+	inDump := map[audit.ObjectKey]bool{}
+	for _, object := range objects {
+		u, ok := object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		key := audit.ObjectKey{Group: vars["group"], Resource: vars["resource"], Namespace: u.GetNamespace(), Name: u.GetName()}
+		inDump[key] = true
+		events = append(events, h.Replay(key, u)...)
+	}
+
+	// Objects that were deleted before the dump was taken, unless a single object is watched
+	if vars["name"] == "" {
+		deleted := &unstructured.UnstructuredList{}
+		keys := map[string]audit.ObjectKey{}
+		for _, key := range h.Objects(vars["group"], vars["resource"], vars["namespace"]) {
+			if inDump[key] {
+				continue
+			}
+			if last := h.LastObject(key); last != nil {
+				deleted.Items = append(deleted.Items, *last.DeepCopy())
+				keys[key.Namespace+"/"+key.Name] = key
+			}
+		}
+		var err error
+		for _, filter := range filter.FromRequest(r) {
+			if deleted, err = filter(deleted); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			for _, item := range deleted.Items {
+				events = append(events, h.Replay(keys[item.GetNamespace()+"/"+item.GetName()], nil)...)
+			}
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(&events[j].Timestamp)
+	})
+	result := make([]response.WatchEvent, 0, len(events))
+	for _, event := range events {
+		result = append(result, response.WatchEvent{Type: event.Type, Object: event.Object})
+	}
+
+	return result
+}
+
+func addedEvents(objects []runtime.Object) []response.WatchEvent {
+	result := make([]response.WatchEvent, 0, len(objects))
+	for _, object := range objects {
+		result = append(result, response.WatchEvent{Type: "ADDED", Object: object})
+	}
+	return result
+}
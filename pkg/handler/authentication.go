@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authenticationv1beta1 "k8s.io/api/authentication/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// authenticationMiddleware rejects all requests the authenticator doesn't accept and stores the user of the
+// ones it accepts in the request context.
+func authenticationMiddleware(l *zap.Logger, a authenticator.Request) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resp, ok, err := a.AuthenticateRequest(r)
+			if err != nil || !ok {
+				if err != nil {
+					l.Info("Authentication failed", zap.String("url", r.URL.String()), zap.Error(err))
+				}
+				writeStatus(l, w, r, apierrors.NewUnauthorized("Unauthorized"))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(genericapirequest.WithUser(r.Context(), resp.User)))
+		})
+	}
+}
+
+// selfSubjectReviewHandler returns the identity of the requester. This is the impersonated user if there is one,
+// otherwise the authenticated user or system:anonymous if authentication is disabled. All versions have the same
+// structure, so the one from the path is echoed back.
+func selfSubjectReviewHandler(l *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := l.With(zap.String("path", r.URL.Path))
+		var review authenticationv1beta1.SelfSubjectReview
+		if !decodeReview(w, r, &review) {
+			return
+		}
+
+		u, err := impersonatedUser(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if u == nil {
+			var authenticated bool
+			if u, authenticated = genericapirequest.UserFrom(r.Context()); !authenticated {
+				u = &user.DefaultInfo{Name: user.Anonymous, Groups: []string{user.AllUnauthenticated}}
+			}
+		}
+
+		review.APIVersion = authenticationv1.GroupName + "/" + mux.Vars(r)["version"]
+		review.Kind = "SelfSubjectReview"
+		review.Status.UserInfo = authenticationv1.UserInfo{
+			Username: u.GetName(),
+			UID:      u.GetUID(),
+			Groups:   u.GetGroups(),
+		}
+		if extra := u.GetExtra(); len(extra) > 0 {
+			review.Status.UserInfo.Extra = make(map[string]authenticationv1.ExtraValue, len(extra))
+			for k, v := range extra {
+				review.Status.UserInfo.Extra[k] = v
+			}
+		}
+		serializeAndWrite(l, w, review)
+	}
+}
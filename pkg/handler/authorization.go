@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+
+	"github.com/alvaroaleman/static-kas/pkg/rbac"
+)
+
+// impersonatedUser returns the user from the impersonation headers of the request or nil if there are none. Like
+// in the kube-apiserver, ServiceAccounts get their groups if none are passed and everyone but system:anonymous is
+// part of system:authenticated.
+func impersonatedUser(r *http.Request) (user.Info, error) {
+	username := r.Header.Get(authenticationv1.ImpersonateUserHeader)
+	groups := r.Header.Values(authenticationv1.ImpersonateGroupHeader)
+	if username == "" {
+		if len(groups) > 0 {
+			return nil, fmt.Errorf("requested %v without impersonating a user", groups)
+		}
+		return nil, nil
+	}
+
+	if namespace, _, err := serviceaccount.SplitUsername(username); err == nil && len(groups) == 0 {
+		groups = serviceaccount.MakeGroupNames(namespace)
+	}
+	impliedGroup := user.AllAuthenticated
+	if username == user.Anonymous {
+		impliedGroup = user.AllUnauthenticated
+	}
+	if !containsString(groups, impliedGroup) {
+		groups = append(groups, impliedGroup)
+	}
+
+	return &user.DefaultInfo{Name: username, UID: r.Header.Get(authenticationv1.ImpersonateUIDHeader), Groups: groups}, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeReview(w http.ResponseWriter, r *http.Request, into interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(into); err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(fmt.Sprintf("failed to decode request body: %v", err)))
+		return false
+	}
+	return true
+}
+
+func reviewStatus(r *http.Request, a *rbac.Authorizer, attributes authorizer.Attributes) authorizationv1.SubjectAccessReviewStatus {
+	decision, reason, err := a.Authorize(r.Context(), attributes)
+	status := authorizationv1.SubjectAccessReviewStatus{
+		Allowed: decision == authorizer.DecisionAllow,
+		Denied:  decision == authorizer.DecisionDeny,
+		Reason:  reason,
+	}
+	if err != nil {
+		status.EvaluationError = err.Error()
+	}
+	return status
+}
+
+// allowAllReason is the reason for reviews of requests that are not impersonating anyone.
+const allowAllReason = "static-kas allows all requests that do not impersonate a user"
+
+func selfSubjectAccessReviewHandler(l *zap.Logger, a *rbac.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := l.With(zap.String("path", r.URL.Path))
+		var ssar authorizationv1.SelfSubjectAccessReview
+		if !decodeReview(w, r, &ssar) {
+			return
+		}
+		u, err := impersonatedUser(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if u == nil {
+			ssar.Status = authorizationv1.SubjectAccessReviewStatus{Allowed: true, Reason: allowAllReason}
+		} else {
+			ssar.Status = reviewStatus(r, a, rbac.Attributes(u, ssar.Spec.ResourceAttributes, ssar.Spec.NonResourceAttributes))
+		}
+		serializeAndWrite(l, w, ssar)
+	}
+}
+
+func subjectAccessReviewSpecUser(spec authorizationv1.SubjectAccessReviewSpec) (user.Info, error) {
+	if spec.User == "" && len(spec.Groups) == 0 {
+		return nil, fmt.Errorf("at least one of user or group must be specified")
+	}
+	extra := make(map[string][]string, len(spec.Extra))
+	for k, v := range spec.Extra {
+		extra[k] = v
+	}
+	return &user.DefaultInfo{Name: spec.User, UID: spec.UID, Groups: spec.Groups, Extra: extra}, nil
+}
+
+// subjectAccessReviewHandler handles SubjectAccessReviews and, if there is a namespace in the path, LocalSubjectAccessReviews.
+// They contain the subject so they are always evaluated against the RBAC of the dump.
+func subjectAccessReviewHandler(l *zap.Logger, a *rbac.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := l.With(zap.String("path", r.URL.Path))
+		namespace := mux.Vars(r)["namespace"]
+		if namespace == "" {
+			var sar authorizationv1.SubjectAccessReview
+			if !decodeReview(w, r, &sar) {
+				return
+			}
+			u, err := subjectAccessReviewSpecUser(sar.Spec)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			sar.Status = reviewStatus(r, a, rbac.Attributes(u, sar.Spec.ResourceAttributes, sar.Spec.NonResourceAttributes))
+			serializeAndWrite(l, w, sar)
+			return
+		}
+
+		var lsar authorizationv1.LocalSubjectAccessReview
+		if !decodeReview(w, r, &lsar) {
+			return
+		}
+		if lsar.Spec.ResourceAttributes == nil {
+			http.Error(w, "resourceAttributes are required for LocalSubjectAccessReviews", http.StatusBadRequest)
+			return
+		}
+		if lsar.Spec.ResourceAttributes.Namespace == "" {
+			lsar.Spec.ResourceAttributes.Namespace = namespace
+		}
+		if lsar.Spec.ResourceAttributes.Namespace != namespace {
+			http.Error(w, fmt.Sprintf("spec.resourceAttributes.namespace must match namespace: %s", namespace), http.StatusBadRequest)
+			return
+		}
+		u, err := subjectAccessReviewSpecUser(lsar.Spec)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lsar.Namespace = namespace
+		lsar.Status = reviewStatus(r, a, rbac.Attributes(u, lsar.Spec.ResourceAttributes, nil))
+		serializeAndWrite(l, w, lsar)
+	}
+}
+
+func selfSubjectRulesReviewHandler(l *zap.Logger, a *rbac.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := l.With(zap.String("path", r.URL.Path))
+		var ssrr authorizationv1.SelfSubjectRulesReview
+		if !decodeReview(w, r, &ssrr) {
+			return
+		}
+		if ssrr.Spec.Namespace == "" {
+			http.Error(w, "no namespace on request", http.StatusBadRequest)
+			return
+		}
+		u, err := impersonatedUser(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if u == nil {
+			ssrr.Status = authorizationv1.SubjectRulesReviewStatus{
+				ResourceRules:    []authorizationv1.ResourceRule{{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}}},
+				NonResourceRules: []authorizationv1.NonResourceRule{{Verbs: []string{"*"}, NonResourceURLs: []string{"*"}}},
+			}
+			serializeAndWrite(l, w, ssrr)
+			return
+		}
+
+		resourceRules, nonResourceRules, incomplete, err := a.RulesFor(u, ssrr.Spec.Namespace)
+		ssrr.Status = authorizationv1.SubjectRulesReviewStatus{
+			ResourceRules:    make([]authorizationv1.ResourceRule, 0, len(resourceRules)),
+			NonResourceRules: make([]authorizationv1.NonResourceRule, 0, len(nonResourceRules)),
+			Incomplete:       incomplete,
+		}
+		if err != nil {
+			ssrr.Status.EvaluationError = err.Error()
+		}
+		for _, rule := range resourceRules {
+			ssrr.Status.ResourceRules = append(ssrr.Status.ResourceRules, authorizationv1.ResourceRule{
+				Verbs:         rule.GetVerbs(),
+				APIGroups:     rule.GetAPIGroups(),
+				Resources:     rule.GetResources(),
+				ResourceNames: rule.GetResourceNames(),
+			})
+		}
+		for _, rule := range nonResourceRules {
+			ssrr.Status.NonResourceRules = append(ssrr.Status.NonResourceRules, authorizationv1.NonResourceRule{
+				Verbs:           rule.GetVerbs(),
+				NonResourceURLs: rule.GetNonResourceURLs(),
+			})
+		}
+		serializeAndWrite(l, w, ssrr)
+	}
+}
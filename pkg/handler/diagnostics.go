@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/alvaroaleman/static-kas/pkg/discovery"
+)
+
+// diagnosticsHandler returns the problems found in the dump. The dump is only diagnosed on the first request,
+// because it doesn't change.
+func diagnosticsHandler(l *zap.Logger, baseDir string, namespaceDirs map[string]string) http.HandlerFunc {
+	var once sync.Once
+	var report *discovery.Report
+	var diagnoseErr error
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := l.With(zap.String("path", r.URL.Path))
+		once.Do(func() {
+			report, diagnoseErr = discovery.Diagnose(baseDir, namespaceDirs)
+		})
+		if diagnoseErr != nil {
+			writeStatus(l, w, r, diagnoseErr)
+			return
+		}
+
+		serializeAndWrite(l, w, report)
+	}
+}
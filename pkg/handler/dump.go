@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// dumpDirs locates the directories of a dump. Namespaces are in the namespaces folder of the dump unless they
+// are mapped to a different directory, like the hosted control plane namespace of a hosted cluster, which is
+// in the dump of the management cluster.
+type dumpDirs struct {
+	baseDir       string
+	namespaceDirs map[string]string
+}
+
+// namespaceDir returns the directory of a namespace.
+func (d dumpDirs) namespaceDir(namespace string) string {
+	if dir, mapped := d.namespaceDirs[namespace]; mapped {
+		return dir
+	}
+
+	return filepath.Join(d.baseDir, "namespaces", namespace)
+}
+
+// namespaces returns the names of all namespaces of the dump, sorted.
+func (d dumpDirs) namespaces() ([]string, error) {
+	namespacePath := filepath.Join(d.baseDir, "namespaces")
+	entries, err := os.ReadDir(namespacePath)
+	// Hosted clusters of which only the control plane was dumped have no namespaces folder
+	if err != nil && !(os.IsNotExist(err) && len(d.namespaceDirs) > 0) {
+		return nil, fmt.Errorf("failed to read namespaces folder %s: %w", namespacePath, err)
+	}
+	var result []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			result = append(result, entry.Name())
+		}
+	}
+	for namespace := range d.namespaceDirs {
+		if _, err := os.Stat(filepath.Join(namespacePath, namespace)); os.IsNotExist(err) {
+			result = append(result, namespace)
+		}
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// allNamespaceDirs returns the directories of all namespaces of the dump.
+func (d dumpDirs) allNamespaceDirs() ([]string, error) {
+	namespaces, err := d.namespaces()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		result = append(result, d.namespaceDir(namespace))
+	}
+
+	return result, nil
+}
+
+// namespaceObject returns the namespace from the <namespace>.yaml in its directory, which must-gather and
+// `oc adm inspect` write. If there is none, a namespace that only has a name is returned.
+func (d dumpDirs) namespaceObject(namespace string) (*unstructured.Unstructured, error) {
+	ns := &unstructured.Unstructured{}
+	path := filepath.Join(d.namespaceDir(namespace), namespace+".yaml")
+	raw, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if len(raw) > 0 {
+		if err := yaml.Unmarshal(raw, &ns.Object); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+	}
+	ns.SetAPIVersion("v1")
+	ns.SetKind("Namespace")
+	ns.SetName(namespace)
+
+	return ns, nil
+}
@@ -0,0 +1,340 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/kubernetes/pkg/kubelet/cri/streaming/portforward"
+	streamingremotecommand "k8s.io/kubernetes/pkg/kubelet/cri/streaming/remotecommand"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// Same as the kubelet defaults
+	streamIdleTimeout     = 4 * time.Hour
+	streamCreationTimeout = 30 * time.Second
+)
+
+// execCommands are the read-only commands that can be enabled for exec. They operate on the
+// files the dump contains for the container.
+var execCommands = map[string]execCommand{
+	"cat":  execCat,
+	"head": execHead,
+	"tail": execTail,
+	"ls":   execLs,
+}
+
+type execCommand func(root string, args []string, out io.Writer) error
+
+// podExecHandler handles the exec, attach and portforward subresources of pods. It negotiates the streaming protocol
+// like the kubelet does so that clients get a proper error rather than a failed upgrade. Exec can optionally run a set of
+// read-only commands against the files of the container in the dump, everything else fails with an error explaining that
+// this is a static snapshot.
+func podExecHandler(l *zap.Logger, dirs dumpDirs, enabledExecCommands sets.String) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		l := l.With(zap.String("path", r.URL.Path))
+
+		pod, code, err := readPod(dirs, vars["namespace"], vars["name"])
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		if vars["subresource"] == "portforward" {
+			opts, err := portforward.NewV4Options(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			portforward.ServePortForward(w, r, staticPodStreamer{}, pod.Name, pod.UID, opts, streamIdleTimeout, streamCreationTimeout, portforward.SupportedProtocols)
+			return
+		}
+
+		container, err := podContainerName(pod, r.URL.Query().Get("container"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Clients send the Pod{Exec,Attach}Options of the apiserver, not the query args of the kubelet
+		execOpts := &corev1.PodExecOptions{}
+		if err := parameterCodec.DecodeParameters(r.URL.Query(), corev1.SchemeGroupVersion, execOpts); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode options: %v", err), http.StatusBadRequest)
+			return
+		}
+		if !execOpts.Stdin && !execOpts.Stdout && !execOpts.Stderr {
+			http.Error(w, "you must specify at least one of stdin, stdout, stderr", http.StatusBadRequest)
+			return
+		}
+		streamOpts := &streamingremotecommand.Options{
+			Stdin:  execOpts.Stdin,
+			Stdout: execOpts.Stdout,
+			Stderr: execOpts.Stderr && !execOpts.TTY,
+			TTY:    execOpts.TTY,
+		}
+
+		streamer := staticPodStreamer{
+			root:     filepath.Join(dirs.namespaceDir(pod.Namespace), "pods", pod.Name, container, container),
+			commands: enabledExecCommands,
+			log:      l,
+		}
+		if vars["subresource"] == "attach" {
+			streamingremotecommand.ServeAttach(w, r, streamer, pod.Name, pod.UID, container, streamOpts, streamIdleTimeout, streamCreationTimeout, remotecommandconsts.SupportedStreamingProtocols)
+			return
+		}
+		streamingremotecommand.ServeExec(w, r, streamer, pod.Name, pod.UID, container, execOpts.Command, streamOpts, streamIdleTimeout, streamCreationTimeout, remotecommandconsts.SupportedStreamingProtocols)
+	}
+}
+
+var errStaticSnapshot = errors.New("static-kas serves a static snapshot of a cluster, there are no running containers")
+
+// staticPodStreamer implements the Executor, Attacher and PortForwarder interfaces of the kubelet streaming server.
+type staticPodStreamer struct {
+	root     string
+	commands sets.String
+	log      *zap.Logger
+}
+
+func (s staticPodStreamer) ExecInContainer(_ context.Context, _ string, _ types.UID, _ string, cmd []string, _ io.Reader, stdout, stderr io.WriteCloser, _ bool, _ <-chan remotecommand.TerminalSize, _ time.Duration) error {
+	if len(cmd) == 0 || !s.commands.Has(cmd[0]) {
+		if s.commands.Len() == 0 {
+			return errStaticSnapshot
+		}
+		return fmt.Errorf("%w, only the following read-only commands are available: %s", errStaticSnapshot, strings.Join(s.commands.List(), ", "))
+	}
+	// kubectl exec -it uses a tty, stderr is not set then
+	if stderr == nil {
+		stderr = stdout
+	}
+	if stdout == nil {
+		return errors.New("stdout is required")
+	}
+
+	if err := execCommands[cmd[0]](s.root, cmd[1:], stdout); err != nil {
+		s.log.Debug("Exec command failed", zap.Strings("command", cmd), zap.Error(err))
+		fmt.Fprintf(stderr, "%s: %v\n", cmd[0], err)
+		return utilexec.CodeExitError{Err: err, Code: 1}
+	}
+
+	return nil
+}
+
+func (staticPodStreamer) AttachContainer(_ context.Context, _ string, _ types.UID, _ string, _ io.Reader, _, _ io.WriteCloser, _ bool, _ <-chan remotecommand.TerminalSize) error {
+	return errStaticSnapshot
+}
+
+func (staticPodStreamer) PortForward(_ context.Context, _ string, _ types.UID, port int32, stream io.ReadWriteCloser) error {
+	defer stream.Close()
+	return fmt.Errorf("can not forward port %d: %w", port, errStaticSnapshot)
+}
+
+// errOutsideContainer is returned for paths that resolve to a file outside of the files of the container.
+var errOutsideContainer = errors.New("links outside of the files of the container are not followed")
+
+// resolveExecPath resolves path relative to root. Absolute paths are treated as relative to root and
+// it is not possible to escape root, neither lexically nor through symlinks in the dump.
+func resolveExecPath(root, path string) (string, error) {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, filepath.Clean("/"+path)))
+	if err != nil {
+		return "", err
+	}
+	if relative, err := filepath.Rel(resolvedRoot, resolved); err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", errOutsideContainer
+	}
+
+	return resolved, nil
+}
+
+func execCat(root string, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("reading from stdin is not supported")
+	}
+	for _, arg := range args {
+		path, err := resolveExecPath(root, arg)
+		if err == nil {
+			err = copyFile(path, out)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, unwrapPathError(err))
+		}
+	}
+
+	return nil
+}
+
+func copyFile(path string, out io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return unwrapPathError(err)
+	}
+	defer f.Close()
+	_, err = io.Copy(out, f)
+	return unwrapPathError(err)
+}
+
+func execHead(root string, args []string, out io.Writer) error {
+	numLines, files, err := parseNumLinesArg(args)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		path, err := resolveExecPath(root, file)
+		if err == nil {
+			err = headFile(path, numLines, out)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, unwrapPathError(err))
+		}
+	}
+
+	return nil
+}
+
+// headFile writes the first numLines lines of the file at path to out.
+func headFile(path string, numLines int, out io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return unwrapPathError(err)
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	for i := 0; i < numLines; i++ {
+		line, readErr := reader.ReadBytes('\n')
+		if _, err := out.Write(line); err != nil {
+			return err
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return unwrapPathError(readErr)
+		}
+	}
+
+	return nil
+}
+
+func execTail(root string, args []string, out io.Writer) error {
+	numLines, files, err := parseNumLinesArg(args)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		path, err := resolveExecPath(root, file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, unwrapPathError(err))
+		}
+		segments, closeSegments, err := openLogSegments([]string{path})
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, unwrapPathError(err))
+		}
+		offset, _, err := tailOffset(segments[0], int64(numLines))
+		if err == nil {
+			_, err = io.Copy(out, io.NewSectionReader(segments[0], offset, segments[0].Size()-offset))
+		}
+		closeSegments()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+// parseNumLinesArg parses the arguments of head and tail, which is an optional -n <lines> and a list of files.
+func parseNumLinesArg(args []string) (int, []string, error) {
+	numLines := 10
+	var files []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-n" && i+1 < len(args):
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				return 0, nil, fmt.Errorf("invalid number of lines: %s", args[i])
+			}
+			numLines = n
+		case strings.HasPrefix(args[i], "-n"):
+			n, err := strconv.Atoi(strings.TrimPrefix(args[i], "-n"))
+			if err != nil || n < 0 {
+				return 0, nil, fmt.Errorf("invalid number of lines: %s", args[i])
+			}
+			numLines = n
+		default:
+			files = append(files, args[i])
+		}
+	}
+	if len(files) == 0 {
+		return 0, nil, errors.New("reading from stdin is not supported")
+	}
+
+	return numLines, files, nil
+}
+
+func execLs(root string, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	for _, arg := range args {
+		path, err := resolveExecPath(root, arg)
+		if err != nil {
+			return fmt.Errorf("cannot access '%s': %w", arg, unwrapPathError(err))
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("cannot access '%s': %w", arg, unwrapPathError(err))
+		}
+		if !info.IsDir() {
+			if _, err := fmt.Fprintln(out, arg); err != nil {
+				return err
+			}
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("cannot open directory '%s': %w", arg, unwrapPathError(err))
+		}
+		if len(args) > 1 {
+			if _, err := fmt.Fprintf(out, "%s:\n", arg); err != nil {
+				return err
+			}
+		}
+		for _, entry := range entries {
+			if _, err := fmt.Fprintln(out, entry.Name()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// unwrapPathError strips the path from os.PathErrors, it is the path on the host and not the one in the container.
+func unwrapPathError(err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
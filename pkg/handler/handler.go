@@ -1,16 +1,13 @@
 package handler
 
 import (
-	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -18,22 +15,88 @@ import (
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 
-	authorizationv1 "k8s.io/api/authorization/v1"
-	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
 
 	"github.com/alvaroaleman/static-kas/pkg/discovery"
 	"github.com/alvaroaleman/static-kas/pkg/filter"
+	"github.com/alvaroaleman/static-kas/pkg/rbac"
+	"github.com/alvaroaleman/static-kas/pkg/redact"
 	"github.com/alvaroaleman/static-kas/pkg/response"
 	"github.com/alvaroaleman/static-kas/pkg/transform"
 )
 
-func New(l *zap.Logger, baseDir string, port string) (*mux.Router, error) {
+// Option configures optional behavior of the handler.
+type Option func(*options)
+
+type options struct {
+	execCommands  sets.String
+	authenticator authenticator.Request
+	redactor      *redact.Redactor
+	namespaceDirs map[string]string
+	auditReplay   bool
+}
+
+// WithExecCommands enables the given read-only commands for exec. They operate on the files the dump
+// contains for the container.
+func WithExecCommands(commands ...string) Option {
+	return func(o *options) {
+		o.execCommands.Insert(commands...)
+	}
+}
+
+// WithNamespaceDirs serves namespaces from directories outside of the dump, for example the hosted control plane
+// namespace of a hosted cluster from the dump of its management cluster. The map is keyed by namespace.
+func WithNamespaceDirs(namespaceDirs map[string]string) Option {
+	return func(o *options) {
+		for namespace, dir := range namespaceDirs {
+			o.namespaceDirs[namespace] = dir
+		}
+	}
+}
+
+// WithAuditReplay makes watches play back the changes of the watched objects that were recorded in the audit logs
+// of the dump, before ending in the state of the dump.
+func WithAuditReplay() Option {
+	return func(o *options) {
+		o.auditReplay = true
+	}
+}
+
+// WithAuthenticator rejects all requests the authenticator does not accept.
+func WithAuthenticator(a authenticator.Request) Option {
+	return func(o *options) {
+		o.authenticator = a
+	}
+}
+
+// WithRedactor redacts all objects that get returned, regardless of whether they are returned through a get,
+// list, watch or as part of a table.
+func WithRedactor(r *redact.Redactor) Option {
+	return func(o *options) {
+		o.redactor = r
+	}
+}
+
+func New(l *zap.Logger, baseDir string, opts ...Option) (*mux.Router, error) {
+	o := options{execCommands: sets.NewString(), namespaceDirs: map[string]string{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	for _, command := range o.execCommands.List() {
+		if _, supported := execCommands[command]; !supported {
+			return nil, fmt.Errorf("exec command %q is not supported, supported commands: %v", command, sets.StringKeySet(execCommands).List())
+		}
+	}
+
 	l.Info("Discovering api resources")
-	groupResourceListMap, groupResourceMap, crdMap, err := discovery.Discover(l, baseDir)
+	groupResourceListMap, groupResourceMap, crdMap, err := discovery.Discover(l, baseDir, o.namespaceDirs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to discover apis: %w", err)
+		return nil, fmt.Errorf("failed to discover apis, run static-kas validate for details: %w", err)
 	}
 	groupSerializedResourceListMap, err := serializeAPIResourceList(groupResourceListMap)
 	if err != nil {
@@ -47,27 +110,57 @@ func New(l *zap.Logger, baseDir string, port string) (*mux.Router, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to serialize api group list: %w", err)
 	}
+	dirs := dumpDirs{baseDir: baseDir, namespaceDirs: o.namespaceDirs}
 	allNamespaces := &unstructured.UnstructuredList{}
 	allNamespaces.SetAPIVersion("v1")
 	allNamespaces.SetKind("NamespaceList")
-	namespacePath := filepath.Join(baseDir, "namespaces")
-	namespacesDirEntries, err := os.ReadDir(namespacePath)
+	namespaces, err := dirs.namespaces()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read namespaces folder %s: %w", namespacePath, err)
+		return nil, err
+	}
+	for _, namespace := range namespaces {
+		ns, err := dirs.namespaceObject(namespace)
+		if err != nil {
+			// This shouldn't make us fail, the namespace is still served with its name
+			l.Warn("failed to read namespace", zap.String("namespace", namespace), zap.Error(err))
+			ns = &unstructured.Unstructured{}
+			ns.SetAPIVersion("v1")
+			ns.SetKind("Namespace")
+			ns.SetName(namespace)
+		}
+		allNamespaces.Items = append(allNamespaces.Items, *ns)
 	}
-	for _, entry := range namespacesDirEntries {
-		ns := unstructured.Unstructured{}
-		ns.SetAPIVersion("v1")
-		ns.SetKind("Namespace")
-		ns.SetName(entry.Name())
-		allNamespaces.Items = append(allNamespaces.Items, ns)
+	allNamespaceDirs, err := dirs.allNamespaceDirs()
+	if err != nil {
+		return nil, err
 	}
 	l.Info("Finished discovering api resources")
 
+	authorizer, err := rbac.NewAuthorizer(baseDir, o.namespaceDirs)
+	if err != nil {
+		// This shouldn't make us fail
+		l.Warn("encountered errors reading rbac", zap.Error(err))
+	}
+
 	tableTransform := transform.NewTableTransformMap(l, crdMap)
 
 	router := mux.NewRouter()
 	router.Use(loggingMiddleware(l))
+	router.Use(mediaTypeMiddleware)
+	if o.authenticator != nil {
+		router.Use(authenticationMiddleware(l, o.authenticator))
+	}
+	if o.redactor != nil {
+		router.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(response.WithObjectMutator(r.Context(), o.redactor.Redact)))
+			})
+		})
+	}
+	history := &auditHistory{l: l, baseDir: baseDir}
+	if o.auditReplay {
+		router.Use(auditReplayMiddleware(l, history))
+	}
 	router.HandleFunc("/version", func(w http.ResponseWriter, _ *http.Request) {
 		data, err := os.ReadFile(filepath.Join(baseDir, "version.json"))
 		if err != nil {
@@ -88,13 +181,19 @@ func New(l *zap.Logger, baseDir string, port string) (*mux.Router, error) {
 	router.HandleFunc("/api/v1", func(w http.ResponseWriter, _ *http.Request) {
 		w.Write(groupSerializedResourceListMap["v1"])
 	}).Methods(http.MethodGet)
+	subresources := subresourceHandler(l, dirs, crdMap, tableTransform, allNamespaces)
+	router.HandleFunc("/api/v1/namespaces/{namespace}/{resource}/{name}/{subresource:status|scale}", subresources).Methods(http.MethodGet, http.MethodPut, http.MethodPatch)
+	router.HandleFunc("/api/v1/{resource}/{name}/{subresource:status|scale}", subresources).Methods(http.MethodGet, http.MethodPut, http.MethodPatch)
+	router.HandleFunc("/apis/{group}/{version}/namespaces/{namespace}/{resource}/{name}/{subresource:status|scale}", subresources).Methods(http.MethodGet, http.MethodPut, http.MethodPatch)
+	router.HandleFunc("/apis/{group}/{version}/{resource}/{name}/{subresource:status|scale}", subresources).Methods(http.MethodGet, http.MethodPut, http.MethodPatch)
 	router.HandleFunc("/api/v1/namespaces/{namespace}/{resource}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		l := l.With(zap.String("path", r.URL.Path))
-		path := path.Join(baseDir, "namespaces", vars["namespace"], "core")
-		var transformFunc transform.TransformFunc
-		if acceptsTable(r) {
-			transformFunc = tableTransform(transformKey(vars, transform.VerbList), tableVersion(r))
+		path := path.Join(dirs.namespaceDir(vars["namespace"]), "core")
+		transformFunc, err := transformFor(r, tableTransform, transformKey(vars, transform.VerbList))
+		if err != nil {
+			writeStatus(l, w, r, err)
+			return
 		}
 		if err := response.NewListResponse(r, w, path, vars["resource"], transformFunc, nil, filter.FromRequest(r)...); err != nil {
 			l.Error("failed to respond", zap.Error(err))
@@ -103,89 +202,36 @@ func New(l *zap.Logger, baseDir string, port string) (*mux.Router, error) {
 	router.HandleFunc("/api/v1/namespaces/{namespace}/{resource}/{name}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		l := l.With(zap.String("path", r.URL.Path))
-		var transformFunc transform.TransformFunc
-		if acceptsTable(r) {
-			transformFunc = tableTransform(transformKey(vars, transform.VerbGet), tableVersion(r))
-		}
-		path := path.Join(baseDir, "namespaces", vars["namespace"], "core")
-		if err := response.NewGetResponse(r, w, path, vars["resource"], vars["name"], nil, transformFunc); err != nil {
-			l.Error("failed to respond", zap.Error(err))
-		}
-	}).Methods(http.MethodGet)
-	router.HandleFunc("/api/v1/namespaces/{namespace}/pods/{name}/log", func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		containerName := r.URL.Query().Get("container")
-
-		if containerName == "" {
-			// User may omit the container name only when the pod has a single container
-			var code int
-			containerName, code, err = getSingleContainerPodContainerName(
-				fmt.Sprintf("http://localhost:%s", port), vars["namespace"], vars["name"])
-			if err != nil {
-				w.WriteHeader(code)
-
-				if code == http.StatusBadRequest {
-					w.Write([]byte(err.Error()))
-				} else {
-					w.Write([]byte(fmt.Sprintf("failed to get single container of pod %s in the %s namespace: %v",
-						vars["name"], vars["namespace"], err)))
-				}
-				return
-			}
-		}
-
-		fileName := "current.log"
-		hypershiftSuffix := ".log"
-		if r.URL.Query().Get("previous") == "true" {
-			fileName = "previous.log"
-			hypershiftSuffix = "-previous.log"
-		}
-		paths := []string{
-			path.Join(baseDir, "namespaces", vars["namespace"], "pods", vars["name"], containerName, containerName, "logs", fileName),
-			path.Join(baseDir, "namespaces", vars["namespace"], "core", "pods", "logs", vars["name"]+"-"+containerName+hypershiftSuffix),
-		}
-		f, err := openFirstFound(paths)
+		transformFunc, err := transformFor(r, tableTransform, transformKey(vars, transform.VerbGet))
 		if err != nil {
-			w.WriteHeader(404)
-			w.Write([]byte(fmt.Sprintf("failed to open one of %v: %v", paths, err)))
+			writeStatus(l, w, r, err)
 			return
 		}
-		defer f.Close()
-
-		if tailRaw := r.URL.Query().Get("tailLines"); tailRaw != "" {
-			tailLines, err := strconv.Atoi(tailRaw)
-			if err != nil {
-				http.Error(w, "tailLines query arg must be an integer", http.StatusBadRequest)
-				return
-			}
-			lines, err := tailFile(f, tailLines)
-			if err != nil {
-				http.Error(w, fmt.Sprintf("failed to read log: %v", err), http.StatusInternalServerError)
-				return
-			}
-			w.Write(lines)
-		} else {
-			io.Copy(w, f)
-		}
-
-		// Block so the client doesn't get an EOF error
-		if r.URL.Query().Get("follow") == "true" {
-			// We have to force a flush first, because golang buffers responses until the handler returns or the buffer is filled.
-			if f, ok := w.(http.Flusher); ok {
-				f.Flush()
-			}
-			<-r.Context().Done()
+		path := path.Join(dirs.namespaceDir(vars["namespace"]), "core")
+		if err := response.NewGetResponse(r, w, path, vars["resource"], vars["name"], nil, transformFunc); err != nil {
+			l.Error("failed to respond", zap.Error(err))
 		}
 	}).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/namespaces/{namespace}/pods/{name}/log", podLogHandler(l, dirs)).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/namespaces/{namespace}/pods/{name}/{subresource:exec|attach|portforward}", podExecHandler(l, dirs, o.execCommands)).Methods(http.MethodGet, http.MethodPost)
+	nodeLogs := nodeLogHandler(l, baseDir)
+	router.HandleFunc("/api/v1/nodes/{name}/proxy/logs", nodeLogs).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/nodes/{name}/proxy/logs/{path:.*}", nodeLogs).Methods(http.MethodGet)
+	router.HandleFunc("/static-kas/v1/logs/search", logSearchHandler(l, dirs)).Methods(http.MethodGet)
+	router.HandleFunc("/static-kas/v1/rbac/who-can", whoCanHandler(l, authorizer)).Methods(http.MethodGet)
+	router.HandleFunc("/static-kas/v1/diagnostics", diagnosticsHandler(l, baseDir, o.namespaceDirs)).Methods(http.MethodGet)
+	router.HandleFunc("/static-kas/v1/history/{group}/{resource}/{name}", historyHandler(l, history, o.redactor)).Methods(http.MethodGet)
+	router.HandleFunc("/static-kas/v1/history/{group}/{resource}/{namespace}/{name}", historyHandler(l, history, o.redactor)).Methods(http.MethodGet)
 	router.HandleFunc("/api/v1/{resource}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		l := l.With(zap.String("path", r.URL.Path))
-		var transformFunc transform.TransformFunc
-		if acceptsTable(r) {
-			transformFunc = tableTransform(transformKey(vars, transform.VerbList), tableVersion(r))
+		transformFunc, err := transformFor(r, tableTransform, transformKey(vars, transform.VerbList))
+		if err != nil {
+			writeStatus(l, w, r, err)
+			return
 		}
 		if groupResourceMap[discovery.GroupVersionResource{GroupVersion: "v1", Resource: vars["resource"]}].Namespaced {
-			if err := response.NewCrossNamespaceListResponse(r, w, filepath.Join(baseDir, "namespaces"), "core", vars["resource"], transformFunc, filter.FromRequest(r)...); err != nil {
+			if err := response.NewCrossNamespaceListResponse(r, w, allNamespaceDirs, "core", vars["resource"], transformFunc, filter.FromRequest(r)...); err != nil {
 				l.Error("failed to respond", zap.Error(err))
 			}
 			return
@@ -205,9 +251,10 @@ func New(l *zap.Logger, baseDir string, port string) (*mux.Router, error) {
 	router.HandleFunc("/api/v1/{resource}/{name}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		l := l.With(zap.String("path", r.URL.Path))
-		var transformFunc transform.TransformFunc
-		if acceptsTable(r) {
-			transformFunc = tableTransform(transformKey(vars, transform.VerbList), tableVersion(r))
+		transformFunc, err := transformFor(r, tableTransform, transformKey(vars, transform.VerbList))
+		if err != nil {
+			writeStatus(l, w, r, err)
+			return
 		}
 		path := path.Join(baseDir, "cluster-scoped-resources", "core")
 		if vars["resource"] == "namespaces" {
@@ -233,11 +280,12 @@ func New(l *zap.Logger, baseDir string, port string) (*mux.Router, error) {
 	router.HandleFunc("/apis/{group}/{version}/namespaces/{namespace}/{resource}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		l := l.With(zap.String("path", r.URL.Path))
-		var transformFunc transform.TransformFunc
-		if acceptsTable(r) {
-			transformFunc = tableTransform(transformKey(vars, transform.VerbList), tableVersion(r))
+		transformFunc, err := transformFor(r, tableTransform, transformKey(vars, transform.VerbList))
+		if err != nil {
+			writeStatus(l, w, r, err)
+			return
 		}
-		path := path.Join(baseDir, "namespaces", vars["namespace"], vars["group"])
+		path := path.Join(dirs.namespaceDir(vars["namespace"]), vars["group"])
 		if err := response.NewListResponse(r, w, path, vars["resource"], transformFunc, nil, filter.FromRequest(r)...); err != nil {
 			l.Error("failed to respond", zap.Error(err))
 		}
@@ -245,31 +293,35 @@ func New(l *zap.Logger, baseDir string, port string) (*mux.Router, error) {
 	router.HandleFunc("/apis/{group}/{version}/namespaces/{namespace}/{resource}/{name}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		l := l.With(zap.String("path", r.URL.Path))
-		var transformFunc transform.TransformFunc
-		if acceptsTable(r) {
-			transformFunc = tableTransform(transformKey(vars, transform.VerbGet), tableVersion(r))
+		transformFunc, err := transformFor(r, tableTransform, transformKey(vars, transform.VerbGet))
+		if err != nil {
+			writeStatus(l, w, r, err)
+			return
 		}
-		path := path.Join(baseDir, "namespaces", vars["namespace"], vars["group"])
+		path := path.Join(dirs.namespaceDir(vars["namespace"]), vars["group"])
 		if err := response.NewGetResponse(r, w, path, vars["resource"], vars["name"], nil, transformFunc); err != nil {
 			l.Error("failed to respond", zap.Error(err))
 		}
 	}).Methods(http.MethodGet)
-	router.HandleFunc("/apis/authorization.k8s.io/{version}/selfsubjectaccessreviews", func(w http.ResponseWriter, r *http.Request) {
-		handleSSAR(l.With(zap.String("path", r.URL.Path)), w, r)
-	}).Methods(http.MethodPost)
+	router.HandleFunc("/apis/authentication.k8s.io/{version:"+strings.Join(discovery.SelfSubjectReviewVersions, "|")+"}/selfsubjectreviews", selfSubjectReviewHandler(l)).Methods(http.MethodPost)
+	router.HandleFunc("/apis/authorization.k8s.io/{version}/selfsubjectaccessreviews", selfSubjectAccessReviewHandler(l, authorizer)).Methods(http.MethodPost)
+	router.HandleFunc("/apis/authorization.k8s.io/{version}/selfsubjectrulesreviews", selfSubjectRulesReviewHandler(l, authorizer)).Methods(http.MethodPost)
+	router.HandleFunc("/apis/authorization.k8s.io/{version}/subjectaccessreviews", subjectAccessReviewHandler(l, authorizer)).Methods(http.MethodPost)
+	router.HandleFunc("/apis/authorization.k8s.io/{version}/namespaces/{namespace}/localsubjectaccessreviews", subjectAccessReviewHandler(l, authorizer)).Methods(http.MethodPost)
 	router.HandleFunc("/apis/{group}/{version}/{resource}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		l := l.With(zap.String("path", r.URL.Path))
-		if vars["group"] == "authorization.k8s.io" && vars["resource"] == "selfsubjectaccessreviews" {
+		if (vars["group"] == "authorization.k8s.io" || vars["group"] == "authentication.k8s.io") && strings.HasSuffix(vars["resource"], "reviews") {
 			http.Error(w, "this endpoint only supports POST", http.StatusMethodNotAllowed)
 			return
 		}
-		var transformFunc transform.TransformFunc
-		if acceptsTable(r) {
-			transformFunc = tableTransform(transformKey(vars, transform.VerbList), tableVersion(r))
+		transformFunc, err := transformFor(r, tableTransform, transformKey(vars, transform.VerbList))
+		if err != nil {
+			writeStatus(l, w, r, err)
+			return
 		}
 		if groupResourceMap[discovery.GroupVersionResource{GroupVersion: vars["group"] + "/" + vars["version"], Resource: vars["resource"]}].Namespaced {
-			if err := response.NewCrossNamespaceListResponse(r, w, filepath.Join(baseDir, "namespaces"), vars["group"], vars["resource"], transformFunc, filter.FromRequest(r)...); err != nil {
+			if err := response.NewCrossNamespaceListResponse(r, w, allNamespaceDirs, vars["group"], vars["resource"], transformFunc, filter.FromRequest(r)...); err != nil {
 				l.Error("failed to respond", zap.Error(err))
 			}
 		} else {
@@ -283,9 +335,10 @@ func New(l *zap.Logger, baseDir string, port string) (*mux.Router, error) {
 		vars := mux.Vars(r)
 		l := l.With(zap.String("path", r.URL.Path))
 		path := path.Join(baseDir, "cluster-scoped-resources", vars["group"])
-		var transformFunc transform.TransformFunc
-		if acceptsTable(r) {
-			transformFunc = tableTransform(transformKey(vars, transform.VerbList), tableVersion(r))
+		transformFunc, err := transformFor(r, tableTransform, transformKey(vars, transform.VerbList))
+		if err != nil {
+			writeStatus(l, w, r, err)
+			return
 		}
 		if err := response.NewGetResponse(r, w, path, vars["resource"], vars["name"], nil, transformFunc); err != nil {
 			l.Error("failed to respond", zap.Error(err))
@@ -299,48 +352,6 @@ func New(l *zap.Logger, baseDir string, port string) (*mux.Router, error) {
 	return router, nil
 }
 
-func getSingleContainerPodContainerName(apiURL, namespace, name string) (string, int, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s", apiURL, namespace, name))
-	if err != nil {
-		return "", http.StatusInternalServerError, fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		errorBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return "", http.StatusInternalServerError, fmt.Errorf("failed to read error response %d body: %w", resp.StatusCode, err)
-		}
-
-		return "", http.StatusInternalServerError, fmt.Errorf("error response: %d. %s", err, string(errorBody))
-	}
-
-	podBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", http.StatusInternalServerError, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	var pod corev1.Pod
-	if err := json.Unmarshal(podBytes, &pod); err != nil {
-		return "", http.StatusInternalServerError, fmt.Errorf("unmarshal failed: %w", err)
-	}
-
-	if len(pod.Spec.Containers) == 0 {
-		return "", http.StatusInternalServerError, errors.New("invalid pod zero containers")
-	}
-
-	if len(pod.Spec.Containers) > 1 {
-		containerNames := make([]string, 0, len(pod.Spec.Containers))
-		for _, container := range pod.Spec.Containers {
-			containerNames = append(containerNames, container.Name)
-		}
-
-		return "", http.StatusBadRequest, fmt.Errorf("a container name must be specified for pod %s, choose one of: %s", name, containerNames)
-	}
-
-	return pod.Spec.Containers[0].Name, http.StatusOK, nil
-}
-
 func serializeAndWrite(l *zap.Logger, w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	serialized, err := json.Marshal(data)
@@ -353,6 +364,13 @@ func serializeAndWrite(l *zap.Logger, w http.ResponseWriter, data interface{}) {
 	}
 }
 
+// writeStatus responds with the Status of an error. Errors that are not an APIStatus result in an InternalError.
+func writeStatus(l *zap.Logger, w http.ResponseWriter, r *http.Request, err error) {
+	if err := response.NewStatusResponse(r, w, err); err != nil {
+		l.Error("failed to write response", zap.Error(err))
+	}
+}
+
 func serializeAPIResourceList(rl map[string]*metav1.APIResourceList) (map[string][]byte, error) {
 	result := make(map[string][]byte, len(rl))
 	for k, v := range rl {
@@ -373,6 +391,7 @@ func apiGroupList(rl map[string]*metav1.APIResourceList) (*metav1.APIGroupList,
 			APIVersion: "v1",
 		},
 	}
+	versionsByGroup := map[string][]string{}
 	for groupVersion := range rl {
 		if groupVersion == "v1" {
 			continue
@@ -381,17 +400,27 @@ func apiGroupList(rl map[string]*metav1.APIResourceList) (*metav1.APIGroupList,
 		if len(split) != 2 {
 			return nil, fmt.Errorf("groupVersion %q does not yield exactly two result when slash splitting", groupVersion)
 		}
+		versionsByGroup[split[0]] = append(versionsByGroup[split[0]], split[1])
+	}
 
-		// TODO: This assumes there is never more than one version, is this safe?
-		group, version := split[0], split[1]
-		result.Groups = append(result.Groups, metav1.APIGroup{
-			Name: group,
-			Versions: []metav1.GroupVersionForDiscovery{{
-				GroupVersion: groupVersion,
-				Version:      version,
-			}},
+	for group, versions := range versionsByGroup {
+		// Same order the kube-apiserver uses, the first one is the preferred version
+		sort.Slice(versions, func(i, j int) bool {
+			return version.CompareKubeAwareVersionStrings(versions[i], versions[j]) > 0
 		})
+		apiGroup := metav1.APIGroup{Name: group}
+		for _, v := range versions {
+			apiGroup.Versions = append(apiGroup.Versions, metav1.GroupVersionForDiscovery{
+				GroupVersion: group + "/" + v,
+				Version:      v,
+			})
+		}
+		apiGroup.PreferredVersion = apiGroup.Versions[0]
+		result.Groups = append(result.Groups, apiGroup)
 	}
+	sort.Slice(result.Groups, func(i, j int) bool {
+		return result.Groups[i].Name < result.Groups[j].Name
+	})
 
 	return result, nil
 }
@@ -410,56 +439,59 @@ func loggingMiddleware(l *zap.Logger) mux.MiddlewareFunc {
 	}
 }
 
-func acceptsTable(r *http.Request) bool {
-	return len(r.Header["Accept"]) > 0 && strings.Contains(r.Header["Accept"][0], "as=Table")
-}
-
-func transformKey(vars map[string]string, verb string) transform.TransformEntryKey {
-	return transform.TransformEntryKey{
-		ResourceName: vars["resource"],
-		GroupName:    vars["group"],
-		Version:      vars["version"],
-		Verb:         verb,
+// transformFor returns the TransformFunc for the representation the request asks for or nil if it asks for the
+// object as-is. The returned error is an APIStatus.
+func transformFor(r *http.Request, tableTransform func(transform.TransformEntryKey, string, transform.TableOptions) transform.TransformFunc, key transform.TransformEntryKey) (transform.TransformFunc, error) {
+	mediaType, err := negotiateMediaType(r)
+	if err != nil {
+		return nil, err
 	}
-}
-
-func tableVersion(r *http.Request) string {
-	split := strings.Split(r.Header["Accept"][0], ";")
-	for _, s := range split {
-		if strings.HasPrefix(s, "v=v") {
-			return strings.Split(s, "=")[1]
+	if mediaType.Convert == nil {
+		return nil, nil
+	}
+	if mediaType.Convert.Kind == "Table" {
+		opts, err := tableOptions(r)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
 		}
+		return tableTransform(key, mediaType.Convert.Version, opts), nil
 	}
 
-	return ""
+	return transform.PartialObjectMetadata(mediaType.Convert.Version), nil
 }
 
-func openFirstFound(paths []string) (*os.File, error) {
-	for _, path := range paths {
-		f, err := os.Open(path)
-		if err != nil {
-			if os.IsNotExist(err) {
-				continue
+// tableOptions parses the includeObject and noHeaders TableOptions from the query. Additionally, wide=false
+// omits the columns kubectl only shows with -o wide.
+func tableOptions(r *http.Request) (transform.TableOptions, error) {
+	opts := transform.DefaultTableOptions()
+	query := r.URL.Query()
+	switch includeObject := metav1.IncludeObjectPolicy(query.Get("includeObject")); includeObject {
+	case "":
+	case metav1.IncludeNone, metav1.IncludeMetadata, metav1.IncludeObject:
+		opts.IncludeObject = includeObject
+	default:
+		return opts, fmt.Errorf("includeObject must be one of %s, %s or %s, got %q", metav1.IncludeNone, metav1.IncludeMetadata, metav1.IncludeObject, includeObject)
+	}
+	for name, target := range map[string]*bool{"noHeaders": &opts.NoHeaders, "wide": &opts.Wide} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return opts, fmt.Errorf("invalid value %q for %s: %w", value, name, err)
 			}
-			return nil, err
+			*target = parsed
 		}
-		return f, nil
 	}
 
-	return nil, os.ErrNotExist
+	return opts, nil
 }
 
-func tailFile(file *os.File, numLines int) ([]byte, error) {
-	data, err := ioutil.ReadAll(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read: %w", err)
-	}
-	split := bytes.Split(data, []byte("\n"))
-	if numLines > len(split) {
-		return data, nil
+func transformKey(vars map[string]string, verb string) transform.TransformEntryKey {
+	return transform.TransformEntryKey{
+		ResourceName: vars["resource"],
+		GroupName:    vars["group"],
+		Version:      vars["version"],
+		Verb:         verb,
 	}
-
-	return bytes.Join(split[len(split)-1-numLines:], []byte("\n")), nil
 }
 
 func findByName(l *unstructured.UnstructuredList, name string) *unstructured.Unstructured {
@@ -471,17 +503,3 @@ func findByName(l *unstructured.UnstructuredList, name string) *unstructured.Uns
 
 	return nil
 }
-
-func handleSSAR(l *zap.Logger, w http.ResponseWriter, r *http.Request) {
-	var ssar authorizationv1.SelfSubjectAccessReview
-	if err := json.NewDecoder(r.Body).Decode(&ssar); err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte(fmt.Sprintf("failed to decode request body: %v", err)))
-		return
-	}
-	ssar.Status.Allowed = true
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(ssar); err != nil {
-		l.Error("failed to encode response", zap.Error(err))
-	}
-}
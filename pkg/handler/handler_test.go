@@ -1,34 +1,66 @@
 package handler_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/x509"
+	"encoding/binary"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.uber.org/zap/zaptest"
 
 	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1beta1 "k8s.io/api/authentication/v1beta1"
 	authorizationv1 "k8s.io/api/authorization/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer/protobuf"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes/scheme"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
 	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/api/legacyscheme"
 	utilpointer "k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
 
+	"github.com/alvaroaleman/static-kas/pkg/authentication"
+	discoverypkg "github.com/alvaroaleman/static-kas/pkg/discovery"
+	"github.com/alvaroaleman/static-kas/pkg/etcd"
 	"github.com/alvaroaleman/static-kas/pkg/handler"
+	"github.com/alvaroaleman/static-kas/pkg/hypershift"
+	"github.com/alvaroaleman/static-kas/pkg/layout"
+	"github.com/alvaroaleman/static-kas/pkg/redact"
+	"github.com/alvaroaleman/static-kas/pkg/timeline"
 )
 
 func init() {
@@ -36,14 +68,14 @@ func init() {
 }
 
 func TestServer(t *testing.T) {
-	handler, err := handler.New(zaptest.NewLogger(t), "./testdata", "8080")
+	router, err := handler.New(zaptest.NewLogger(t), "./testdata", handler.WithExecCommands("cat", "ls"))
 	if err != nil {
 		t.Fatalf("failed to construct server: %v", err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	serverDone := make(chan struct{})
-	server := &http.Server{Addr: "127.0.0.1:8080", Handler: handler}
+	server := &http.Server{Addr: "127.0.0.1:8080", Handler: router}
 	t.Cleanup(func() {
 		cancel()
 		server.Shutdown(ctx)
@@ -108,6 +140,9 @@ func TestServer(t *testing.T) {
 		Host: "http://127.0.0.1:8080",
 		// Prevent controller-runtime from defaulting to proto
 		ContentConfig: rest.ContentConfig{ContentType: "application/json"},
+		// All tests run in parallel, don't let the client-side rate limiter make the ones with short timeouts flake
+		QPS:   1000,
+		Burst: 1000,
 	}
 
 	c, err := client.New(cfg, client.Options{})
@@ -136,6 +171,15 @@ func TestServer(t *testing.T) {
 		t.Fatalf("failed to add authorizationv1 to client scheme: %v", err)
 	}
 
+	networkDiagnosticsClient, err := client.New(impersonatingConfig(cfg, "system:serviceaccount:openshift-network-diagnostics:network-diagnostics"), client.Options{Scheme: c.Scheme()})
+	if err != nil {
+		t.Fatalf("failed to construct impersonating controller-runtime client: %v", err)
+	}
+	prometheusClient, err := client.New(impersonatingConfig(cfg, "system:serviceaccount:openshift-monitoring:prometheus-k8s"), client.Options{Scheme: c.Scheme()})
+	if err != nil {
+		t.Fatalf("failed to construct impersonating controller-runtime client: %v", err)
+	}
+
 	tcs := []struct {
 		name string
 		run  func(*testing.T)
@@ -264,6 +308,26 @@ func TestServer(t *testing.T) {
 			name: "List namespaced non-core resource from namespace with non-matching label selector",
 			run:  verifyList(ctx, c, &appsv1.DeploymentList{}, 0, client.InNamespace("openshift-network-operator"), client.MatchingFields{"metadata.name": "other"}),
 		},
+		{
+			name: "Get namespaced resource as PartialObjectMetadata",
+			run:  verifyGet(ctx, c, partialObjectMetadataFor("apps/v1", "Deployment", "openshift-network-operator", "network-operator")),
+		},
+		{
+			name: "Get cluster-scoped resource as PartialObjectMetadata",
+			run:  verifyGet(ctx, c, partialObjectMetadataFor("v1", "Node", "", "ip-10-0-143-10.ec2.internal")),
+		},
+		{
+			name: "List namespaced resource as PartialObjectMetadataList",
+			run:  verifyList(ctx, c, partialObjectMetadataListFor("apps/v1", "Deployment"), 1, client.InNamespace("openshift-network-operator")),
+		},
+		{
+			name: "List namespaced resource across namespaces as PartialObjectMetadataList",
+			run:  verifyList(ctx, c, partialObjectMetadataListFor("v1", "Pod"), 3),
+		},
+		{
+			name: "List resource as PartialObjectMetadataList from cache",
+			run:  verifyList(ctx, cache, partialObjectMetadataListFor("apps/v1", "Deployment"), 1, client.InNamespace("openshift-network-operator")),
+		},
 		{
 			name: "List namespaced non-core resource from all namespaces",
 			run:  verifyList(ctx, c, &appsv1.DeploymentList{}, 2),
@@ -288,6 +352,315 @@ func TestServer(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Self-subject access review of impersonated user is allowed through ClusterRoleBinding",
+			run: verifyAccessReview(ctx, networkDiagnosticsClient, &authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "list", Resource: "pods"},
+			}}, true),
+		},
+		{
+			name: "Self-subject access review of impersonated user is denied for verb not in ClusterRole",
+			run: verifyAccessReview(ctx, networkDiagnosticsClient, &authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "delete", Resource: "pods", Namespace: "openshift-network-operator"},
+			}}, false),
+		},
+		{
+			name: "Self-subject access review of impersonated user is allowed through RoleBinding in namespace",
+			run: verifyAccessReview(ctx, prometheusClient, &authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "get", Resource: "endpoints", Namespace: "openshift-network-operator"},
+			}}, true),
+		},
+		{
+			name: "Self-subject access review of impersonated user is denied in namespace without RoleBinding",
+			run: verifyAccessReview(ctx, prometheusClient, &authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "get", Resource: "endpoints", Namespace: "openshift-sdn"},
+			}}, false),
+		},
+		{
+			name: "Subject access review is evaluated against RBAC",
+			run: verifyAccessReview(ctx, c, &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:               "system:serviceaccount:openshift-monitoring:prometheus-k8s",
+				ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "list", Resource: "secrets", Namespace: "openshift-network-operator"},
+			}}, false),
+		},
+		{
+			name: "Subject access review for system:masters is allowed",
+			run: verifyAccessReview(ctx, c, &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:               "admin",
+				Groups:             []string{"system:masters"},
+				ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "delete", Resource: "secrets", Namespace: "openshift-network-operator"},
+			}}, true),
+		},
+		{
+			name: "Local subject access review is evaluated against RBAC",
+			run: verifyAccessReview(ctx, c, &authorizationv1.LocalSubjectAccessReview{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-network-operator"},
+				Spec: authorizationv1.SubjectAccessReviewSpec{
+					User:               "system:serviceaccount:openshift-monitoring:prometheus-k8s",
+					Groups:             []string{"system:serviceaccounts", "system:serviceaccounts:openshift-monitoring"},
+					ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "watch", Resource: "pods"},
+				},
+			}, true),
+		},
+		{
+			name: "Self-subject rules review without impersonation allows everything",
+			run:  verifyRulesReview(ctx, c, "openshift-network-operator", authorizationv1.ResourceRule{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}}),
+		},
+		{
+			name: "Self-subject rules review of impersonated user contains rules from RoleBinding",
+			run: verifyRulesReview(ctx, prometheusClient, "openshift-network-operator", authorizationv1.ResourceRule{
+				Verbs:     []string{"get", "list", "watch"},
+				APIGroups: []string{""},
+				Resources: []string{"services", "endpoints", "pods"},
+			}),
+		},
+		{
+			name: "Who can lists subjects from ClusterRoleBindings, RoleBindings and aggregated ClusterRoles",
+			run: verifyWhoCan(ctx, cfg.Host, "verb=list&resource=pods&namespace=openshift-network-operator", []string{
+				"Group//support-engineers",
+				"Group//system:masters",
+				"ServiceAccount/openshift-monitoring/prometheus-k8s",
+				"ServiceAccount/openshift-network-diagnostics/network-diagnostics",
+			}),
+		},
+		{
+			name: "Who can without namespace only considers ClusterRoleBindings",
+			run: verifyWhoCan(ctx, cfg.Host, "verb=list&resource=pods", []string{
+				"Group//system:masters",
+				"ServiceAccount/openshift-network-diagnostics/network-diagnostics",
+			}),
+		},
+		{
+			name: "Who can with verb that is not granted only returns system:masters",
+			run:  verifyWhoCan(ctx, cfg.Host, "verb=delete&resource=pods&namespace=openshift-network-operator", []string{"Group//system:masters"}),
+		},
+		{
+			name: "Self-subject review without authentication returns anonymous",
+			run:  verifySelfSubjectReview(ctx, cfg, "system:anonymous"),
+		},
+		{
+			name: "Self-subject review is advertised and served in all versions",
+			run: func(t *testing.T) {
+				for _, version := range []string{"v1", "v1beta1", "v1alpha1"} {
+					verifyDiscoveryResources(cfg, "authentication.k8s.io/"+version, []string{"selfsubjectreviews"}, nil)(t)
+					review, err := selfSubjectReview(ctx, cfg, version)
+					if err != nil {
+						t.Fatalf("failed to create self-subject review in version %s: %v", version, err)
+					}
+					if expected := "authentication.k8s.io/" + version; review.APIVersion != expected {
+						t.Errorf("expected self-subject review in %s, got %s", expected, review.APIVersion)
+					}
+				}
+			},
+		},
+		{
+			name: "Self-subject review returns impersonated user",
+			run:  verifySelfSubjectReview(ctx, impersonatingConfig(cfg, "jane"), "jane"),
+		},
+		{
+			name: "Authentication with token and basic auth files",
+			run: func(t *testing.T) {
+				dir := t.TempDir()
+				tokenFile, basicAuthFile := filepath.Join(dir, "tokens.csv"), filepath.Join(dir, "basic-auth.csv")
+				if err := os.WriteFile(tokenFile, []byte(`secret-token,token-user,1,"group-a,group-b"`+"\n"), 0600); err != nil {
+					t.Fatalf("failed to write token file: %v", err)
+				}
+				if err := os.WriteFile(basicAuthFile, []byte("secret-password,basic-user,2\n"), 0600); err != nil {
+					t.Fatalf("failed to write basic auth file: %v", err)
+				}
+				authenticator, err := authentication.New(authentication.Options{TokenFile: tokenFile, BasicAuthFile: basicAuthFile})
+				if err != nil {
+					t.Fatalf("failed to construct authenticator: %v", err)
+				}
+				authenticatingHandler, err := handler.New(zaptest.NewLogger(t), "./testdata", handler.WithAuthenticator(authenticator))
+				if err != nil {
+					t.Fatalf("failed to construct server: %v", err)
+				}
+				server := httptest.NewServer(authenticatingHandler)
+				defer server.Close()
+
+				unauthenticated := &rest.Config{Host: server.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}}
+				unauthenticatedClient, err := corev1client.NewForConfig(unauthenticated)
+				if err != nil {
+					t.Fatalf("failed to construct client: %v", err)
+				}
+				if _, err := unauthenticatedClient.Pods("openshift-network-operator").List(ctx, metav1.ListOptions{}); !apierrors.IsUnauthorized(err) {
+					t.Errorf("expected Unauthorized error without credentials, got %v", err)
+				}
+				wrongToken := rest.CopyConfig(unauthenticated)
+				wrongToken.BearerToken = "wrong-token"
+				verifySelfSubjectReviewError(ctx, wrongToken, apierrors.IsUnauthorized)(t)
+
+				withToken := rest.CopyConfig(unauthenticated)
+				withToken.BearerToken = "secret-token"
+				verifySelfSubjectReview(ctx, withToken, "token-user")(t)
+				withBasicAuth := rest.CopyConfig(unauthenticated)
+				withBasicAuth.Username, withBasicAuth.Password = "basic-user", "secret-password"
+				verifySelfSubjectReview(ctx, withBasicAuth, "basic-user")(t)
+				withWrongPassword := rest.CopyConfig(withBasicAuth)
+				withWrongPassword.Password = "wrong-password"
+				verifySelfSubjectReviewError(ctx, withWrongPassword, apierrors.IsUnauthorized)(t)
+			},
+		},
+		{
+			name: "Scope is not derived from the location of the dump",
+			run: func(t *testing.T) {
+				// A dump in a directory called namespaces used to make all resources namespaced
+				baseDir := filepath.Join(t.TempDir(), "namespaces", "dump")
+				if err := os.MkdirAll(filepath.Join(baseDir, "namespaces"), 0755); err != nil {
+					t.Fatalf("failed to create namespaces dir: %v", err)
+				}
+				if err := os.MkdirAll(filepath.Join(baseDir, "cluster-scoped-resources", "core"), 0755); err != nil {
+					t.Fatalf("failed to create cluster-scoped-resources dir: %v", err)
+				}
+				nodes, err := os.ReadFile("./testdata/cluster-scoped-resources/core/nodes.yaml")
+				if err != nil {
+					t.Fatalf("failed to read nodes: %v", err)
+				}
+				if err := os.WriteFile(filepath.Join(baseDir, "cluster-scoped-resources", "core", "nodes.yaml"), nodes, 0644); err != nil {
+					t.Fatalf("failed to write nodes: %v", err)
+				}
+
+				nestedHandler, err := handler.New(zaptest.NewLogger(t), baseDir)
+				if err != nil {
+					t.Fatalf("failed to construct server: %v", err)
+				}
+				server := httptest.NewServer(nestedHandler)
+				defer server.Close()
+				nestedCfg := &rest.Config{Host: server.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}}
+
+				verifyDiscoveryScope(nestedCfg, "v1", "nodes", false)(t)
+				nestedClient, err := corev1client.NewForConfig(nestedCfg)
+				if err != nil {
+					t.Fatalf("failed to construct client: %v", err)
+				}
+				nodeList, err := nestedClient.Nodes().List(ctx, metav1.ListOptions{})
+				if err != nil {
+					t.Fatalf("failed to list nodes: %v", err)
+				}
+				if len(nodeList.Items) != 1 {
+					t.Errorf("expected one node, got %d", len(nodeList.Items))
+				}
+			},
+		},
+		{
+			name: "Scope of built-in resources comes from the scheme",
+			run:  verifyDiscoveryScope(cfg, "rbac.authorization.k8s.io/v1", "roles", true),
+		},
+		{
+			name: "Scope of custom resources comes from the CRD",
+			run:  verifyDiscoveryScope(cfg, "config.openshift.io/v1", "clusteroperators", false),
+		},
+		{
+			name: "Get object with multiple representations returns the newest",
+			run: func(t *testing.T) {
+				pod, err := corev1Client.Pods("openshift-network2-operator").Get(ctx, "network-operator2-7887564c4-mjg9d", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("failed to get pod: %v", err)
+				}
+				if pod.ResourceVersion != "4301" || pod.Labels["representation"] != "individual" {
+					t.Errorf("expected the individual representation with resourceVersion 4301, got resourceVersion %s and labels %v", pod.ResourceVersion, pod.Labels)
+				}
+			},
+		},
+		{
+			name: "List objects with multiple representations de-duplicates them",
+			run: func(t *testing.T) {
+				pods, err := corev1Client.Pods("openshift-network2-operator").List(ctx, metav1.ListOptions{})
+				if err != nil {
+					t.Fatalf("failed to list pods: %v", err)
+				}
+				if len(pods.Items) != 1 {
+					t.Fatalf("expected exactly one pod, got %d", len(pods.Items))
+				}
+				if pods.Items[0].ResourceVersion != "4301" {
+					t.Errorf("expected the pod with resourceVersion 4301, got %s", pods.Items[0].ResourceVersion)
+				}
+			},
+		},
+		{
+			name: "Redaction",
+			run: func(t *testing.T) {
+				redactor, err := redact.New(redact.DefaultRules())
+				if err != nil {
+					t.Fatalf("failed to construct redactor: %v", err)
+				}
+				redactingHandler, err := handler.New(zaptest.NewLogger(t), "./testdata", handler.WithRedactor(redactor))
+				if err != nil {
+					t.Fatalf("failed to construct server: %v", err)
+				}
+				server := httptest.NewServer(redactingHandler)
+				defer server.Close()
+				redactingClient, err := corev1client.NewForConfig(&rest.Config{Host: server.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}})
+				if err != nil {
+					t.Fatalf("failed to construct client: %v", err)
+				}
+
+				secret, err := redactingClient.Secrets("openshift-network-operator").Get(ctx, "proxy-credentials", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("failed to get secret: %v", err)
+				}
+				for key, value := range secret.Data {
+					if string(value) != "REDACTED" {
+						t.Errorf("expected secret key %s to be redacted, got %q", key, string(value))
+					}
+				}
+				if annotation := secret.Annotations["kubectl.kubernetes.io/last-applied-configuration"]; annotation != "REDACTED" {
+					t.Errorf("expected last-applied-configuration annotation to be redacted, got %q", annotation)
+				}
+
+				configMaps, err := redactingClient.ConfigMaps("openshift-network-operator").List(ctx, metav1.ListOptions{})
+				if err != nil {
+					t.Fatalf("failed to list configmaps: %v", err)
+				}
+				if len(configMaps.Items) != 1 {
+					t.Fatalf("expected exactly one configmap, got %d", len(configMaps.Items))
+				}
+				expectedData := map[string]string{"admin.kubeconfig": "REDACTED", "applyConfig": "REDACTED", "mtu": "1400"}
+				if !reflect.DeepEqual(configMaps.Items[0].Data, expectedData) {
+					t.Errorf("expected configmap data %v, got %v", expectedData, configMaps.Items[0].Data)
+				}
+
+				pod, err := redactingClient.Pods("openshift-network-operator").Get(ctx, "network-operator-7887564c4-mjg9d", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("failed to get pod: %v", err)
+				}
+				for _, env := range pod.Spec.Containers[0].Env {
+					if env.Name == "PROXY_PASSWORD" && env.Value != "REDACTED" {
+						t.Errorf("expected PROXY_PASSWORD env var to be redacted, got %q", env.Value)
+					}
+					if env.Name == "RELEASE_VERSION" && env.Value == "REDACTED" {
+						t.Error("expected RELEASE_VERSION env var to not be redacted")
+					}
+				}
+
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/namespaces/openshift-network-operator/secrets?includeObject=Object", nil)
+				if err != nil {
+					t.Fatalf("failed to construct request: %v", err)
+				}
+				req.Header.Set("Accept", "application/json;as=Table;v=v1;g=meta.k8s.io")
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("failed to request table: %v", err)
+				}
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatalf("failed to read table: %v", err)
+				}
+				if bytes.Contains(body, []byte("c3VwZXItc2VjcmV0")) {
+					t.Errorf("expected table to not contain secret data, got %s", string(body))
+				}
+
+				unredacted := &corev1.Secret{}
+				if err := c.Get(ctx, client.ObjectKey{Namespace: "openshift-network-operator", Name: "proxy-credentials"}, unredacted); err != nil {
+					t.Fatalf("failed to get secret: %v", err)
+				}
+				if string(unredacted.Data["password"]) != "super-secret" {
+					t.Errorf("expected secret to not be redacted without redaction mode, got %q", string(unredacted.Data["password"]))
+				}
+			},
+		},
 		{
 			// These are special because they are not in the dump
 			name: "Get namespace",
@@ -306,6 +679,120 @@ func TestServer(t *testing.T) {
 			name: "List nodes table printing",
 			run:  verifyTablePrinting(ctx, "/api/v1/nodes", 10, 1),
 		},
+		{
+			name: "Negotiation picks the first acceptable media type of a list",
+			run:  verifyNegotiation(ctx, "/api/v1/nodes", []string{"application/json;as=Table;v=v1;g=meta.k8s.io,application/json;as=Table;v=v1beta1;g=meta.k8s.io,application/json"}, 200, "meta.k8s.io/v1", "Table"),
+		},
+		{
+			name: "Negotiation skips unsupported media types",
+			run:  verifyNegotiation(ctx, "/api/v1/nodes", []string{"application/vnd.kubernetes.protobuf;as=Table;v=v1;g=meta.k8s.io,application/json;as=Table;v=v1beta1;g=meta.k8s.io"}, 200, "meta.k8s.io/v1beta1", "Table"),
+		},
+		{
+			name: "Negotiation skips transformations without version",
+			run:  verifyNegotiation(ctx, "/api/v1/nodes", []string{"application/json;as=Table;g=meta.k8s.io,application/json"}, 200, "v1", "NodeList"),
+		},
+		{
+			name: "Negotiation honors quality",
+			run:  verifyNegotiation(ctx, "/api/v1/nodes/ip-10-0-143-10.ec2.internal", []string{"application/json;q=0.5,application/json;as=PartialObjectMetadata;v=v1;g=meta.k8s.io"}, 200, "meta.k8s.io/v1", "PartialObjectMetadata"),
+		},
+		{
+			name: "Negotiation considers all Accept headers",
+			run:  verifyNegotiation(ctx, "/api/v1/nodes", []string{"application/vnd.kubernetes.protobuf", "application/json;as=Table;v=v1;g=meta.k8s.io"}, 200, "meta.k8s.io/v1", "Table"),
+		},
+		{
+			name: "Get as YAML",
+			run:  verifyYAML(ctx, "/api/v1/nodes/ip-10-0-143-10.ec2.internal", 200, "v1", "Node"),
+		},
+		{
+			name: "List as YAML",
+			run:  verifyYAML(ctx, "/apis/apps/v1/namespaces/openshift-network-operator/deployments", 200, "apps/v1", "DeploymentList"),
+		},
+		{
+			name: "Not found Status as YAML",
+			run:  verifyYAML(ctx, "/api/v1/nodes/does-not-exist", 404, "v1", "Status"),
+		},
+		{
+			name: "Get as YAML preserves the field order of objects with their own file",
+			run: func(t *testing.T) {
+				_, body, err := requestYAML(ctx, "/apis/monitoring.coreos.com/v1/namespaces/openshift-sdn/servicemonitors/monitor-sdn")
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected, err := os.ReadFile("testdata/namespaces/openshift-sdn/monitoring.coreos.com/servicemonitors/monitor-sdn.yaml")
+				if err != nil {
+					t.Fatalf("failed to read servicemonitor: %v", err)
+				}
+				if !bytes.Equal(body, expected) {
+					t.Errorf("expected the file to be served as-is, got %s", string(body))
+				}
+			},
+		},
+		{
+			name: "Negotiation without acceptable media type",
+			run:  verifyNegotiation(ctx, "/api/v1/nodes", []string{"application/vnd.kubernetes.protobuf"}, 406, "v1", "Status"),
+		},
+		{
+			name: "Negotiation of protobuf explains that it is not supported",
+			run: func(t *testing.T) {
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:8080/api/v1/nodes", nil)
+				if err != nil {
+					t.Fatalf("failed to construct request: %v", err)
+				}
+				req.Header.Set("Accept", "application/vnd.kubernetes.protobuf")
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("failed to do http request: %v", err)
+				}
+				defer resp.Body.Close()
+				status := &metav1.Status{}
+				if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+					t.Fatalf("failed to decode status: %v", err)
+				}
+				if status.Reason != metav1.StatusReasonNotAcceptable || !strings.Contains(status.Message, "application/vnd.kubernetes.protobuf is not supported") {
+					t.Errorf("expected NotAcceptable status that explains protobuf is not supported, got %+v", status)
+				}
+			},
+		},
+		{
+			name: "Negotiation with unsupported transformation",
+			run:  verifyNegotiation(ctx, "/apis/apps/v1/deployments", []string{"application/json;as=Deployment;v=v1;g=apps"}, 406, "v1", "Status"),
+		},
+		{
+			name: "Table embeds PartialObjectMetadata by default",
+			run:  verifyTableOptions(ctx, "/api/v1/nodes", 10, 10, "PartialObjectMetadata"),
+		},
+		{
+			name: "Table embeds full object with includeObject=Object",
+			run:  verifyTableOptions(ctx, "/api/v1/nodes?includeObject=Object", 10, 10, "Node"),
+		},
+		{
+			name: "Table embeds nothing with includeObject=None",
+			run:  verifyTableOptions(ctx, "/api/v1/nodes?includeObject=None", 10, 10, ""),
+		},
+		{
+			name: "Table without headers",
+			run:  verifyTableOptions(ctx, "/api/v1/nodes?noHeaders=true", 0, 10, "PartialObjectMetadata"),
+		},
+		{
+			name: "Table without wide columns",
+			run:  verifyTableOptions(ctx, "/api/v1/nodes?wide=false", 5, 5, "PartialObjectMetadata"),
+		},
+		{
+			name: "Table of custom resource embeds full object with includeObject=Object",
+			run:  verifyTableOptions(ctx, "/apis/config.openshift.io/v1/clusteroperators?includeObject=Object", 6, 6, "ClusterOperator"),
+		},
+		{
+			name: "Table of custom resource without headers and objects",
+			run:  verifyTableOptions(ctx, "/apis/config.openshift.io/v1/clusteroperators?noHeaders=true&wide=false&includeObject=None", 0, 6, ""),
+		},
+		{
+			name: "Table with invalid includeObject",
+			run: func(t *testing.T) {
+				if _, err := requestTableOnPath(ctx, "/api/v1/nodes?includeObject=Everything", "v1"); err == nil || !strings.Contains(err.Error(), "400") {
+					t.Errorf("expected a 400 error, got %v", err)
+				}
+			},
+		},
 		{
 			name: "Get node table printing",
 			run:  verifyTablePrinting(ctx, "/api/v1/nodes/ip-10-0-143-10.ec2.internal", 10, 1),
@@ -372,7 +859,7 @@ func TestServer(t *testing.T) {
 		},
 		{
 			name: "List apiservice tableprinting",
-			run:  verifyTablePrinting(ctx, "/apis/apiregistration.k8s.io/v1/apiservices", 4, 1),
+			run:  verifyTablePrinting(ctx, "/apis/apiregistration.k8s.io/v1/apiservices", 4, 2),
 		},
 		{
 			name: "Get apiservice tableprinting",
@@ -380,7 +867,7 @@ func TestServer(t *testing.T) {
 		},
 		{
 			name: "List for CRDs falls back to default printer",
-			run:  verifyTablePrinting(ctx, "/apis/apiextensions.k8s.io/v1/customresourcedefinitions", 2, 1),
+			run:  verifyTablePrinting(ctx, "/apis/apiextensions.k8s.io/v1/customresourcedefinitions", 2, 2),
 		},
 		{
 			name: "Get for CRDs falls back to default printer",
@@ -416,10 +903,28 @@ func TestServer(t *testing.T) {
 				cfg.Host,
 				"openshift-network2-operator",
 				"network-operator2-7887564c4-mjg9d",
-				"a container name must be specified for pod network-operator2-7887564c4-mjg9d, choose one of: [container1 container2]",
+				"a container name must be specified for pod network-operator2-7887564c4-mjg9d, choose one of: [container1 container2] or one of the init containers: [init-container] or one of the ephemeral containers: [debugger]",
 				http.StatusBadRequest,
 			),
 		},
+		{
+			name: "Get pod logs for container that does not exist in pod",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/namespaces/openshift-network2-operator/pods/network-operator2-7887564c4-mjg9d/log?container=container3",
+				"container container3 is not valid for pod network-operator2-7887564c4-mjg9d",
+				http.StatusBadRequest,
+			),
+		},
+		{
+			name: "Get pod logs for pod that does not exist",
+			run: verifyGetLogsNoContainer(ctx,
+				cfg.Host,
+				"openshift-network2-operator",
+				"does-not-exist",
+				`pods "does-not-exist" not found`,
+				http.StatusNotFound,
+			),
+		},
 		{
 			name: "Get pod logs with follow",
 			run: func(t *testing.T) {
@@ -533,48 +1038,1471 @@ func TestServer(t *testing.T) {
 			),
 		},
 		{
-			name: "List response is sorted",
-			run: func(t *testing.T) {
-				podList := &corev1.PodList{}
-				if err := c.List(ctx, podList); err != nil {
-					t.Fatalf("failed to list pods: %v", err)
-				}
-				isSorted := sort.SliceIsSorted(podList.Items, func(a, b int) bool {
-					if podList.Items[a].CreationTimestamp != podList.Items[b].CreationTimestamp {
-						return podList.Items[a].CreationTimestamp.Before(&podList.Items[b].CreationTimestamp)
-					}
-					return podList.Items[a].Name < podList.Items[b].Name
-				})
-				if !isSorted {
-					t.Error("resulting pod list is not sorted by creationTimestamp")
-				}
-			},
+			name: "Get pod logs strips CRI timestamps by default",
+			run: verifyGetLogs(ctx,
+				corev1Client,
+				"openshift-network2-operator",
+				"network-operator2-7887564c4-mjg9d",
+				"First line\nSecond line\nThird line",
+				func(o *corev1.PodLogOptions) { o.Container = "container1" },
+			),
 		},
-	}
-
-	for _, tc := range tcs {
-		tc := tc
-		t.Run(tc.name, func(t *testing.T) {
-			t.Parallel()
-			tc.run(t)
-		})
-	}
-}
-
-func unstructuredListFor(apiVersion, kind string) *unstructured.UnstructuredList {
-	u := &unstructured.UnstructuredList{}
-	u.SetAPIVersion(apiVersion)
-	u.SetKind(kind)
-
-	return u
-}
-
-func requestTableOnPath(ctx context.Context, path string, version string) (*metav1.Table, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:8080"+path, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to construct request: %w", err)
-	}
-	req.Header.Set("Accept", fmt.Sprintf("application/json;as=Table;v=%s;g=meta.k8s.io", version))
+		{
+			name: "Get pod logs with timestamps",
+			run: verifyGetLogs(ctx,
+				corev1Client,
+				"openshift-network2-operator",
+				"network-operator2-7887564c4-mjg9d",
+				"2022-03-04T18:10:00.000000000Z First line\n2022-03-04T18:15:00.000000000Z Second line\n2022-03-04T18:19:00.500000000Z Third line",
+				func(o *corev1.PodLogOptions) {
+					o.Container = "container1"
+					o.Timestamps = true
+				},
+			),
+		},
+		{
+			name: "Get pod logs with tail when file doesn't end with a newline",
+			run: verifyGetLogs(ctx,
+				corev1Client,
+				"openshift-network2-operator",
+				"network-operator2-7887564c4-mjg9d",
+				"Second line\nThird line",
+				func(o *corev1.PodLogOptions) {
+					o.Container = "container1"
+					o.TailLines = utilpointer.Int64(3)
+				},
+			),
+		},
+		{
+			name: "Get pod logs with tail zero",
+			run: verifyGetLogs(ctx,
+				corev1Client,
+				"openshift-network-operator",
+				"network-operator-7887564c4-mjg9d",
+				"",
+				func(o *corev1.PodLogOptions) {
+					o.Container = "network-operator"
+					o.TailLines = utilpointer.Int64(0)
+				},
+			),
+		},
+		{
+			name: "Get pod logs with tail larger than the log",
+			run: verifyGetLogs(ctx,
+				corev1Client,
+				"openshift-network-operator",
+				"network-operator-7887564c4-mjg9d",
+				"Current first line\nCurrent second line\n",
+				func(o *corev1.PodLogOptions) {
+					o.Container = "network-operator"
+					o.TailLines = utilpointer.Int64(10)
+				},
+			),
+		},
+		{
+			name: "Get pod logs with sinceSeconds is relative to the dump time",
+			run: verifyGetLogs(ctx,
+				corev1Client,
+				"openshift-network2-operator",
+				"network-operator2-7887564c4-mjg9d",
+				"Third line",
+				func(o *corev1.PodLogOptions) {
+					o.Container = "container1"
+					o.SinceSeconds = utilpointer.Int64(120)
+				},
+			),
+		},
+		{
+			name: "Get pod logs with sinceTime",
+			run: verifyGetLogs(ctx,
+				corev1Client,
+				"openshift-network2-operator",
+				"network-operator2-7887564c4-mjg9d",
+				"Second line\nThird line",
+				func(o *corev1.PodLogOptions) {
+					o.Container = "container1"
+					o.SinceTime = &metav1.Time{Time: time.Date(2022, 3, 4, 18, 12, 0, 0, time.UTC)}
+				},
+			),
+		},
+		{
+			name: "Get pod logs with limitBytes",
+			run: verifyGetLogs(ctx,
+				corev1Client,
+				"openshift-network2-operator",
+				"network-operator2-7887564c4-mjg9d",
+				"First",
+				func(o *corev1.PodLogOptions) {
+					o.Container = "container1"
+					o.LimitBytes = utilpointer.Int64(5)
+				},
+			),
+		},
+		{
+			name: "Get pod logs with sinceTime and sinceSeconds is rejected",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/namespaces/openshift-network2-operator/pods/network-operator2-7887564c4-mjg9d/log?container=container1&sinceSeconds=10&sinceTime=2022-03-04T18:12:00Z",
+				"at most one of `sinceTime` or `sinceSeconds` may be specified",
+				http.StatusBadRequest,
+			),
+		},
+		{
+			name: "Get init container logs concatenates rotated files",
+			run: verifyGetLogs(ctx,
+				corev1Client,
+				"openshift-network2-operator",
+				"network-operator2-7887564c4-mjg9d",
+				"Oldest first line\nOldest second line\nOlder first line\nOlder second line\nCurrent first line\nCurrent second line\n",
+				func(o *corev1.PodLogOptions) { o.Container = "init-container" },
+			),
+		},
+		{
+			name: "Get init container logs with tail spanning rotated files",
+			run: verifyGetLogs(ctx,
+				corev1Client,
+				"openshift-network2-operator",
+				"network-operator2-7887564c4-mjg9d",
+				"Oldest second line\nOlder first line\nOlder second line\nCurrent first line\nCurrent second line\n",
+				func(o *corev1.PodLogOptions) {
+					o.Container = "init-container"
+					o.TailLines = utilpointer.Int64(5)
+				},
+			),
+		},
+		{
+			name: "Get ephemeral container logs",
+			run: verifyGetLogs(ctx,
+				corev1Client,
+				"openshift-network2-operator",
+				"network-operator2-7887564c4-mjg9d",
+				"Debugging\n",
+				func(o *corev1.PodLogOptions) { o.Container = "debugger" },
+			),
+		},
+		{
+			name: "Get pod logs for container without logs lists containers with logs",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/namespaces/openshift-network2-operator/pods/network-operator2-7887564c4-mjg9d/log?container=container2",
+				`container "container2" in pod "network-operator2-7887564c4-mjg9d" has no logs in the dump, choose one of: [container1] or one of the init containers: [init-container] or one of the ephemeral containers: [debugger]`,
+				http.StatusBadRequest,
+			),
+		},
+		{
+			name: "Get previous pod logs for container that never terminated",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/namespaces/openshift-network2-operator/pods/network-operator2-7887564c4-mjg9d/log?container=container1&previous=true",
+				`previous terminated container "container1" in pod "network-operator2-7887564c4-mjg9d" not found, no container of this pod has logs in the dump`,
+				http.StatusBadRequest,
+			),
+		},
+		{
+			name: "Get node journal for unit",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/nodes/ip-10-0-143-10.ec2.internal/proxy/logs/journal?unit=crio",
+				"Mar 04 18:02:00 ip-10-0-143-10 crio[1000]: crio first\nMar 04 18:04:00 ip-10-0-143-10 crio[1000]: crio second\n",
+				http.StatusOK,
+			),
+		},
+		{
+			name: "Get node journal for multiple units with tail",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/nodes/ip-10-0-143-10.ec2.internal/proxy/logs/journal?unit=kubelet&unit=crio.service&tail=3",
+				"Mar 04 18:02:00 ip-10-0-143-10 crio[1000]: crio first\nMar 04 18:03:00 ip-10-0-143-10 kubenswrapper[1234]: kubelet second\nMar 04 18:04:00 ip-10-0-143-10 crio[1000]: crio second\n",
+				http.StatusOK,
+			),
+		},
+		{
+			name: "Get node logs through query interface",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/nodes/ip-10-0-143-10.ec2.internal/proxy/logs/?query=kubelet&tailLines=1",
+				"Mar 04 18:03:00 ip-10-0-143-10 kubenswrapper[1234]: kubelet second\n",
+				http.StatusOK,
+			),
+		},
+		{
+			name: "Get node logs through query interface with time window",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/nodes/ip-10-0-143-10.ec2.internal/proxy/logs/?query=kubelet&query=crio&sinceTime=2022-03-04T18:02:00Z&untilTime=2022-03-04T18:03:00Z",
+				"Mar 04 18:02:00 ip-10-0-143-10 crio[1000]: crio first\nMar 04 18:03:00 ip-10-0-143-10 kubenswrapper[1234]: kubelet second\n",
+				http.StatusOK,
+			),
+		},
+		{
+			name: "Get node log index",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/nodes/ip-10-0-143-10.ec2.internal/proxy/logs",
+				"<pre>\n<a href=\"journal\">journal</a>\n<a href=\"crio\">crio</a>\n<a href=\"kubelet\">kubelet</a>\n</pre>\n",
+				http.StatusOK,
+			),
+		},
+		{
+			name: "Get node log index with trailing slash",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/nodes/ip-10-0-143-10.ec2.internal/proxy/logs/",
+				"<pre>\n<a href=\"journal\">journal</a>\n<a href=\"crio\">crio</a>\n<a href=\"kubelet\">kubelet</a>\n</pre>\n",
+				http.StatusOK,
+			),
+		},
+		{
+			name: "Get node log of unit",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/nodes/ip-10-0-143-10.ec2.internal/proxy/logs/kubelet",
+				"Mar 04 18:01:00 ip-10-0-143-10 kubenswrapper[1234]: kubelet first\nMar 04 18:03:00 ip-10-0-143-10 kubenswrapper[1234]: kubelet second\n",
+				http.StatusOK,
+			),
+		},
+		{
+			name: "Get node file that is not a log",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/nodes/ip-10-0-143-10.ec2.internal/proxy/logs/crio/crio.conf",
+				"node ip-10-0-143-10.ec2.internal has no log \"crio/crio.conf\" in the dump\n",
+				http.StatusNotFound,
+			),
+		},
+		{
+			name: "Get node logs for node that does not exist",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/api/v1/nodes/does-not-exist/proxy/logs/journal",
+				"nodes \"does-not-exist\" not found\n",
+				http.StatusNotFound,
+			),
+		},
+		{
+			name: "Search logs across all pods",
+			run:  verifyLogSearch(ctx, cfg.Host, "regex=second", 7, nil),
+		},
+		{
+			name: "Search logs with label selector",
+			run:  verifyLogSearch(ctx, cfg.Host, "regex=second&labelSelector=name%3Dnetwork-operator", 2, nil),
+		},
+		{
+			name: "Search logs in namespace with time window",
+			run: verifyLogSearch(ctx, cfg.Host, "regex=First|Third&namespace=openshift-network2-operator&sinceTime=2022-03-04T18:12:00Z", 1, &logSearchMatch{
+				Namespace:  "openshift-network2-operator",
+				Pod:        "network-operator2-7887564c4-mjg9d",
+				Container:  "container1",
+				LineNumber: 4,
+				Timestamp:  time.Date(2022, 3, 4, 18, 19, 0, 500000000, time.UTC),
+				Line:       "Third line",
+			}),
+		},
+		{
+			name: "Search logs across rotated files that were cut off mid-line",
+			run: verifyLogSearch(ctx, cfg.Host, "regex=Older&namespace=openshift-network2-operator", 2, &logSearchMatch{
+				Namespace:  "openshift-network2-operator",
+				Pod:        "network-operator2-7887564c4-mjg9d",
+				Container:  "init-container",
+				LineNumber: 3,
+				Line:       "Older first line",
+			}),
+		},
+		{
+			name: "Search logs with invalid regex",
+			run: verifyGetLogsRaw(ctx,
+				cfg.Host+"/static-kas/v1/logs/search?regex=(",
+				"failed to compile regex: error parsing regexp: missing closing ): `(`\n",
+				http.StatusBadRequest,
+			),
+		},
+		{
+			name: "Get status subresource",
+			run:  verifyGetStatus(ctx, c, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-network-operator", Name: "network-operator"}}),
+		},
+		{
+			name: "Get status subresource of a cluster-scoped core resource",
+			run:  verifyGetStatus(ctx, c, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "ip-10-0-143-10.ec2.internal"}}),
+		},
+		{
+			name: "Get scale subresource",
+			run: verifyGetScale(ctx, c, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-network-operator", Name: "network-operator"}}, &autoscalingv1.Scale{
+				Spec:   autoscalingv1.ScaleSpec{Replicas: 1},
+				Status: autoscalingv1.ScaleStatus{Replicas: 1, Selector: "name=network-operator"},
+			}),
+		},
+		{
+			name: "Get scale subresource of resource without scale subresource returns NotFound",
+			run: func(t *testing.T) {
+				err := c.SubResource("scale").Get(ctx, &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-monitoring", Name: "node-exporter"}}, &autoscalingv1.Scale{})
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("expected NotFound error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Dry-run patch of scale subresource returns updated scale",
+			run: func(t *testing.T) {
+				deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-network-operator", Name: "network-operator"}}
+				scale := &autoscalingv1.Scale{}
+				patch := client.RawPatch(types.MergePatchType, []byte(`{"spec":{"replicas":3}}`))
+				if err := c.SubResource("scale").Patch(ctx, deployment, patch, client.WithSubResourceBody(scale), client.DryRunAll); err != nil {
+					t.Fatalf("failed to patch scale: %v", err)
+				}
+				if scale.Name != "network-operator" || scale.Spec.Replicas != 3 || scale.Status.Replicas != 1 {
+					t.Errorf("expected scale of network-operator with 3 desired and 1 current replicas, got %+v", scale)
+				}
+			},
+		},
+		{
+			name: "Dry-run update of scale subresource returns updated scale",
+			run: func(t *testing.T) {
+				deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-network-operator", Name: "network-operator"}}
+				scale := &autoscalingv1.Scale{Spec: autoscalingv1.ScaleSpec{Replicas: 0}}
+				if err := c.SubResource("scale").Update(ctx, deployment, client.WithSubResourceBody(scale), client.DryRunAll); err != nil {
+					t.Fatalf("failed to update scale: %v", err)
+				}
+				if scale.Name != "network-operator" || scale.Spec.Replicas != 0 || scale.Status.Selector != "name=network-operator" {
+					t.Errorf("expected scale of network-operator with 0 desired replicas, got %+v", scale)
+				}
+			},
+		},
+		{
+			name: "Patch of scale subresource without dry-run is not allowed",
+			run: func(t *testing.T) {
+				deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-network-operator", Name: "network-operator"}}
+				patch := client.RawPatch(types.MergePatchType, []byte(`{"spec":{"replicas":3}}`))
+				err := c.SubResource("scale").Patch(ctx, deployment, patch, client.WithSubResourceBody(&autoscalingv1.Scale{}))
+				if !apierrors.IsMethodNotSupported(err) {
+					t.Errorf("expected MethodNotAllowed error, got %v", err)
+				}
+			},
+		},
+		{
+			name: "Discovery advertises status and scale subresources",
+			run: verifyDiscoveryResources(cfg, "apps/v1",
+				[]string{"deployments/status", "deployments/scale", "daemonsets/status"},
+				[]string{"daemonsets/scale"},
+			),
+		},
+		{
+			name: "Discovery advertises names and categories of built-in resources",
+			run:  verifyDiscoveryNames(cfg, "v1", "configmaps", "configmap", []string{"cm"}, nil, []string{"get", "list", "watch"}),
+		},
+		{
+			name: "Discovery advertises the verbs of namespaces from the built-in table",
+			run:  verifyDiscoveryNames(cfg, "v1", "namespaces", "namespace", []string{"ns"}, nil, []string{"get", "list", "watch"}),
+		},
+		{
+			name: "Discovery advertises the all category for pods",
+			run:  verifyDiscoveryNames(cfg, "v1", "pods", "pod", []string{"po"}, []string{"all"}, []string{"get", "list", "watch"}),
+		},
+		{
+			name: "Discovery advertises the api-extensions category",
+			run:  verifyDiscoveryNames(cfg, "apiextensions.k8s.io/v1", "customresourcedefinitions", "customresourcedefinition", []string{"crd", "crds"}, []string{"api-extensions"}, []string{"get", "list", "watch"}),
+		},
+		{
+			name: "Discovery advertises names of custom resources from their CRD",
+			run:  verifyDiscoveryNames(cfg, "config.openshift.io/v1", "clusteroperators", "clusteroperator", []string{"co"}, nil, []string{"get", "list", "watch"}),
+		},
+		{
+			name: "Discovery advertises all served versions of CRDs without instances",
+			run:  verifyDiscoveryResources(cfg, "machine.openshift.io/v1alpha1", []string{"machinehealthchecks", "machinehealthchecks/status"}, nil),
+		},
+		{
+			name: "Discovery advertises all served versions of a group in the group list",
+			run:  verifyGroupVersions(cfg, "machine.openshift.io", "machine.openshift.io/v1beta1", []string{"machine.openshift.io/v1beta1", "machine.openshift.io/v1alpha1"}),
+		},
+		{
+			name: "List CRD without instances returns empty list",
+			run:  verifyList(ctx, c, unstructuredListFor("machine.openshift.io/v1beta1", "MachineHealthCheckList"), 0, client.InNamespace("openshift-machine-api")),
+		},
+		{
+			name: "List CRD without instances across namespaces returns empty list",
+			run:  verifyList(ctx, c, unstructuredListFor("machine.openshift.io/v1beta1", "MachineHealthCheckList"), 0),
+		},
+		{
+			name: "Discovery advertises built-in resources of APIServices without instances",
+			run:  verifyDiscoveryNames(cfg, "coordination.k8s.io/v1", "leases", "lease", nil, nil, []string{"get", "list", "watch"}),
+		},
+		{
+			name: "List built-in resource of APIService without instances returns empty list",
+			run:  verifyList(ctx, c, &coordinationv1.LeaseList{}, 0),
+		},
+		{
+			name: "Exec allowed command operates on the files in the dump",
+			run: verifyExec(ctx, cfg, corev1Client,
+				"openshift-network-operator",
+				"network-operator-7887564c4-mjg9d",
+				[]string{"cat", "/logs/current.log"},
+				"Current first line\nCurrent second line\n",
+				"",
+			),
+		},
+		{
+			name: "Exec allowed command that fails returns non-zero exit code",
+			run: verifyExec(ctx, cfg, corev1Client,
+				"openshift-network-operator",
+				"network-operator-7887564c4-mjg9d",
+				[]string{"cat", "../../../../../../version.json"},
+				"",
+				"command terminated with exit code 1",
+			),
+		},
+		{
+			name: "Exec disallowed command explains static snapshot",
+			run: verifyExec(ctx, cfg, corev1Client,
+				"openshift-network-operator",
+				"network-operator-7887564c4-mjg9d",
+				[]string{"sh"},
+				"",
+				"static-kas serves a static snapshot of a cluster, there are no running containers, only the following read-only commands are available: cat, ls",
+			),
+		},
+		{
+			name: "List response is sorted",
+			run: func(t *testing.T) {
+				podList := &corev1.PodList{}
+				if err := c.List(ctx, podList); err != nil {
+					t.Fatalf("failed to list pods: %v", err)
+				}
+				isSorted := sort.SliceIsSorted(podList.Items, func(a, b int) bool {
+					if podList.Items[a].CreationTimestamp != podList.Items[b].CreationTimestamp {
+						return podList.Items[a].CreationTimestamp.Before(&podList.Items[b].CreationTimestamp)
+					}
+					return podList.Items[a].Name < podList.Items[b].Name
+				})
+				if !isSorted {
+					t.Error("resulting pod list is not sorted by creationTimestamp")
+				}
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.run(t)
+		})
+	}
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	ctx := context.Background()
+	certs, err := authentication.GenerateCertificates("cert-user", []string{"cert-group"})
+	if err != nil {
+		t.Fatalf("failed to generate certificates: %v", err)
+	}
+	tlsConfig, err := certs.ServingTLSConfig()
+	if err != nil {
+		t.Fatalf("failed to construct tls config: %v", err)
+	}
+	tokenFile := filepath.Join(t.TempDir(), "tokens.csv")
+	if err := os.WriteFile(tokenFile, []byte("secret-token,token-user,1\n"), 0600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+	authenticator, err := authentication.New(authentication.Options{ClientCA: tlsConfig.ClientCAs, TokenFile: tokenFile})
+	if err != nil {
+		t.Fatalf("failed to construct authenticator: %v", err)
+	}
+	h, err := handler.New(zaptest.NewLogger(t), "./testdata", handler.WithAuthenticator(authenticator))
+	if err != nil {
+		t.Fatalf("failed to construct server: %v", err)
+	}
+	server := httptest.NewUnstartedServer(h)
+	server.TLS = tlsConfig
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	withoutCert := &rest.Config{
+		Host:            server.URL,
+		TLSClientConfig: rest.TLSClientConfig{CAData: certs.CACert},
+		ContentConfig:   rest.ContentConfig{ContentType: "application/json"},
+	}
+	withCert := rest.CopyConfig(withoutCert)
+	withCert.CertData, withCert.KeyData = certs.ClientCert, certs.ClientKey
+
+	t.Run("Client certificate authenticates its common name", verifySelfSubjectReview(ctx, withCert, "cert-user"))
+	t.Run("Request without client certificate is unauthorized", verifySelfSubjectReviewError(ctx, withoutCert, apierrors.IsUnauthorized))
+	t.Run("Request without client certificate can use a token", func(t *testing.T) {
+		withToken := rest.CopyConfig(withoutCert)
+		withToken.BearerToken = "secret-token"
+		verifySelfSubjectReview(ctx, withToken, "token-user")(t)
+	})
+	t.Run("Serving certificate is valid for additional hosts", func(t *testing.T) {
+		hostCerts, err := authentication.GenerateCertificates("cert-user", nil, "static-kas.example.com", "10.0.0.1")
+		if err != nil {
+			t.Fatalf("failed to generate certificates: %v", err)
+		}
+		block, _ := pem.Decode(hostCerts.ServerCert)
+		if block == nil {
+			t.Fatal("failed to decode serving certificate")
+		}
+		servingCert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("failed to parse serving certificate: %v", err)
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(hostCerts.CACert)
+		for _, host := range []string{"localhost", "127.0.0.1", "static-kas.example.com", "10.0.0.1"} {
+			if _, err := servingCert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+				t.Errorf("expected serving certificate to be valid for %s: %v", host, err)
+			}
+		}
+	})
+	t.Run("Client certificate of another CA fails the handshake", func(t *testing.T) {
+		otherCerts, err := authentication.GenerateCertificates("cert-user", nil)
+		if err != nil {
+			t.Fatalf("failed to generate certificates: %v", err)
+		}
+		withOtherCert := rest.CopyConfig(withoutCert)
+		withOtherCert.CertData, withOtherCert.KeyData = otherCerts.ClientCert, otherCerts.ClientKey
+		if _, err := selfSubjectReview(ctx, withOtherCert, "v1"); err == nil || apierrors.ReasonForError(err) != metav1.StatusReasonUnknown {
+			t.Errorf("expected a tls error, got %v", err)
+		}
+	})
+}
+
+func TestPodLogsDefaultContainer(t *testing.T) {
+	baseDir := writeDump(t, map[string]string{
+		"namespaces/app/core/pods.yaml": `apiVersion: v1
+kind: PodList
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: app
+    namespace: app
+    annotations:
+      kubectl.kubernetes.io/default-container: main
+  spec:
+    containers:
+    - name: sidecar
+    - name: main
+`,
+		"namespaces/app/pods/app/sidecar/sidecar/logs/current.log": "sidecar log\n",
+		"namespaces/app/pods/app/main/main/logs/current.log":       "main log\n",
+	})
+	client, err := corev1client.NewForConfig(serveDump(t, baseDir))
+	if err != nil {
+		t.Fatalf("failed to construct client: %v", err)
+	}
+
+	logs, err := client.Pods("app").GetLogs("app", &corev1.PodLogOptions{}).DoRaw(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get logs: %v", err)
+	}
+	if string(logs) != "main log\n" {
+		t.Errorf("expected the logs of the default container, got %q", string(logs))
+	}
+}
+
+func TestPodLogsRotated(t *testing.T) {
+	compressed := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(compressed)
+	if _, err := gzipWriter.Write([]byte("compressed line\n")); err != nil {
+		t.Fatalf("failed to compress log: %v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("failed to compress log: %v", err)
+	}
+	baseDir := writeDump(t, map[string]string{
+		"namespaces/app/core/pods.yaml": "apiVersion: v1\nkind: PodList\nitems:\n- apiVersion: v1\n  kind: Pod\n  metadata:\n    name: app\n    namespace: app\n  spec:\n    containers:\n    - name: app\n",
+		// Cut off without a trailing newline
+		"namespaces/app/pods/app/app/app/logs/current.log.1":  "rotated line",
+		"namespaces/app/pods/app/app/app/logs/current.log.gz": compressed.String(),
+		"namespaces/app/pods/app/app/app/logs/current.log":    "current line\n",
+	})
+	client, err := corev1client.NewForConfig(serveDump(t, baseDir))
+	if err != nil {
+		t.Fatalf("failed to construct client: %v", err)
+	}
+
+	logs, err := client.Pods("app").GetLogs("app", &corev1.PodLogOptions{}).DoRaw(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get logs: %v", err)
+	}
+	if expected := "rotated line\ncompressed line\ncurrent line\n"; string(logs) != expected {
+		t.Errorf("expected logs %q, got %q", expected, string(logs))
+	}
+}
+
+func TestExecSymlinks(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "shadow")
+	if err := os.WriteFile(outside, []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("failed to write file outside of the dump: %v", err)
+	}
+	baseDir := writeDump(t, map[string]string{
+		"namespaces/app/core/pods.yaml":                    "apiVersion: v1\nkind: PodList\nitems:\n- apiVersion: v1\n  kind: Pod\n  metadata:\n    name: app\n    namespace: app\n  spec:\n    containers:\n    - name: app\n",
+		"namespaces/app/pods/app/app/app/logs/current.log": "current line\n",
+	})
+	logsDir := filepath.Join(baseDir, "namespaces/app/pods/app/app/app/logs")
+	if err := os.Symlink(outside, filepath.Join(logsDir, "outside.log")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Symlink("current.log", filepath.Join(logsDir, "inside.log")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(filepath.Dir(outside), filepath.Join(logsDir, "outside")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	cfg := serveDump(t, baseDir, handler.WithExecCommands("cat", "head", "tail", "ls"))
+	client, err := corev1client.NewForConfig(cfg)
+	if err != nil {
+		t.Fatalf("failed to construct client: %v", err)
+	}
+
+	ctx := context.Background()
+	t.Run("Symlink within the container is followed", verifyExec(ctx, cfg, client, "app", "app", []string{"cat", "/logs/inside.log"}, "current line\n", ""))
+	for _, command := range [][]string{
+		{"cat", "/logs/outside.log"},
+		{"head", "/logs/outside.log"},
+		{"tail", "/logs/outside.log"},
+		{"ls", "/logs/outside"},
+	} {
+		t.Run(command[0]+" does not follow symlink out of the dump", verifyExec(ctx, cfg, client, "app", "app", command, "", "command terminated with exit code 1"))
+	}
+}
+
+func TestNodeJournalAcrossNewYear(t *testing.T) {
+	baseDir := writeDump(t, map[string]string{
+		"timestamp":                                "2023-01-01 00:10:00.000000000 +0000 UTC m=+0.1\n",
+		"namespaces/default/default.yaml":          "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: default\n",
+		"cluster-scoped-resources/core/nodes.yaml": "apiVersion: v1\nkind: NodeList\nitems:\n- apiVersion: v1\n  kind: Node\n  metadata:\n    name: node\n",
+		"host_service_logs/masters/kubelet_service.log": "Dec 31 23:58:00 node kubelet[1]: old year\n" +
+			"Jan 01 00:01:00 node kubelet[1]: new year\n",
+		"host_service_logs/masters/crio_service.log": "Dec 31 23:59:00 node crio[1]: old year\n",
+	})
+	cfg := serveDump(t, baseDir)
+
+	verifyGetLogsRaw(context.Background(),
+		cfg.Host+"/api/v1/nodes/node/proxy/logs/journal",
+		"Dec 31 23:58:00 node kubelet[1]: old year\nDec 31 23:59:00 node crio[1]: old year\nJan 01 00:01:00 node kubelet[1]: new year\n",
+		http.StatusOK,
+	)(t)
+	verifyGetLogsRaw(context.Background(),
+		cfg.Host+"/api/v1/nodes/node/proxy/logs/?query=kubelet&sinceTime=2022-12-31T23:59:00Z",
+		"Jan 01 00:01:00 node kubelet[1]: new year\n",
+		http.StatusOK,
+	)(t)
+}
+
+func TestLogSearchErrors(t *testing.T) {
+	baseDir := writeDump(t, map[string]string{
+		"namespaces/app/core/pods.yaml":                             "apiVersion: v1\nkind: PodList\nitems:\n- apiVersion: v1\n  kind: Pod\n  metadata:\n    name: app\n    namespace: app\n  spec:\n    containers:\n    - name: broken\n    - name: working\n",
+		"namespaces/app/pods/app/broken/broken/logs/current.log.gz": "not gzipped\n",
+		"namespaces/app/pods/app/working/working/logs/current.log":  "needle\n",
+	})
+	cfg := serveDump(t, baseDir)
+
+	t.Run("A log that can not be read is skipped", verifyLogSearch(context.Background(), cfg.Host, "regex=needle", 1, &logSearchMatch{
+		Namespace:  "app",
+		Pod:        "app",
+		Container:  "working",
+		LineNumber: 1,
+		Line:       "needle",
+	}))
+	t.Run("Invalid label selector is a bad request", func(t *testing.T) {
+		resp, err := http.Get(cfg.Host + "/static-kas/v1/logs/search?regex=needle&labelSelector=" + url.QueryEscape("app in ("))
+		if err != nil {
+			t.Fatalf("failed to search logs: %v", err)
+		}
+		defer resp.Body.Close()
+		status := &metav1.Status{}
+		if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+			t.Fatalf("failed to decode status: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest || status.Reason != metav1.StatusReasonBadRequest {
+			t.Errorf("expected a BadRequest status, got code %d and status %+v", resp.StatusCode, status)
+		}
+	})
+}
+
+func TestDiagnostics(t *testing.T) {
+	files := map[string]string{
+		"namespaces/foo/core/configmaps.yaml":          "apiVersion: v1\nkind: ConfigMapList\nitems:\n- metadata:\n    name: wrong-namespace\n    namespace: bar\n",
+		"namespaces/foo/core/services.yaml":            "apiVersion: v1\nkind: ServiceList\nitems: []\n",
+		"namespaces/foo/core/endpoints.yaml":           "",
+		"namespaces/foo/core/pods.yaml":                "apiVersion: v1\nkind: PodList\nitems:\n- apiVersion: v1\n  kind: Pod\n  metadata:\n    name: duplicate\n    namespace: foo\n",
+		"namespaces/foo/pods/duplicate/duplicate.yaml": "apiVersion: v1\nkind: Pod\nmetadata:\n  name: duplicate\n  namespace: foo\n",
+		"namespaces/foo/example.com/widgets.yaml":      "apiVersion: example.com/v1\nkind: WidgetList\nitems:\n- apiVersion: example.com/v1\n  kind: Widget\n  metadata:\n    name: widget\n    namespace: foo\n",
+		"pod.yaml": "apiVersion: v1\nkind: Pod\nmetadata:\n  name: pod\n  namespace: foo\n",
+	}
+	expected := map[discoverypkg.ProblemType]string{
+		discoverypkg.ProblemNamespaceMismatch:   "namespaces/foo/core/configmaps.yaml",
+		discoverypkg.ProblemEmptyList:           "namespaces/foo/core/services.yaml",
+		discoverypkg.ProblemEmptyFile:           "namespaces/foo/core/endpoints.yaml",
+		discoverypkg.ProblemDuplicateObject:     "namespaces/foo/pods/duplicate/duplicate.yaml",
+		discoverypkg.ProblemUnknownKind:         "namespaces/foo/example.com/widgets.yaml",
+		discoverypkg.ProblemUnknownResourceName: "pod.yaml",
+	}
+
+	// Problems that don't prevent serving the dump are served by the diagnostics endpoint
+	cfg := serveDump(t, writeDump(t, files))
+	resp, err := http.Get(cfg.Host + "/static-kas/v1/diagnostics")
+	if err != nil {
+		t.Fatalf("failed to get diagnostics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	report := &discoverypkg.Report{}
+	if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+		t.Fatalf("failed to decode diagnostics: %v", err)
+	}
+	if actual := problemPaths(report); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected problems %v, got %v", expected, actual)
+	}
+
+	// Unparsable files make serving fail, validate still reports everything
+	files["namespaces/foo/core/secrets.yaml"] = "apiVersion: v1\nkind: SecretList\nitems: [\n  - a: b: c\n"
+	expected[discoverypkg.ProblemParseError] = "namespaces/foo/core/secrets.yaml"
+	baseDir := writeDump(t, files)
+	if _, err := handler.New(zaptest.NewLogger(t), baseDir); err == nil {
+		t.Error("expected constructing the server to fail for a dump with a parse error")
+	}
+	report, err = discoverypkg.Diagnose(baseDir, nil)
+	if err != nil {
+		t.Fatalf("failed to diagnose: %v", err)
+	}
+	if actual := problemPaths(report); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected problems %v, got %v", expected, actual)
+	}
+	for _, problem := range report.Problems {
+		if problem.Type == discoverypkg.ProblemParseError && problem.Line != 3 {
+			t.Errorf("expected parse error to be in line 3, got %d", problem.Line)
+		}
+	}
+}
+
+// problemPaths returns the path of each type of problem in the report.
+func problemPaths(report *discoverypkg.Report) map[discoverypkg.ProblemType]string {
+	result := map[discoverypkg.ProblemType]string{}
+	for _, problem := range report.Problems {
+		result[problem.Type] = problem.Path
+	}
+
+	return result
+}
+
+func TestObjectRepresentations(t *testing.T) {
+	ctx := context.Background()
+	podList := func(listedResourceVersion string) string {
+		return `apiVersion: v1
+kind: PodList
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: recreated
+    namespace: foo
+    uid: second
+    creationTimestamp: "2023-01-02T00:00:00Z"
+    resourceVersion: "10"
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: listed
+    namespace: foo
+    resourceVersion: "` + listedResourceVersion + `"
+`
+	}
+	baseDir := writeDump(t, map[string]string{
+		"namespaces/foo/core/pods.yaml": podList("5"),
+		// The first incarnation of the pod, from a different etcd with a higher resourceVersion
+		"namespaces/foo/pods/recreated/recreated.yaml": "apiVersion: v1\nkind: Pod\nmetadata:\n  name: recreated\n  namespace: foo\n  uid: first\n  creationTimestamp: \"2023-01-01T00:00:00Z\"\n  resourceVersion: \"20\"\n",
+	})
+	podClient, err := corev1client.NewForConfig(serveDump(t, baseDir))
+	if err != nil {
+		t.Fatalf("failed to construct client: %v", err)
+	}
+
+	pod, err := podClient.Pods("foo").Get(ctx, "recreated", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	if pod.UID != "second" {
+		t.Errorf("expected the pod that was created last to be served, got uid %s", pod.UID)
+	}
+	pods, err := podClient.Pods("foo").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list pods: %v", err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Name == "recreated" && pod.UID != "second" {
+			t.Errorf("expected the pod that was created last to be listed, got uid %s", pod.UID)
+		}
+	}
+
+	// Objects from lists are cached, but not beyond a change of the list
+	if _, err := podClient.Pods("foo").Get(ctx, "listed", metav1.GetOptions{}); err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(baseDir, "namespaces/foo/core/pods.yaml"), []byte(podList("600")), 0644); err != nil {
+		t.Fatalf("failed to update list: %v", err)
+	}
+	pod, err = podClient.Pods("foo").Get(ctx, "listed", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	if pod.ResourceVersion != "600" {
+		t.Errorf("expected the pod from the updated list, got resourceVersion %s", pod.ResourceVersion)
+	}
+}
+
+func TestHostedClusters(t *testing.T) {
+	ctx := context.Background()
+	baseDir := writeDump(t, map[string]string{
+		"namespaces/clusters/hypershift.openshift.io/hostedclusters/my.cluster.yaml":        "apiVersion: hypershift.openshift.io/v1beta1\nkind: HostedCluster\nmetadata:\n  name: my.cluster\n  namespace: clusters\n",
+		"namespaces/clusters-my-cluster/core/pods.yaml":                                     "apiVersion: v1\nkind: PodList\nitems:\n- apiVersion: v1\n  kind: Pod\n  metadata:\n    name: kube-apiserver-0\n    namespace: clusters-my-cluster\n  spec:\n    containers:\n    - name: kube-apiserver\n",
+		"namespaces/clusters-my-cluster/core/pods/logs/kube-apiserver-0-kube-apiserver.log": "control plane log\n",
+		"hostedcluster-my.cluster/namespaces/default/core/secrets.yaml":                     "apiVersion: v1\nkind: SecretList\nitems:\n- apiVersion: v1\n  kind: Secret\n  metadata:\n    name: guest\n    namespace: default\n",
+		"namespaces/clusters-my-cluster/rbac.authorization.k8s.io/roles.yaml":               "apiVersion: rbac.authorization.k8s.io/v1\nkind: RoleList\nitems:\n- apiVersion: rbac.authorization.k8s.io/v1\n  kind: Role\n  metadata:\n    name: pod-reader\n    namespace: clusters-my-cluster\n  rules:\n  - apiGroups: [\"\"]\n    resources: [pods]\n    verbs: [list]\n",
+		"namespaces/clusters-my-cluster/rbac.authorization.k8s.io/rolebindings.yaml":        "apiVersion: rbac.authorization.k8s.io/v1\nkind: RoleBindingList\nitems:\n- apiVersion: rbac.authorization.k8s.io/v1\n  kind: RoleBinding\n  metadata:\n    name: pod-reader\n    namespace: clusters-my-cluster\n  roleRef:\n    apiGroup: rbac.authorization.k8s.io\n    kind: Role\n    name: pod-reader\n  subjects:\n  - kind: ServiceAccount\n    name: control-plane-operator\n    namespace: clusters-my-cluster\n",
+	})
+
+	hostedClusters, err := hypershift.HostedClusters(baseDir)
+	if err != nil {
+		t.Fatalf("failed to find hosted clusters: %v", err)
+	}
+	if len(hostedClusters) != 1 {
+		t.Fatalf("expected one hosted cluster, got %d", len(hostedClusters))
+	}
+	if hostedClusters[0].ControlPlaneNamespace != "clusters-my-cluster" {
+		t.Errorf("expected control plane namespace clusters-my-cluster, got %s", hostedClusters[0].ControlPlaneNamespace)
+	}
+
+	// The dump of the hosted cluster must not leak into the one of the management cluster
+	managementDiscovery, err := discovery.NewDiscoveryClientForConfig(serveDump(t, baseDir))
+	if err != nil {
+		t.Fatalf("failed to construct discovery client: %v", err)
+	}
+	resources, err := managementDiscovery.ServerResourcesForGroupVersion("v1")
+	if err != nil {
+		t.Fatalf("failed to discover resources: %v", err)
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == "secrets" {
+			t.Error("expected secrets of the hosted cluster to not be discovered for the management cluster")
+		}
+	}
+
+	hostedCfg := serveDump(t, hostedClusters[0].GuestDir, handler.WithNamespaceDirs(hostedClusters[0].NamespaceDirs()))
+	hostedClient, err := corev1client.NewForConfig(hostedCfg)
+	if err != nil {
+		t.Fatalf("failed to construct client: %v", err)
+	}
+
+	namespaces, err := hostedClient.Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list namespaces: %v", err)
+	}
+	var namespaceNames []string
+	for _, namespace := range namespaces.Items {
+		namespaceNames = append(namespaceNames, namespace.Name)
+	}
+	if expected := []string{"clusters-my-cluster", "default"}; !reflect.DeepEqual(namespaceNames, expected) {
+		t.Errorf("expected namespaces %v, got %v", expected, namespaceNames)
+	}
+	pods, err := hostedClient.Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list pods: %v", err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != "kube-apiserver-0" {
+		t.Errorf("expected the control plane pod, got %v", pods.Items)
+	}
+	logs, err := hostedClient.Pods("clusters-my-cluster").GetLogs("kube-apiserver-0", &corev1.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		t.Fatalf("failed to get control plane logs: %v", err)
+	}
+	if string(logs) != "control plane log\n" {
+		t.Errorf("expected control plane log, got %q", string(logs))
+	}
+
+	// RBAC of the control plane namespace is read from the dump of the management cluster as well
+	t.Run("who-can", verifyWhoCan(ctx, hostedCfg.Host, "verb=list&resource=pods&namespace=clusters-my-cluster", []string{
+		"Group//system:masters",
+		"ServiceAccount/clusters-my-cluster/control-plane-operator",
+	}))
+}
+
+func TestOtherLayouts(t *testing.T) {
+	ctx := context.Background()
+	// Output of kubectl get -o yaml, which nests everything in a single list
+	all := `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: app
+    labels:
+      team: a
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: config
+    namespace: app
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: app
+    namespace: app
+- apiVersion: v1
+  kind: Node
+  metadata:
+    name: node
+- apiVersion: certificates.k8s.io/v1alpha1
+  kind: ClusterTrustBundle
+  metadata:
+    name: bundle
+`
+	dir := writeDump(t, map[string]string{"all.yaml": all})
+	baseDir, cleanup, err := layout.Normalize(filepath.Join(dir, "all.yaml"))
+	if err != nil {
+		t.Fatalf("failed to normalize: %v", err)
+	}
+	defer cleanup()
+
+	cfg := serveDump(t, baseDir)
+	normalizedClient, err := client.New(cfg, client.Options{})
+	if err != nil {
+		t.Fatalf("failed to construct client: %v", err)
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := normalizedClient.Get(ctx, client.ObjectKey{Name: "app"}, namespace); err != nil {
+		t.Fatalf("failed to get namespace: %v", err)
+	}
+	if namespace.Labels["team"] != "a" {
+		t.Errorf("expected namespace to be served from the dump, got labels %v", namespace.Labels)
+	}
+	if err := normalizedClient.Get(ctx, client.ObjectKey{Namespace: "app", Name: "config"}, &corev1.ConfigMap{}); err != nil {
+		t.Errorf("failed to get configmap: %v", err)
+	}
+	if err := normalizedClient.Get(ctx, client.ObjectKey{Namespace: "app", Name: "app"}, &appsv1.Deployment{}); err != nil {
+		t.Errorf("failed to get deployment: %v", err)
+	}
+	if err := normalizedClient.Get(ctx, client.ObjectKey{Name: "node"}, &corev1.Node{}); err != nil {
+		t.Errorf("failed to get node: %v", err)
+	}
+
+	// ClusterTrustBundles are cluster-scoped and only served with a feature gate, the scope must still be known
+	if _, err := os.Stat(filepath.Join(baseDir, "cluster-scoped-resources", "certificates.k8s.io", "clustertrustbundles.yaml")); err != nil {
+		t.Errorf("expected clustertrustbundles to be written as cluster-scoped resource: %v", err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		t.Fatalf("failed to construct discovery client: %v", err)
+	}
+	resources, err := discoveryClient.ServerResourcesForGroupVersion("certificates.k8s.io/v1alpha1")
+	if err != nil {
+		t.Fatalf("failed to discover certificates.k8s.io/v1alpha1: %v", err)
+	}
+	var foundBundles bool
+	for _, resource := range resources.APIResources {
+		if resource.Name != "clustertrustbundles" {
+			continue
+		}
+		foundBundles = true
+		if resource.Namespaced {
+			t.Errorf("expected clustertrustbundles to be cluster-scoped")
+		}
+	}
+	if !foundBundles {
+		t.Errorf("expected clustertrustbundles to be discovered, got %+v", resources.APIResources)
+	}
+
+	// oc adm inspect nests the dump in an inspect.local.<id> directory
+	inspectDir := filepath.Join(dir, "inspect", "inspect.local.1234")
+	if err := os.MkdirAll(filepath.Join(inspectDir, "namespaces", "app"), 0755); err != nil {
+		t.Fatalf("failed to create inspect dir: %v", err)
+	}
+	baseDir, _, err = layout.Normalize(filepath.Join(dir, "inspect"))
+	if err != nil {
+		t.Fatalf("failed to normalize: %v", err)
+	}
+	if baseDir != inspectDir {
+		t.Errorf("expected base dir %s, got %s", inspectDir, baseDir)
+	}
+
+	// Dumps of multiple clusters are served on their own and must not be merged into one
+	multiDir := writeDump(t, map[string]string{
+		"cluster-a/must-gather.local.1/namespaces/a/core/configmaps.yaml": "apiVersion: v1\nkind: ConfigMapList\nitems:\n- apiVersion: v1\n  kind: ConfigMap\n  metadata:\n    name: a\n    namespace: a\n",
+		"cluster-b/namespaces/b/core/configmaps.yaml":                     "apiVersion: v1\nkind: ConfigMapList\nitems:\n- apiVersion: v1\n  kind: ConfigMap\n  metadata:\n    name: b\n    namespace: b\n",
+		"notes.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: notes\n",
+	})
+	baseDir, _, err = layout.Normalize(multiDir)
+	if err != nil {
+		t.Fatalf("failed to normalize: %v", err)
+	}
+	if baseDir != multiDir {
+		t.Errorf("expected base dir with multiple dumps %s to be used as-is, got %s", multiDir, baseDir)
+	}
+}
+
+func TestDiagnoseManifests(t *testing.T) {
+	dir := writeDump(t, map[string]string{
+		"app.yaml":         "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: one\n  namespace: app\n---\napiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: widget\n",
+		"broken.yaml":      "# The first document is fine\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: fine\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: broken\n namespace: app\n",
+		"empty.yaml":       "",
+		"nested/copy.json": `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "one", "namespace": "app"}}`,
+	})
+
+	// Problems are reported for the files that are converted, not for the converted copy
+	report, err := layout.Diagnose(dir)
+	if err != nil {
+		t.Fatalf("failed to diagnose: %v", err)
+	}
+	type problem struct {
+		Type discoverypkg.ProblemType
+		Path string
+		Line int
+	}
+	var problems []problem
+	for _, p := range report.Problems {
+		problems = append(problems, problem{Type: p.Type, Path: p.Path, Line: p.Line})
+	}
+	expected := []problem{
+		{Type: discoverypkg.ProblemUnknownKind, Path: "app.yaml"},
+		{Type: discoverypkg.ProblemParseError, Path: "broken.yaml", Line: 10},
+		{Type: discoverypkg.ProblemEmptyFile, Path: "empty.yaml"},
+		{Type: discoverypkg.ProblemDuplicateObject, Path: filepath.Join("nested", "copy.json")},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected problems %+v, got %+v", expected, report.Problems)
+	}
+	if !report.HasErrors() {
+		t.Error("expected the parse error to be an error")
+	}
+
+	// Normalize fails with the same line
+	if _, _, err := layout.Normalize(filepath.Join(dir, "broken.yaml")); err == nil || !strings.Contains(err.Error(), "line 10:") {
+		t.Errorf("expected the error of normalizing broken.yaml to name line 10, got %v", err)
+	}
+}
+
+func TestEtcdSnapshot(t *testing.T) {
+	ctx := context.Background()
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	db, err := bolt.Open(snapshot, 0600, nil)
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "pod"},
+	}
+	encodedPod := &bytes.Buffer{}
+	if err := protobuf.NewSerializer(legacyscheme.Scheme, legacyscheme.Scheme).Encode(pod, encodedPod); err != nil {
+		t.Fatalf("failed to encode pod: %v", err)
+	}
+	revisions := []struct {
+		key       string
+		value     string
+		tombstone bool
+	}{
+		{key: "/kubernetes.io/configmaps/app/config", value: `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"namespace":"app","name":"config"},"data":{"version":"old"}}`},
+		{key: "/kubernetes.io/pods/app/pod", value: encodedPod.String()},
+		{key: "/kubernetes.io/configmaps/app/config", value: `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"namespace":"app","name":"config"},"data":{"version":"new"}}`},
+		{key: "/kubernetes.io/secrets/app/deleted", value: `{"apiVersion":"v1","kind":"Secret","metadata":{"namespace":"app","name":"deleted"}}`},
+		{key: "/kubernetes.io/secrets/app/deleted", tombstone: true},
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("key"))
+		if err != nil {
+			return err
+		}
+		for idx, revision := range revisions {
+			revisionKey := make([]byte, 17, 18)
+			binary.BigEndian.PutUint64(revisionKey, uint64(idx+1))
+			revisionKey[8] = '_'
+			if revision.tombstone {
+				revisionKey = append(revisionKey, 't')
+			}
+			kv := &mvccpb.KeyValue{Key: []byte(revision.key), ModRevision: int64(idx + 1), Value: []byte(revision.value)}
+			serialized, err := kv.Marshal()
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(revisionKey, serialized); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close snapshot: %v", err)
+	}
+
+	objects, err := etcd.Read(zaptest.NewLogger(t), snapshot)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %v", err)
+	}
+	baseDir, cleanup, err := layout.FromObjects(objects)
+	if err != nil {
+		t.Fatalf("failed to convert snapshot: %v", err)
+	}
+	defer cleanup()
+	snapshotClient, err := corev1client.NewForConfig(serveDump(t, baseDir))
+	if err != nil {
+		t.Fatalf("failed to construct client: %v", err)
+	}
+
+	servedPod, err := snapshotClient.Pods("app").Get(ctx, "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	if servedPod.ResourceVersion != "2" {
+		t.Errorf("expected pod to have resourceVersion 2, got %s", servedPod.ResourceVersion)
+	}
+	configMap, err := snapshotClient.ConfigMaps("app").Get(ctx, "config", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get configmap: %v", err)
+	}
+	if configMap.Data["version"] != "new" || configMap.ResourceVersion != "3" {
+		t.Errorf("expected latest revision 3 of the configmap, got revision %s with data %v", configMap.ResourceVersion, configMap.Data)
+	}
+	if _, err := snapshotClient.Secrets("app").Get(ctx, "deleted", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected deleted secret to not be found, got error %v", err)
+	}
+}
+
+func TestAuditLogs(t *testing.T) {
+	ctx := context.Background()
+	configMap := func(name, resourceVersion string) string {
+		return `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"namespace":"app","name":"` + name + `","resourceVersion":"` + resourceVersion + `"}}`
+	}
+	event := func(verb, name, user, timestamp, responseObject string) string {
+		return `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"RequestResponse","auditID":"` + name + `-` + verb + `","stage":"ResponseComplete",` +
+			`"verb":"` + verb + `","user":{"username":"` + user + `"},"objectRef":{"resource":"configmaps","namespace":"app","name":"` + name + `","apiVersion":"v1"},` +
+			`"responseStatus":{"code":200},"responseObject":` + responseObject + `,"stageTimestamp":"` + timestamp + `"}`
+	}
+	baseDir := writeDump(t, map[string]string{
+		"namespaces/app/core/configmaps.yaml": "apiVersion: v1\nkind: ConfigMapList\nitems:\n- " + configMap("config", "5") + "\n",
+		"audit_logs/kube-apiserver/master-0-audit.log": strings.Join([]string{
+			event("create", "gone", "alice", "2023-01-01T00:00:00.000000Z", configMap("gone", "2")),
+			event("create", "config", "alice", "2023-01-01T00:01:00.000000Z", configMap("config", "3")),
+			event("get", "config", "carol", "2023-01-01T00:01:30.000000Z", configMap("config", "3")),
+			event("delete", "gone", "bob", "2023-01-01T00:03:00.000000Z", `{"kind":"Status","apiVersion":"v1","status":"Success"}`),
+		}, "\n"),
+		// Cut off while the dump was taken, the events before the cut still count
+		"audit_logs/kube-apiserver/master-1-audit.log.gz": truncatedGzip(t, event("update", "config", "bob", "2023-01-01T00:02:00.000000Z", configMap("config", "4"))+"\n"),
+	})
+	cfg := serveDump(t, baseDir, handler.WithAuditReplay())
+
+	resp, err := http.Get(cfg.Host + "/static-kas/v1/history/core/configmaps/app/config")
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	defer resp.Body.Close()
+	var history struct {
+		Changes []struct {
+			Verb string `json:"verb"`
+			User string `json:"user"`
+		} `json:"changes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatalf("failed to decode history: %v", err)
+	}
+	var changes []string
+	for _, change := range history.Changes {
+		changes = append(changes, change.Verb+" by "+change.User)
+	}
+	if expected := []string{"create by alice", "update by bob"}; !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+
+	auditClient, err := corev1client.NewForConfig(cfg)
+	if err != nil {
+		t.Fatalf("failed to construct client: %v", err)
+	}
+	watchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	watcher, err := auditClient.ConfigMaps("app").Watch(watchCtx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to watch configmaps: %v", err)
+	}
+	defer watcher.Stop()
+	expected := []string{"ADDED gone 2", "ADDED config 3", "MODIFIED config 4", "MODIFIED config 5", "DELETED gone 2"}
+	var actual []string
+	for len(actual) < len(expected) {
+		select {
+		case event := <-watcher.ResultChan():
+			object := event.Object.(*corev1.ConfigMap)
+			actual = append(actual, fmt.Sprintf("%s %s %s", event.Type, object.Name, object.ResourceVersion))
+		case <-watchCtx.Done():
+			t.Fatalf("timed out waiting for events, got %v", actual)
+		}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected events %v, got %v", expected, actual)
+	}
+}
+
+func TestServers(t *testing.T) {
+	ctx := context.Background()
+	configMap := func(value, resourceVersion string) string {
+		return `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"namespace":"app","name":"a","resourceVersion":"` + resourceVersion + `"},"data":{"value":"` + value + `"}}`
+	}
+	namespace := "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: kube-system\n  uid: cluster-uid\n"
+	baseDir := writeDump(t, map[string]string{
+		"must-gather.local.1/timestamp":                               "2023-01-01 10:00:00.000000000 +0000 UTC m=+0.1\n",
+		"must-gather.local.1/namespaces/kube-system/kube-system.yaml": namespace,
+		"must-gather.local.1/namespaces/app/core/configmaps.yaml":     "apiVersion: v1\nkind: ConfigMapList\nitems:\n- " + configMap("old", "10") + "\n",
+		"must-gather.local.2/timestamp":                               "2023-01-01 12:00:00.000000000 +0000 UTC m=+0.1\n",
+		"must-gather.local.2/namespaces/kube-system/kube-system.yaml": namespace,
+		"must-gather.local.2/namespaces/app/core/configmaps.yaml":     "apiVersion: v1\nkind: ConfigMapList\nitems:\n- " + configMap("new", "20") + "\n",
+		"must-gather.local.2/audit_logs/kube-apiserver/master-0-audit.log": `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"RequestResponse","auditID":"1","stage":"ResponseComplete",` +
+			`"verb":"update","user":{"username":"alice"},"objectRef":{"resource":"configmaps","namespace":"app","name":"a","apiVersion":"v1"},` +
+			`"responseStatus":{"code":200},"responseObject":` + configMap("between", "15") + `,"stageTimestamp":"2023-01-01T11:00:00.000000Z"}`,
+		"notes.txt": "two must-gathers of the same cluster\n",
+	})
+
+	servers, err := handler.Servers(zaptest.NewLogger(t), baseDir, handler.WithAuditReplay())
+	if err != nil {
+		t.Fatalf("failed to find servers: %v", err)
+	}
+	var names []string
+	serverURLs := map[string]string{}
+	for _, server := range servers {
+		names = append(names, strings.TrimPrefix(server.Name, baseDir+"/")+" "+strings.Join(server.Aliases, ","))
+		router, err := server.New()
+		if err != nil {
+			t.Fatalf("failed to construct server %s: %v", server.Name, err)
+		}
+		httpServer := httptest.NewServer(router)
+		t.Cleanup(httpServer.Close)
+		serverURLs[server.Name] = httpServer.URL
+	}
+	expectedNames := []string{
+		"must-gather.local.1 cluster-uid@2023-01-01T10:00:00Z",
+		"must-gather.local.2 cluster-uid@2023-01-01T12:00:00Z",
+		"cluster-uid ",
+	}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("expected servers %v, got %v", expectedNames, names)
+	}
+
+	// The timeline plays back the transitions between the dumps, a dump on its own its audit logs
+	for serverName, expected := range map[string][]string{
+		"cluster-uid": {"ADDED a=old 1", "MODIFIED a=new 2"},
+		filepath.Join(baseDir, "must-gather.local.2"): {"ADDED a=between 15", "MODIFIED a=new 20"},
+	} {
+		client, err := corev1client.NewForConfig(&rest.Config{Host: serverURLs[serverName], ContentConfig: rest.ContentConfig{ContentType: "application/json"}})
+		if err != nil {
+			t.Fatalf("failed to construct client: %v", err)
+		}
+		watchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		watcher, err := client.ConfigMaps("app").Watch(watchCtx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to watch configmaps of %s: %v", serverName, err)
+		}
+		defer watcher.Stop()
+		var actual []string
+		for len(actual) < len(expected) {
+			select {
+			case event := <-watcher.ResultChan():
+				object := event.Object.(*corev1.ConfigMap)
+				actual = append(actual, fmt.Sprintf("%s %s=%s %s", event.Type, object.Name, object.Data["value"], object.ResourceVersion))
+			case <-watchCtx.Done():
+				t.Fatalf("timed out waiting for events of %s, got %v", serverName, actual)
+			}
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected events of %s %v, got %v", serverName, expected, actual)
+		}
+	}
+}
+
+// truncatedGzip returns content compressed with gzip and cut off after it, like a log that is still being written.
+func truncatedGzip(t *testing.T, content string) string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write([]byte(content)); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if _, err := writer.Write([]byte(`{"kind":"Event","apiVersion":"audit.k8s.io/v1"`)); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+
+	return buf.String()
+}
+
+func TestAuditLogObjectLimit(t *testing.T) {
+	var events []string
+	for i := 1; i <= 250; i++ {
+		configMap := fmt.Sprintf(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"namespace":"app","name":"busy","resourceVersion":"%d"}}`, i)
+		events = append(events, `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"RequestResponse","auditID":"`+fmt.Sprint(i)+`","stage":"ResponseComplete",`+
+			`"verb":"update","user":{"username":"controller"},"objectRef":{"resource":"configmaps","namespace":"app","name":"busy","apiVersion":"v1"},`+
+			`"responseStatus":{"code":200},"responseObject":`+configMap+`,"stageTimestamp":"`+time.Date(2023, 1, 1, 0, 0, i, 0, time.UTC).Format(metav1.RFC3339Micro)+`"}`)
+	}
+	// The logs of different apiservers overlap in time and are not read in order
+	baseDir := writeDump(t, map[string]string{
+		"namespaces/app/core/configmaps.yaml":          "apiVersion: v1\nkind: ConfigMapList\nitems: []\n",
+		"audit_logs/kube-apiserver/master-0-audit.log": strings.Join(events[125:], "\n"),
+		"audit_logs/kube-apiserver/master-1-audit.log": strings.Join(events[:125], "\n"),
+	})
+	cfg := serveDump(t, baseDir)
+
+	resp, err := http.Get(cfg.Host + "/static-kas/v1/history/core/configmaps/app/busy")
+	if err != nil {
+		t.Fatalf("failed to get history: %v", err)
+	}
+	defer resp.Body.Close()
+	var history struct {
+		Changes []struct {
+			Object *metav1.PartialObjectMetadata `json:"object"`
+		} `json:"changes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatalf("failed to decode history: %v", err)
+	}
+	if len(history.Changes) != 250 {
+		t.Fatalf("expected 250 changes, got %d", len(history.Changes))
+	}
+	for i, change := range history.Changes {
+		if hasObject := change.Object != nil; hasObject != (i >= 150) {
+			t.Errorf("expected change %d to have an object: %t, got %t", i+1, i >= 150, hasObject)
+		}
+	}
+	if last := history.Changes[249].Object; last == nil || last.ResourceVersion != "250" {
+		t.Errorf("expected the last change to have resourceVersion 250, got %v", last)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	ctx := context.Background()
+	configMap := func(name, value string) string {
+		return "- apiVersion: v1\n  kind: ConfigMap\n  metadata:\n    name: " + name + "\n    namespace: app\n  data:\n    value: " + value + "\n"
+	}
+	dumps := []map[string]string{
+		{
+			"timestamp":                           "2023-01-01 10:00:00.000000000 +0000 UTC m=+0.1\n2023-01-01 10:05:00.000000000 +0000 UTC m=+300.1\n",
+			"namespaces/app/core/configmaps.yaml": "apiVersion: v1\nkind: ConfigMapList\nitems:\n" + configMap("a", "old") + configMap("b", "unchanged"),
+		},
+		{
+			"timestamp":                           "2023-01-01 12:00:00.000000000 +0000 UTC m=+0.1\n2023-01-01 12:05:00.000000000 +0000 UTC m=+300.1\n",
+			"namespaces/app/core/configmaps.yaml": "apiVersion: v1\nkind: ConfigMapList\nitems:\n" + configMap("a", "new") + configMap("c", "added"),
+		},
+	}
+	var baseDirs []string
+	for _, files := range dumps {
+		files["namespaces/kube-system/kube-system.yaml"] = "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: kube-system\n  uid: cluster-uid\n"
+		baseDir := writeDump(t, files)
+		// Newest first, to verify they get sorted
+		baseDirs = append([]string{baseDir}, baseDirs...)
+	}
+
+	timelines, err := timeline.Group(baseDirs)
+	if err != nil {
+		t.Fatalf("failed to group dumps: %v", err)
+	}
+	snapshots := timelines["cluster-uid"]
+	if len(snapshots) != 2 || !snapshots[0].Time.Before(snapshots[1].Time) {
+		t.Fatalf("expected two snapshots of cluster-uid, oldest first, got %v", timelines)
+	}
+	var handlers []http.Handler
+	for _, snapshot := range snapshots {
+		snapshotHandler, err := handler.New(zaptest.NewLogger(t), snapshot.BaseDir)
+		if err != nil {
+			t.Fatalf("failed to construct server: %v", err)
+		}
+		handlers = append(handlers, snapshotHandler)
+	}
+	server := httptest.NewServer(timeline.NewHandler(zaptest.NewLogger(t), snapshots, handlers))
+	defer server.Close()
+
+	timelineClient, err := corev1client.NewForConfig(&rest.Config{Host: server.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}})
+	if err != nil {
+		t.Fatalf("failed to construct client: %v", err)
+	}
+	configMapNames := func(list *corev1.ConfigMapList) []string {
+		var result []string
+		for _, item := range list.Items {
+			result = append(result, item.Name+"="+item.Data["value"])
+		}
+		sort.Strings(result)
+		return result
+	}
+	newest, err := timelineClient.ConfigMaps("app").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list configmaps: %v", err)
+	}
+	if expected := []string{"a=new", "c=added"}; !reflect.DeepEqual(configMapNames(newest), expected) {
+		t.Errorf("expected newest snapshot %v, got %v", expected, configMapNames(newest))
+	}
+	asOf := &corev1.ConfigMapList{}
+	if err := timelineClient.RESTClient().Get().Namespace("app").Resource("configmaps").Param("asOf", "2023-01-01T11:00:00Z").Do(ctx).Into(asOf); err != nil {
+		t.Fatalf("failed to list configmaps as of 11:00: %v", err)
+	}
+	if expected := []string{"a=old", "b=unchanged"}; !reflect.DeepEqual(configMapNames(asOf), expected) {
+		t.Errorf("expected first snapshot %v, got %v", expected, configMapNames(asOf))
+	}
+
+	watchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	watcher, err := timelineClient.ConfigMaps("app").Watch(watchCtx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to watch configmaps: %v", err)
+	}
+	defer watcher.Stop()
+	expected := []string{"ADDED a=old 1", "ADDED b=unchanged 2", "MODIFIED a=new 3", "ADDED c=added 4", "DELETED b=unchanged 5"}
+	var actual []string
+	for len(actual) < len(expected) {
+		select {
+		case event := <-watcher.ResultChan():
+			object := event.Object.(*corev1.ConfigMap)
+			actual = append(actual, fmt.Sprintf("%s %s=%s %s", event.Type, object.Name, object.Data["value"], object.ResourceVersion))
+		case <-watchCtx.Done():
+			t.Fatalf("timed out waiting for events, got %v", actual)
+		}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected events %v, got %v", expected, actual)
+	}
+}
+
+// writeDump writes files, keyed by their path relative to the base dir, to a temporary dump and returns its base dir.
+func writeDump(t *testing.T, files map[string]string) string {
+	t.Helper()
+	baseDir := t.TempDir()
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(baseDir, name)), 0755); err != nil {
+			t.Fatalf("failed to create dir for %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(baseDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	return baseDir
+}
+
+// serveDump serves a dump until the test ends and returns a config for it.
+func serveDump(t *testing.T, baseDir string, opts ...handler.Option) *rest.Config {
+	t.Helper()
+	router, err := handler.New(zaptest.NewLogger(t), baseDir, opts...)
+	if err != nil {
+		t.Fatalf("failed to construct server: %v", err)
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return &rest.Config{Host: server.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}}
+}
+
+func unstructuredListFor(apiVersion, kind string) *unstructured.UnstructuredList {
+	u := &unstructured.UnstructuredList{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+
+	return u
+}
+
+func requestTableOnPath(ctx context.Context, path string, version string) (*metav1.Table, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:8080"+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct request: %w", err)
+	}
+	req.Header.Set("Accept", fmt.Sprintf("application/json;as=Table;v=%s;g=meta.k8s.io", version))
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to do http request: %w", err)
@@ -593,6 +2521,179 @@ func requestTableOnPath(ctx context.Context, path string, version string) (*meta
 	return table, nil
 }
 
+func TestYAMLFieldOrder(t *testing.T) {
+	configMap := func(name string) string {
+		return "- kind: ConfigMap\n  apiVersion: v1\n  metadata:\n    namespace: app\n    name: " + name + "\n  data:\n    zeta: z\n    alpha: a\n"
+	}
+	baseDir := writeDump(t, map[string]string{
+		"namespaces/app/core/configmaps.yaml":  "apiVersion: v1\nkind: ConfigMapList\nitems:\n" + configMap("first") + configMap("second"),
+		"namespaces/app/core/secrets/one.yaml": "kind: Secret\napiVersion: v1\nmetadata:\n  namespace: app\n  name: one\ndata:\n  zeta: ego=\n  alpha: ego=\ntype: Opaque\n",
+	})
+	redactor, err := redact.New(redact.DefaultRules())
+	if err != nil {
+		t.Fatalf("failed to construct redactor: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		path string
+		opts []handler.Option
+		// expected are strings that must appear in this order
+		expected []string
+	}{
+		{
+			name:     "List items",
+			path:     "/api/v1/namespaces/app/configmaps",
+			expected: []string{"kind: ConfigMap", "apiVersion: v1", "namespace: app", "name: first", "zeta: z", "alpha: a", "name: second", "zeta: z", "alpha: a"},
+		},
+		{
+			name:     "Object from a list file",
+			path:     "/api/v1/namespaces/app/configmaps/second",
+			expected: []string{"kind: ConfigMap", "apiVersion: v1", "namespace: app", "name: second", "zeta: z", "alpha: a"},
+		},
+		{
+			name:     "Redacted object",
+			path:     "/api/v1/namespaces/app/secrets/one",
+			opts:     []handler.Option{handler.WithRedactor(redactor)},
+			expected: []string{"kind: Secret", "apiVersion: v1", "namespace: app", "name: one", "zeta:", "alpha:", "type: Opaque"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := serveDump(t, baseDir, tc.opts...)
+			req, err := http.NewRequest(http.MethodGet, cfg.Host+tc.path, nil)
+			if err != nil {
+				t.Fatalf("failed to construct request: %v", err)
+			}
+			req.Header.Set("Accept", "application/yaml")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("failed to do http request: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status code 200, got %d: %s", resp.StatusCode, body)
+			}
+			remaining := string(body)
+			for _, expected := range tc.expected {
+				idx := strings.Index(remaining, expected)
+				if idx < 0 {
+					t.Fatalf("expected %q to follow in the order of the file, got:\n%s", expected, body)
+				}
+				remaining = remaining[idx+len(expected):]
+			}
+		})
+	}
+}
+
+// requestYAML requests path as YAML and returns the status code and body of the response.
+func requestYAML(ctx context.Context, path string) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:8080"+path, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to construct request: %w", err)
+	}
+	req.Header.Set("Accept", "application/yaml")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to do http request: %w", err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/yaml" {
+		return 0, nil, fmt.Errorf("expected content type application/yaml, got %q", contentType)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+func verifyYAML(ctx context.Context, path string, expectStatusCode int, expectAPIVersion, expectKind string) func(t *testing.T) {
+	return func(t *testing.T) {
+		statusCode, body, err := requestYAML(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statusCode != expectStatusCode {
+			t.Errorf("expected status code %d, got %d", expectStatusCode, statusCode)
+		}
+		if bytes.HasPrefix(body, []byte("{")) {
+			t.Errorf("expected a yaml body, got %s", string(body))
+		}
+		var typeMeta metav1.TypeMeta
+		if err := yaml.Unmarshal(body, &typeMeta); err != nil {
+			t.Fatalf("failed to unmarshal response as yaml: %v", err)
+		}
+		if typeMeta.APIVersion != expectAPIVersion || typeMeta.Kind != expectKind {
+			t.Errorf("expected response to be a %s %s, got %s %s", expectAPIVersion, expectKind, typeMeta.APIVersion, typeMeta.Kind)
+		}
+	}
+}
+
+// verifyNegotiation requests path with the given Accept headers and verifies the status code and the apiVersion
+// and kind of the response.
+func verifyNegotiation(ctx context.Context, path string, accept []string, expectStatusCode int, expectAPIVersion, expectKind string) func(t *testing.T) {
+	return func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:8080"+path, nil)
+		if err != nil {
+			t.Fatalf("failed to construct request: %v", err)
+		}
+		for _, value := range accept {
+			req.Header.Add("Accept", value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to do http request: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != expectStatusCode {
+			t.Errorf("expected status code %d, got %d", expectStatusCode, resp.StatusCode)
+		}
+		var typeMeta metav1.TypeMeta
+		if err := json.NewDecoder(resp.Body).Decode(&typeMeta); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if typeMeta.APIVersion != expectAPIVersion || typeMeta.Kind != expectKind {
+			t.Errorf("expected response to be a %s %s, got %s %s", expectAPIVersion, expectKind, typeMeta.APIVersion, typeMeta.Kind)
+		}
+	}
+}
+
+// verifyTableOptions verifies the number of columns and cells and the kind of the object embedded in the rows
+// of a table, which is empty if no object is embedded.
+func verifyTableOptions(ctx context.Context, path string, expectNumColumns int, expectNumCells int, expectObjectKind string) func(t *testing.T) {
+	return func(t *testing.T) {
+		table, err := requestTableOnPath(ctx, path, "v1")
+		if err != nil {
+			t.Fatalf("failed to get table for %s: %v", path, err)
+		}
+		if n := len(table.ColumnDefinitions); n != expectNumColumns {
+			t.Errorf("expected %d columns, got %d", expectNumColumns, n)
+		}
+		if len(table.Rows) == 0 {
+			t.Fatal("expected to get rows back, got none")
+		}
+		for _, row := range table.Rows {
+			if n := len(row.Cells); n != expectNumCells {
+				t.Errorf("expected %d cells, got %d", expectNumCells, n)
+			}
+			var typeMeta metav1.TypeMeta
+			if len(row.Object.Raw) > 0 {
+				if err := json.Unmarshal(row.Object.Raw, &typeMeta); err != nil {
+					t.Fatalf("failed to unmarshal embedded object: %v", err)
+				}
+			}
+			if typeMeta.Kind != expectObjectKind {
+				t.Errorf("expected embedded object to be of kind %q, got %q", expectObjectKind, typeMeta.Kind)
+			}
+		}
+	}
+}
+
 func verifyTablePrinting(ctx context.Context, path string, expectNumColumns int, expectNumRows int) func(t *testing.T) {
 	return func(t *testing.T) {
 		for _, version := range []string{"v1", "v1beta1"} {
@@ -636,6 +2737,17 @@ func verifyList(ctx context.Context, c client.Reader, list client.ObjectList, nu
 	}
 }
 
+func partialObjectMetadataFor(apiVersion, kind, namespace, name string) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: apiVersion, Kind: kind},
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+	}
+}
+
+func partialObjectMetadataListFor(apiVersion, kind string) *metav1.PartialObjectMetadataList {
+	return &metav1.PartialObjectMetadataList{TypeMeta: metav1.TypeMeta{APIVersion: apiVersion, Kind: kind + "List"}}
+}
+
 func verifyGet(ctx context.Context, c client.Client, obj client.Object) func(t *testing.T) {
 	return func(t *testing.T) {
 		if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
@@ -665,8 +2777,16 @@ func verifyGetLogs(ctx context.Context, c corev1client.CoreV1Interface, namespac
 }
 
 func verifyGetLogsNoContainer(ctx context.Context, apiURL, namespace, podName string, expectedResponseBody string, expectedStatusCode int) func(*testing.T) {
+	return verifyGetLogsRaw(ctx, fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/log", apiURL, namespace, podName), expectedResponseBody, expectedStatusCode)
+}
+
+func verifyGetLogsRaw(ctx context.Context, url string, expectedResponseBody string, expectedStatusCode int) func(*testing.T) {
 	return func(t *testing.T) {
-		resp, err := http.Get(fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/log", apiURL, namespace, podName))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatalf("failed to construct request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			t.Fatalf("failed to get logs: %v", err)
 		}
@@ -686,3 +2806,328 @@ func verifyGetLogsNoContainer(ctx context.Context, apiURL, namespace, podName st
 		}
 	}
 }
+
+type logSearchMatch struct {
+	Namespace  string    `json:"namespace"`
+	Pod        string    `json:"pod"`
+	Container  string    `json:"container"`
+	Previous   bool      `json:"previous"`
+	LineNumber int       `json:"lineNumber"`
+	Timestamp  time.Time `json:"timestamp"`
+	Line       string    `json:"line"`
+}
+
+func verifyLogSearch(ctx context.Context, apiURL, query string, expectedNumMatches int, expectedFirstMatch *logSearchMatch) func(*testing.T) {
+	return func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL+"/static-kas/v1/logs/search?"+query, nil)
+		if err != nil {
+			t.Fatalf("failed to construct request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to search logs: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			t.Fatalf("expected status code 200, got %d: %s", resp.StatusCode, body)
+		}
+
+		var matches []logSearchMatch
+		decoder := json.NewDecoder(resp.Body)
+		for decoder.More() {
+			var match logSearchMatch
+			if err := decoder.Decode(&match); err != nil {
+				t.Fatalf("failed to decode match: %v", err)
+			}
+			matches = append(matches, match)
+		}
+		if len(matches) != expectedNumMatches {
+			t.Fatalf("expected %d matches, got %d: %+v", expectedNumMatches, len(matches), matches)
+		}
+		if expectedFirstMatch != nil && !reflect.DeepEqual(matches[0], *expectedFirstMatch) {
+			t.Errorf("expected first match to be %+v, was %+v", *expectedFirstMatch, matches[0])
+		}
+	}
+}
+
+func verifyExec(ctx context.Context, cfg *rest.Config, c corev1client.CoreV1Interface, namespace, podName string, command []string, expectedStdout, expectedErrSubstring string) func(*testing.T) {
+	return func(t *testing.T) {
+		req := c.RESTClient().Post().
+			Resource("pods").
+			Namespace(namespace).
+			Name(podName).
+			SubResource("exec").
+			VersionedParams(&corev1.PodExecOptions{Command: command, Stdout: true, Stderr: true}, scheme.ParameterCodec)
+		executor, err := remotecommand.NewSPDYExecutor(cfg, http.MethodPost, req.URL())
+		if err != nil {
+			t.Fatalf("failed to construct executor: %v", err)
+		}
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: stdout, Stderr: stderr})
+		if expectedErrSubstring == "" && err != nil {
+			t.Fatalf("exec failed: %v, stderr: %s", err, stderr.String())
+		}
+		if expectedErrSubstring != "" && (err == nil || !strings.Contains(err.Error(), expectedErrSubstring)) {
+			t.Errorf("expected error containing %q, got %v", expectedErrSubstring, err)
+		}
+		if actual := stdout.String(); actual != expectedStdout {
+			t.Errorf("expected stdout to be %q, was %q", expectedStdout, actual)
+		}
+	}
+}
+
+func verifyGetStatus(ctx context.Context, c client.Client, obj client.Object) func(*testing.T) {
+	return func(t *testing.T) {
+		if err := c.SubResource("status").Get(ctx, obj, obj); err != nil {
+			t.Fatalf("failed to get status of %T %s: %v", obj, client.ObjectKeyFromObject(obj), err)
+		}
+		if obj.GetUID() == "" {
+			t.Errorf("expected status subresource to return the full object, uid was empty")
+		}
+	}
+}
+
+func verifyGetScale(ctx context.Context, c client.Client, obj client.Object, expected *autoscalingv1.Scale) func(*testing.T) {
+	return func(t *testing.T) {
+		scale := &autoscalingv1.Scale{}
+		if err := c.SubResource("scale").Get(ctx, obj, scale); err != nil {
+			t.Fatalf("failed to get scale of %T %s: %v", obj, client.ObjectKeyFromObject(obj), err)
+		}
+		if scale.Name != obj.GetName() || scale.Namespace != obj.GetNamespace() {
+			t.Errorf("expected scale to be for %s, was for %s/%s", client.ObjectKeyFromObject(obj), scale.Namespace, scale.Name)
+		}
+		if scale.Spec != expected.Spec || scale.Status != expected.Status {
+			t.Errorf("expected scale spec %+v and status %+v, got %+v and %+v", expected.Spec, expected.Status, scale.Spec, scale.Status)
+		}
+	}
+}
+
+func verifyDiscoveryResources(cfg *rest.Config, groupVersion string, expected, unexpected []string) func(*testing.T) {
+	return func(t *testing.T) {
+		discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+		if err != nil {
+			t.Fatalf("failed to construct discovery client: %v", err)
+		}
+		resources, err := discoveryClient.ServerResourcesForGroupVersion(groupVersion)
+		if err != nil {
+			t.Fatalf("failed to discover resources for %s: %v", groupVersion, err)
+		}
+		names := map[string]bool{}
+		for _, resource := range resources.APIResources {
+			names[resource.Name] = true
+		}
+		for _, name := range expected {
+			if !names[name] {
+				t.Errorf("expected discovery for %s to contain %s", groupVersion, name)
+			}
+		}
+		for _, name := range unexpected {
+			if names[name] {
+				t.Errorf("expected discovery for %s not to contain %s", groupVersion, name)
+			}
+		}
+	}
+}
+
+func verifyGroupVersions(cfg *rest.Config, group, preferredVersion string, versions []string) func(*testing.T) {
+	return func(t *testing.T) {
+		discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+		if err != nil {
+			t.Fatalf("failed to construct discovery client: %v", err)
+		}
+		groups, err := discoveryClient.ServerGroups()
+		if err != nil {
+			t.Fatalf("failed to discover groups: %v", err)
+		}
+		for _, apiGroup := range groups.Groups {
+			if apiGroup.Name != group {
+				continue
+			}
+			if apiGroup.PreferredVersion.GroupVersion != preferredVersion {
+				t.Errorf("expected preferred version %s, got %s", preferredVersion, apiGroup.PreferredVersion.GroupVersion)
+			}
+			var actual []string
+			for _, version := range apiGroup.Versions {
+				actual = append(actual, version.GroupVersion)
+			}
+			if !reflect.DeepEqual(actual, versions) {
+				t.Errorf("expected versions %v, got %v", versions, actual)
+			}
+			return
+		}
+		t.Errorf("expected group list to contain %s", group)
+	}
+}
+
+func verifyDiscoveryScope(cfg *rest.Config, groupVersion, resource string, namespaced bool) func(*testing.T) {
+	return func(t *testing.T) {
+		discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+		if err != nil {
+			t.Fatalf("failed to construct discovery client: %v", err)
+		}
+		resources, err := discoveryClient.ServerResourcesForGroupVersion(groupVersion)
+		if err != nil {
+			t.Fatalf("failed to discover resources for %s: %v", groupVersion, err)
+		}
+		for _, apiResource := range resources.APIResources {
+			if apiResource.Name == resource {
+				if apiResource.Namespaced != namespaced {
+					t.Errorf("expected %s to have namespaced=%t, got %t", resource, namespaced, apiResource.Namespaced)
+				}
+				return
+			}
+		}
+		t.Errorf("expected discovery for %s to contain %s", groupVersion, resource)
+	}
+}
+
+func verifyDiscoveryNames(cfg *rest.Config, groupVersion, resource, singular string, shortNames, categories, verbs []string) func(*testing.T) {
+	return func(t *testing.T) {
+		discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+		if err != nil {
+			t.Fatalf("failed to construct discovery client: %v", err)
+		}
+		resources, err := discoveryClient.ServerResourcesForGroupVersion(groupVersion)
+		if err != nil {
+			t.Fatalf("failed to discover resources for %s: %v", groupVersion, err)
+		}
+		for _, apiResource := range resources.APIResources {
+			if apiResource.Name != resource {
+				continue
+			}
+			if apiResource.SingularName != singular {
+				t.Errorf("expected singular name %q, got %q", singular, apiResource.SingularName)
+			}
+			if !reflect.DeepEqual(apiResource.ShortNames, shortNames) {
+				t.Errorf("expected short names %v, got %v", shortNames, apiResource.ShortNames)
+			}
+			if !reflect.DeepEqual(apiResource.Categories, categories) {
+				t.Errorf("expected categories %v, got %v", categories, apiResource.Categories)
+			}
+			if !reflect.DeepEqual([]string(apiResource.Verbs), verbs) {
+				t.Errorf("expected verbs %v, got %v", verbs, apiResource.Verbs)
+			}
+			return
+		}
+		t.Errorf("expected discovery for %s to contain %s", groupVersion, resource)
+	}
+}
+
+func impersonatingConfig(cfg *rest.Config, username string) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+	cfg.Impersonate = rest.ImpersonationConfig{UserName: username}
+	return cfg
+}
+
+func verifyAccessReview(ctx context.Context, c client.Client, review client.Object, expectedAllowed bool) func(*testing.T) {
+	return func(t *testing.T) {
+		if err := c.Create(ctx, review); err != nil {
+			t.Fatalf("failed to create %T: %v", review, err)
+		}
+		var status authorizationv1.SubjectAccessReviewStatus
+		switch review := review.(type) {
+		case *authorizationv1.SelfSubjectAccessReview:
+			status = review.Status
+		case *authorizationv1.SubjectAccessReview:
+			status = review.Status
+		case *authorizationv1.LocalSubjectAccessReview:
+			status = review.Status
+		default:
+			t.Fatalf("unexpected review type %T", review)
+		}
+		if status.Allowed != expectedAllowed {
+			t.Errorf("expected allowed to be %t, was %t (reason: %q, evaluationError: %q)", expectedAllowed, status.Allowed, status.Reason, status.EvaluationError)
+		}
+	}
+}
+
+func verifyRulesReview(ctx context.Context, c client.Client, namespace string, expectedRule authorizationv1.ResourceRule) func(*testing.T) {
+	return func(t *testing.T) {
+		ssrr := &authorizationv1.SelfSubjectRulesReview{Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: namespace}}
+		if err := c.Create(ctx, ssrr); err != nil {
+			t.Fatalf("failed to create self-subject rules review: %v", err)
+		}
+		for _, rule := range ssrr.Status.ResourceRules {
+			if reflect.DeepEqual(rule, expectedRule) {
+				return
+			}
+		}
+		t.Errorf("expected rules %+v to contain %+v", ssrr.Status.ResourceRules, expectedRule)
+	}
+}
+
+func verifyWhoCan(ctx context.Context, apiURL, query string, expectedSubjects []string) func(*testing.T) {
+	return func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL+"/static-kas/v1/rbac/who-can?"+query, nil)
+		if err != nil {
+			t.Fatalf("failed to construct request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to query who-can: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			t.Fatalf("expected status code 200, got %d: %s", resp.StatusCode, body)
+		}
+
+		var response struct {
+			Grants []struct {
+				Subject rbacv1.Subject `json:"subject"`
+			} `json:"grants"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		var subjects []string
+		for _, grant := range response.Grants {
+			subjects = append(subjects, grant.Subject.Kind+"/"+grant.Subject.Namespace+"/"+grant.Subject.Name)
+		}
+		if !reflect.DeepEqual(subjects, expectedSubjects) {
+			t.Errorf("expected subjects %v, got %v", expectedSubjects, subjects)
+		}
+	}
+}
+
+// selfSubjectReview creates a SelfSubjectReview in the given version of authentication.k8s.io.
+func selfSubjectReview(ctx context.Context, cfg *rest.Config, version string) (*authenticationv1beta1.SelfSubjectReview, error) {
+	cfg = rest.CopyConfig(cfg)
+	cfg.APIPath = "/apis"
+	cfg.GroupVersion = &schema.GroupVersion{Group: "authentication.k8s.io", Version: version}
+	cfg.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+	c, err := rest.RESTClientFor(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct client: %w", err)
+	}
+	raw, err := c.Post().Resource("selfsubjectreviews").Body([]byte(`{}`)).Do(ctx).Raw()
+	if err != nil {
+		return nil, err
+	}
+	review := &authenticationv1beta1.SelfSubjectReview{}
+	if err := json.Unmarshal(raw, review); err != nil {
+		return nil, fmt.Errorf("failed to decode self-subject review: %w", err)
+	}
+	return review, nil
+}
+
+func verifySelfSubjectReview(ctx context.Context, cfg *rest.Config, expectedUsername string) func(*testing.T) {
+	return func(t *testing.T) {
+		review, err := selfSubjectReview(ctx, cfg, "v1")
+		if err != nil {
+			t.Fatalf("failed to create self-subject review: %v", err)
+		}
+		if review.Status.UserInfo.Username != expectedUsername {
+			t.Errorf("expected username %q, got %q", expectedUsername, review.Status.UserInfo.Username)
+		}
+	}
+}
+
+func verifySelfSubjectReviewError(ctx context.Context, cfg *rest.Config, check func(error) bool) func(*testing.T) {
+	return func(t *testing.T) {
+		if _, err := selfSubjectReview(ctx, cfg, "v1"); !check(err) {
+			t.Errorf("got unexpected error %v", err)
+		}
+	}
+}
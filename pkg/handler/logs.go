@@ -0,0 +1,614 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/api/legacyscheme"
+	_ "k8s.io/kubernetes/pkg/apis/core/install"
+
+	"github.com/alvaroaleman/static-kas/pkg/response"
+	"github.com/alvaroaleman/static-kas/pkg/timeline"
+)
+
+var parameterCodec = runtime.NewParameterCodec(legacyscheme.Scheme)
+
+// kubeletTimestampFormat is the format the kubelet uses for the timestamps it prefixes log lines with if timestamps
+// is set. Unlike time.RFC3339Nano it doesn't strip trailing zeros, so all timestamps have the same width.
+const kubeletTimestampFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// podLogHandler serves the logs of a single container, like the kube-apiserver. Clients that show the logs of all
+// containers, like kubectl logs --all-containers, send one request per container.
+func podLogHandler(l *zap.Logger, dirs dumpDirs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		l := l.With(zap.String("path", r.URL.Path))
+
+		opts := &corev1.PodLogOptions{}
+		if err := parameterCodec.DecodeParameters(r.URL.Query(), corev1.SchemeGroupVersion, opts); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("failed to decode log options: %v", err)))
+			return
+		}
+		if err := validatePodLogOptions(opts); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		pod, code, err := readPod(dirs, vars["namespace"], vars["name"])
+		if err != nil {
+			w.WriteHeader(code)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		containerName, err := podContainerName(pod, opts.Container)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		paths, err := podLogFiles(dirs, pod.Namespace, pod.Name, containerName, opts.Previous)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to find log files: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if len(paths) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(noLogsMessage(dirs, pod, containerName, opts.Previous)))
+			return
+		}
+		segments, closeSegments, err := openLogSegments(paths)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to open log files: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if err := writeLogs(w, segments, opts, dumpTime(l, dirs.baseDir)); err != nil {
+			l.Error("failed to write logs", zap.Error(err))
+		}
+		// Close the files before blocking on follow, there might be many concurrent followers
+		// (kubectl logs -f --all-containers -l ...) and we don't want to hold on to open files.
+		closeSegments()
+
+		// Block so the client doesn't get an EOF error
+		if opts.Follow {
+			// We have to force a flush first, because golang buffers responses until the handler returns or the buffer is filled.
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			<-r.Context().Done()
+		}
+	}
+}
+
+func validatePodLogOptions(opts *corev1.PodLogOptions) error {
+	if opts.SinceSeconds != nil && opts.SinceTime != nil {
+		return errors.New("at most one of `sinceTime` or `sinceSeconds` may be specified")
+	}
+	if opts.SinceSeconds != nil && *opts.SinceSeconds < 1 {
+		return errors.New("sinceSeconds must be greater than 0")
+	}
+	if opts.TailLines != nil && *opts.TailLines < 0 {
+		return errors.New("tailLines must be greater than or equal to 0")
+	}
+	if opts.LimitBytes != nil && *opts.LimitBytes < 1 {
+		return errors.New("limitBytes must be greater than 0")
+	}
+
+	return nil
+}
+
+func readPod(dirs dumpDirs, namespace, name string) (*corev1.Pod, int, error) {
+	u, found, err := response.ReadObject(path.Join(dirs.namespaceDir(namespace), "core"), "pods", name)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to read pod %s in the %s namespace: %w", name, namespace, err)
+	}
+	if !found {
+		return nil, http.StatusNotFound, fmt.Errorf("pods %q not found", name)
+	}
+	pod := &corev1.Pod{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, pod); err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to convert pod %s in the %s namespace: %w", name, namespace, err)
+	}
+	// The path is authoritative, the object might not have a namespace set
+	pod.Namespace = namespace
+
+	return pod, http.StatusOK, nil
+}
+
+// podContainerName validates the requested container of a pod or, if none was requested, defaults it the
+// same way kubectl and the kube-apiserver do: The kubectl.kubernetes.io/default-container annotation wins,
+// otherwise the pod must have exactly one container.
+func podContainerName(pod *corev1.Pod, requested string) (string, error) {
+	if requested != "" {
+		if !podHasContainer(pod, requested) {
+			return "", fmt.Errorf("container %s is not valid for pod %s", requested, pod.Name)
+		}
+		return requested, nil
+	}
+
+	if defaultContainer := pod.Annotations[defaultContainerAnnotation]; defaultContainer != "" && podHasContainer(pod, defaultContainer) {
+		return defaultContainer, nil
+	}
+
+	switch len(pod.Spec.Containers) {
+	case 0:
+		return "", fmt.Errorf("a container name must be specified for pod %s", pod.Name)
+	case 1:
+		return pod.Spec.Containers[0].Name, nil
+	}
+
+	return "", fmt.Errorf("a container name must be specified for pod %s, choose one of: %s", pod.Name, containerChoices(podContainerNames(pod, func(string) bool { return true })))
+}
+
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+func podHasContainer(pod *corev1.Pod, name string) bool {
+	for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		if container.Name == name {
+			return true
+		}
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		if container.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// podContainerNames returns the names of the containers, init containers and ephemeral containers of
+// the pod for which include returns true.
+func podContainerNames(pod *corev1.Pod, include func(string) bool) (containers, initContainers, ephemeralContainers []string) {
+	for _, container := range pod.Spec.Containers {
+		if include(container.Name) {
+			containers = append(containers, container.Name)
+		}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if include(container.Name) {
+			initContainers = append(initContainers, container.Name)
+		}
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		if include(container.Name) {
+			ephemeralContainers = append(ephemeralContainers, container.Name)
+		}
+	}
+
+	return containers, initContainers, ephemeralContainers
+}
+
+// containerChoices formats container names the way the kube-apiserver does when asking the user to pick one.
+func containerChoices(containers, initContainers, ephemeralContainers []string) string {
+	result := fmt.Sprintf("[%s]", strings.Join(containers, " "))
+	if len(initContainers) > 0 {
+		result += fmt.Sprintf(" or one of the init containers: [%s]", strings.Join(initContainers, " "))
+	}
+	if len(ephemeralContainers) > 0 {
+		result += fmt.Sprintf(" or one of the ephemeral containers: [%s]", strings.Join(ephemeralContainers, " "))
+	}
+
+	return result
+}
+
+// noLogsMessage explains why there are no logs for a container. It uses the same messages as the kubelet
+// if the container status explains it and lists the containers of the pod that do have logs.
+func noLogsMessage(dirs dumpDirs, pod *corev1.Pod, container string, previous bool) string {
+	msg := fmt.Sprintf("container %q in pod %q has no logs in the dump", container, pod.Name)
+	if status, found := podContainerStatus(pod, container); !found {
+		msg = fmt.Sprintf("container %q in pod %q is not available", container, pod.Name)
+	} else if previous && status.LastTerminationState.Terminated == nil {
+		msg = fmt.Sprintf("previous terminated container %q in pod %q not found", container, pod.Name)
+	} else if waiting := status.State.Waiting; waiting != nil && status.LastTerminationState.Terminated == nil {
+		switch waiting.Reason {
+		case "ErrImagePull":
+			msg = fmt.Sprintf("container %q in pod %q is waiting to start: image can't be pulled", container, pod.Name)
+		case "ImagePullBackOff":
+			msg = fmt.Sprintf("container %q in pod %q is waiting to start: trying and failing to pull image", container, pod.Name)
+		default:
+			msg = fmt.Sprintf("container %q in pod %q is waiting to start: %v", container, pod.Name, waiting.Reason)
+		}
+	}
+
+	containers, initContainers, ephemeralContainers := podContainerNames(pod, func(name string) bool {
+		paths, err := podLogFiles(dirs, pod.Namespace, pod.Name, name, previous)
+		return err == nil && len(paths) > 0
+	})
+	if len(containers)+len(initContainers)+len(ephemeralContainers) == 0 {
+		return msg + ", no container of this pod has logs in the dump"
+	}
+
+	return msg + ", choose one of: " + containerChoices(containers, initContainers, ephemeralContainers)
+}
+
+func podContainerStatus(pod *corev1.Pod, container string) (corev1.ContainerStatus, bool) {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses, pod.Status.EphemeralContainerStatuses} {
+		for _, status := range statuses {
+			if status.Name == container {
+				return status, true
+			}
+		}
+	}
+
+	return corev1.ContainerStatus{}, false
+}
+
+// podLogFiles returns the log files of a container, oldest first. must-gather puts them at
+// pods/<pod>/<container>/<container>/logs/{current,previous}.log while hypershift dumps use
+// core/pods/logs/<pod>-<container>{,-previous}.log. Both may be accompanied by rotated files that
+// have a numeric suffix and may be gzipped, a higher number meaning an older file. A gzipped file
+// is older than an uncompressed one with the same number, because files get compressed on rotation.
+func podLogFiles(dirs dumpDirs, namespace, pod, container string, previous bool) ([]string, error) {
+	fileName, hypershiftFileName := "current.log", pod+"-"+container+".log"
+	if previous {
+		fileName, hypershiftFileName = "previous.log", pod+"-"+container+"-previous.log"
+	}
+	candidates := []struct {
+		dir  string
+		name string
+	}{
+		{dir: path.Join(dirs.namespaceDir(namespace), "pods", pod, container, container, "logs"), name: fileName},
+		{dir: path.Join(dirs.namespaceDir(namespace), "core", "pods", "logs"), name: hypershiftFileName},
+	}
+	for _, candidate := range candidates {
+		paths, err := rotatedLogFiles(candidate.dir, candidate.name)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) > 0 {
+			return paths, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func rotatedLogFiles(dir, name string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+
+	type rotatedFile struct {
+		index      int
+		compressed bool
+		path       string
+	}
+	var files []rotatedFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		suffix := strings.TrimSuffix(entry.Name(), ".gz")
+		compressed := suffix != entry.Name()
+		if suffix == name {
+			files = append(files, rotatedFile{compressed: compressed, path: path.Join(dir, entry.Name())})
+			continue
+		}
+		if !strings.HasPrefix(suffix, name+".") {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(suffix, name+"."))
+		if err != nil || index < 1 {
+			continue
+		}
+		files = append(files, rotatedFile{index: index, compressed: compressed, path: path.Join(dir, entry.Name())})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].index != files[j].index {
+			return files[i].index > files[j].index
+		}
+		return files[i].compressed && !files[j].compressed
+	})
+
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		result = append(result, file.path)
+	}
+
+	return result, nil
+}
+
+// openLogSegments opens the given log files. Gzipped files are decompressed into memory, rotated files
+// are small enough for that.
+func openLogSegments(paths []string) ([]*io.SectionReader, func(), error) {
+	var files []*os.File
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	result := make([]*io.SectionReader, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		if !strings.HasSuffix(path, ".gz") {
+			files = append(files, f)
+			info, err := f.Stat()
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("failed to stat %s: %w", path, err)
+			}
+			result = append(result, io.NewSectionReader(f, 0, info.Size()))
+			continue
+		}
+
+		data, err := readGzip(f)
+		f.Close()
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+		}
+		result = append(result, io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+	}
+
+	return result, closeAll, nil
+}
+
+func readGzip(r io.Reader) ([]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	return io.ReadAll(gz)
+}
+
+// joinLogSegments returns a reader that reads the log segments one after another. Segments that don't end in a
+// newline, because they were cut off, are terminated with one, so their last line doesn't run into the first line of
+// the next segment.
+func joinLogSegments(segments []*io.SectionReader) (io.Reader, error) {
+	readers := make([]io.Reader, 0, len(segments))
+	for i, segment := range segments {
+		readers = append(readers, segment)
+		if i < len(segments)-1 && segment.Size() > 0 {
+			last := make([]byte, 1)
+			if _, err := segment.ReadAt(last, segment.Size()-1); err != nil {
+				return nil, fmt.Errorf("failed to read end of log segment: %w", err)
+			}
+			if last[0] != '\n' {
+				readers = append(readers, strings.NewReader("\n"))
+			}
+		}
+	}
+
+	return io.MultiReader(readers...), nil
+}
+
+// writeLogs writes the log segments to w, applying the options in the same order the kubelet does:
+// tailLines first, then sinceTime/sinceSeconds, then limitBytes.
+func writeLogs(w io.Writer, segments []*io.SectionReader, opts *corev1.PodLogOptions, dumpTime *time.Time) error {
+	if opts.TailLines != nil {
+		remaining := *opts.TailLines
+		first := len(segments) - 1
+		for ; first >= 0; first-- {
+			offset, found, err := tailOffset(segments[first], remaining)
+			if err != nil {
+				return fmt.Errorf("failed to find offset for tailLines: %w", err)
+			}
+			segments[first] = io.NewSectionReader(segments[first], offset, segments[first].Size()-offset)
+			if remaining -= found; remaining <= 0 {
+				break
+			}
+		}
+		if first < 0 {
+			first = 0
+		}
+		segments = segments[first:]
+	}
+
+	var since time.Time
+	switch {
+	case opts.SinceTime != nil:
+		since = opts.SinceTime.Time
+	case opts.SinceSeconds != nil:
+		if dumpTime == nil && len(segments) > 0 {
+			last, err := lastTimestamp(segments[len(segments)-1])
+			if err != nil {
+				return fmt.Errorf("failed to determine timestamp of last log line: %w", err)
+			}
+			dumpTime = &last
+		}
+		if dumpTime != nil {
+			since = dumpTime.Add(-time.Duration(*opts.SinceSeconds) * time.Second)
+		}
+	}
+
+	if opts.LimitBytes != nil {
+		w = &limitWriter{w: w, remaining: *opts.LimitBytes}
+	}
+
+	joined, err := joinLogSegments(segments)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(joined)
+	// Lines without a timestamp are continuations of the previous one, so they inherit its timestamp
+	var lastSeen time.Time
+	// Like the kubelet, only the first part of a line that was split into partial lines gets a timestamp
+	lineStart := true
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			ts, content := parseLogLine(line)
+			if !ts.IsZero() {
+				lastSeen = ts
+			}
+			if !since.IsZero() && !lastSeen.IsZero() && lastSeen.Before(since) {
+				continue
+			}
+			if opts.Timestamps && !ts.IsZero() && lineStart {
+				content = append([]byte(ts.Format(kubeletTimestampFormat)+" "), content...)
+			}
+			lineStart = line[len(line)-1] != '\n' || bytes.HasSuffix(content, []byte("\n"))
+			if _, err := w.Write(content); err != nil {
+				if errors.Is(err, errLimitReached) {
+					return nil
+				}
+				return err
+			}
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				return nil
+			}
+			return readErr
+		}
+	}
+}
+
+// parseLogLine splits a log line into its timestamp and content. must-gather logs are either
+// in the CRI format (<RFC3339Nano timestamp> <stream> <P|F> <content>), in the format
+// `kubectl logs --timestamps` produces (<RFC3339Nano timestamp> <content>) or have no timestamp at
+// all, in which case the zero time is returned along with the unmodified line.
+func parseLogLine(line []byte) (time.Time, []byte) {
+	idx := bytes.IndexByte(line, ' ')
+	if idx < 0 {
+		return time.Time{}, line
+	}
+	ts, err := time.Parse(time.RFC3339Nano, string(line[:idx]))
+	if err != nil {
+		return time.Time{}, line
+	}
+	content := line[idx+1:]
+
+	fields := bytes.SplitN(content, []byte(" "), 3)
+	if len(fields) == 3 && (string(fields[0]) == "stdout" || string(fields[0]) == "stderr") {
+		switch string(fields[1]) {
+		case "F":
+			return ts, fields[2]
+		case "P":
+			// Partial lines get joined with the next one
+			return ts, bytes.TrimSuffix(fields[2], []byte("\n"))
+		}
+	}
+
+	return ts, content
+}
+
+// tailOffset returns the offset in r at which the last numLines lines start along with the
+// number of lines found, which is smaller than numLines if r doesn't have that many lines.
+// A trailing newline terminates the last line and does not start a new one.
+func tailOffset(r *io.SectionReader, numLines int64) (int64, int64, error) {
+	size := r.Size()
+	if numLines == 0 || size == 0 {
+		return size, 0, nil
+	}
+
+	buf := make([]byte, 32*1024)
+	var found int64
+	isLastByte := true
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := r.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, 0, fmt.Errorf("failed to read at offset %d: %w", start, err)
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] != '\n' {
+				isLastByte = false
+				continue
+			}
+			if isLastByte {
+				isLastByte = false
+				continue
+			}
+			found++
+			if found == numLines {
+				return start + int64(i) + 1, found, nil
+			}
+		}
+		end = start
+	}
+
+	// The first line is not preceded by a newline
+	return 0, found + 1, nil
+}
+
+// lastTimestamp returns the timestamp of the last line in r that has one. It is used as
+// an approximation of when the dump was taken if there is no timestamp file.
+func lastTimestamp(r *io.SectionReader) (time.Time, error) {
+	const maxLines = 100
+	offset, _, err := tailOffset(r, maxLines)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var result time.Time
+	scanner := bufio.NewScanner(io.NewSectionReader(r, offset, r.Size()-offset))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if ts, _ := parseLogLine(scanner.Bytes()); !ts.IsZero() {
+			result = ts
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+// dumpTime returns the time at which the dump was taken, based on the timestamp file must-gather
+// creates. The file contains the start and end time of the must-gather in the format of time.Time.String,
+// we use the last one.
+func dumpTime(l *zap.Logger, baseDir string) *time.Time {
+	result, err := timeline.DumpTime(baseDir)
+	if err != nil {
+		l.Warn("failed to read timestamp file", zap.Error(err))
+	}
+
+	return result
+}
+
+var errLimitReached = errors.New("limit reached")
+
+type limitWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitWriter) Write(data []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, errLimitReached
+	}
+	if int64(len(data)) > l.remaining {
+		n, err := l.w.Write(data[:l.remaining])
+		l.remaining -= int64(n)
+		if err != nil {
+			return n, err
+		}
+		return n, errLimitReached
+	}
+	n, err := l.w.Write(data)
+	l.remaining -= int64(n)
+	return n, err
+}
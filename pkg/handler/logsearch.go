@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/alvaroaleman/static-kas/pkg/filter"
+	"github.com/alvaroaleman/static-kas/pkg/response"
+)
+
+// logSearchMatch is a single line of the response of the log search endpoint.
+type logSearchMatch struct {
+	Namespace  string     `json:"namespace"`
+	Pod        string     `json:"pod"`
+	Container  string     `json:"container"`
+	Previous   bool       `json:"previous,omitempty"`
+	LineNumber int        `json:"lineNumber"`
+	Timestamp  *time.Time `json:"timestamp,omitempty"`
+	Line       string     `json:"line"`
+}
+
+type logSearchOptions struct {
+	regex      *regexp.Regexp
+	namespaces []string
+	since      time.Time
+	until      time.Time
+	limit      int
+}
+
+func logSearchOptionsFromRequest(r *http.Request) (*logSearchOptions, error) {
+	query := r.URL.Query()
+	if query.Get("regex") == "" {
+		return nil, fmt.Errorf("the regex query arg is mandatory")
+	}
+	regex, err := regexp.Compile(query.Get("regex"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile regex: %w", err)
+	}
+	opts := &logSearchOptions{regex: regex, namespaces: query["namespace"]}
+
+	for name, target := range map[string]*time.Time{"sinceTime": &opts.since, "untilTime": &opts.until} {
+		if raw := query.Get(name); raw != "" {
+			if *target, err = time.Parse(time.RFC3339Nano, raw); err != nil {
+				return nil, fmt.Errorf("%s query arg must be a RFC3339 timestamp: %w", name, err)
+			}
+		}
+	}
+	if raw := query.Get("limit"); raw != "" {
+		if opts.limit, err = strconv.Atoi(raw); err != nil || opts.limit < 1 {
+			return nil, fmt.Errorf("limit query arg must be a positive integer")
+		}
+	}
+
+	return opts, nil
+}
+
+// logSearchHandler greps through the logs of all containers of all pods that match the namespace and the label- and
+// fieldSelector of the request and streams the matching lines back as newline-delimited JSON.
+func logSearchHandler(l *zap.Logger, dirs dumpDirs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := l.With(zap.String("path", r.URL.Path))
+		opts, err := logSearchOptionsFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pods, err := podsForLogSearch(dirs, opts.namespaces, filter.FromRequest(r))
+		if err != nil {
+			// Invalid selectors result in a BadRequest
+			writeStatus(l, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		matches := 0
+		for _, pod := range pods {
+			if r.Context().Err() != nil {
+				return
+			}
+			containers, initContainers, ephemeralContainers := podContainerNames(pod, func(string) bool { return true })
+			for _, container := range append(append(initContainers, containers...), ephemeralContainers...) {
+				for _, previous := range []bool{true, false} {
+					var writeErr error
+					err := searchContainerLog(dirs, pod, container, previous, opts, func(match logSearchMatch) error {
+						matches++
+						writeErr = encoder.Encode(match)
+						return writeErr
+					}, func() bool { return opts.limit > 0 && matches >= opts.limit })
+					if writeErr != nil {
+						l.Error("failed to write match", zap.Error(writeErr))
+						return
+					}
+					if err != nil {
+						// The response is already being streamed, so a single log we can't read shouldn't end it
+						l.Error("failed to search container log, skipping it", zap.String("namespace", pod.Namespace), zap.String("pod", pod.Name), zap.String("container", container), zap.Error(err))
+						continue
+					}
+					if opts.limit > 0 && matches >= opts.limit {
+						return
+					}
+				}
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	}
+}
+
+func podsForLogSearch(dirs dumpDirs, namespaces []string, filters []filter.Filter) ([]*corev1.Pod, error) {
+	if len(namespaces) == 0 {
+		var err error
+		if namespaces, err = dirs.namespaces(); err != nil {
+			return nil, fmt.Errorf("failed to list namespaces: %w", err)
+		}
+	}
+
+	var result []*corev1.Pod
+	for _, namespace := range namespaces {
+		list, err := response.ReadAndDeserializeList(path.Join(dirs.namespaceDir(namespace), "core"), "pods")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pods in namespace %s: %w", namespace, err)
+		}
+		for _, filter := range filters {
+			if list, err = filter(list); err != nil {
+				return nil, fmt.Errorf("filter failed: %w", err)
+			}
+		}
+		for _, item := range list.Items {
+			pod := &corev1.Pod{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, pod); err != nil {
+				return nil, fmt.Errorf("failed to convert pod %s in namespace %s: %w", item.GetName(), namespace, err)
+			}
+			pod.Namespace = namespace
+			result = append(result, pod)
+		}
+	}
+
+	return result, nil
+}
+
+func searchContainerLog(
+	dirs dumpDirs,
+	pod *corev1.Pod,
+	container string,
+	previous bool,
+	opts *logSearchOptions,
+	onMatch func(logSearchMatch) error,
+	done func() bool,
+) error {
+	paths, err := podLogFiles(dirs, pod.Namespace, pod.Name, container, previous)
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+	segments, closeSegments, err := openLogSegments(paths)
+	if err != nil {
+		return err
+	}
+	defer closeSegments()
+
+	joined, err := joinLogSegments(segments)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(joined)
+	scanner.Buffer(nil, 1024*1024)
+	var lineNumber int
+	// Lines without a timestamp are continuations of the previous one, so they inherit its timestamp
+	var lastSeen time.Time
+	for scanner.Scan() {
+		lineNumber++
+		ts, content := parseLogLine(scanner.Bytes())
+		if !ts.IsZero() {
+			lastSeen = ts
+		}
+		if !lastSeen.IsZero() {
+			if !opts.since.IsZero() && lastSeen.Before(opts.since) {
+				continue
+			}
+			if !opts.until.IsZero() && lastSeen.After(opts.until) {
+				return nil
+			}
+		}
+		if !opts.regex.Match(content) {
+			continue
+		}
+
+		match := logSearchMatch{
+			Namespace:  pod.Namespace,
+			Pod:        pod.Name,
+			Container:  container,
+			Previous:   previous,
+			LineNumber: lineNumber,
+			Line:       string(content),
+		}
+		if !ts.IsZero() {
+			match.Timestamp = &ts
+		}
+		if err := onMatch(match); err != nil {
+			return err
+		}
+		if done() {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
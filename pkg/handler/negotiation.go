@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/apiserver/pkg/endpoints/handlers/negotiation"
+
+	"github.com/alvaroaleman/static-kas/pkg/response"
+)
+
+// supportedMediaTypes are the media types objects can be served as. Protobuf is not supported, because
+// objects are only available as unstructured.
+var supportedMediaTypes = []runtime.SerializerInfo{
+	{
+		MediaType:        runtime.ContentTypeJSON,
+		MediaTypeType:    "application",
+		MediaTypeSubType: "json",
+		EncodesAsText:    true,
+		Serializer:       json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{}),
+		StreamSerializer: &runtime.StreamSerializerInfo{
+			EncodesAsText: true,
+			Serializer:    json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{}),
+			Framer:        json.Framer,
+		},
+	},
+	{
+		MediaType:        runtime.ContentTypeYAML,
+		MediaTypeType:    "application",
+		MediaTypeSubType: "yaml",
+		EncodesAsText:    true,
+		Serializer:       json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{Yaml: true}),
+	},
+}
+
+// negotiateMediaType negotiates the media type and the representation of an object with the client, taking
+// all Accept headers into account. It returns a NotAcceptable error if none of the media types the client
+// accepts can be served, which explains that protobuf is not supported if the client asked for it.
+func negotiateMediaType(r *http.Request) (negotiation.MediaTypeOptions, error) {
+	header := strings.Join(r.Header.Values("Accept"), ",")
+	options, ok := negotiation.NegotiateMediaTypeOptions(header, supportedMediaTypes, objectEndpointRestrictions{})
+	if !ok {
+		var supported []string
+		for _, info := range supportedMediaTypes {
+			supported = append(supported, info.MediaType)
+		}
+		if !strings.Contains(header, runtime.ContentTypeProtobuf) {
+			return options, negotiation.NewNotAcceptableError(supported)
+		}
+		return options, &apierrors.StatusError{ErrStatus: metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusNotAcceptable,
+			Reason:  metav1.StatusReasonNotAcceptable,
+			Message: fmt.Sprintf("%s is not supported, objects are only available as unstructured. Only the following media types are accepted: %s", runtime.ContentTypeProtobuf, strings.Join(supported, ", ")),
+		}}
+	}
+
+	return options, nil
+}
+
+// mediaTypeMiddleware makes responses use the media type the client asks for.
+func mediaTypeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, err := negotiateMediaType(r); err == nil {
+			r = r.WithContext(response.WithMediaType(r.Context(), mediaType.Accepted.MediaType))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// objectEndpointRestrictions allows to get objects as Table, PartialObjectMetadata and
+// PartialObjectMetadataList in addition to their own representation.
+type objectEndpointRestrictions struct{}
+
+func (objectEndpointRestrictions) AllowsMediaTypeTransform(_, _ string, target *schema.GroupVersionKind) bool {
+	if target == nil {
+		return true
+	}
+	if target.Group != metav1.GroupName || (target.Version != "v1" && target.Version != "v1beta1") {
+		return false
+	}
+	switch target.Kind {
+	case "Table", "PartialObjectMetadata", "PartialObjectMetadataList":
+		return true
+	}
+
+	return false
+}
+
+func (objectEndpointRestrictions) AllowsServerVersion(string) bool { return false }
+
+func (objectEndpointRestrictions) AllowsStreamSchema(schema string) bool { return schema == "watch" }
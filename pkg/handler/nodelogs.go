@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/alvaroaleman/static-kas/pkg/response"
+)
+
+// nodeLogHandler emulates the kubelets /logs endpoint that is reachable through the node proxy subresource.
+// must-gather stores the journal of some units per node in nodes/<node>/<node>_logs_<unit>.gz and the journal of
+// other units for all nodes of a role in host_service_logs/<role>/<unit>_service.log. Both are served through the
+// journal interface (/logs/journal?unit=<unit>&tail=<n>) that `oc adm node-logs` uses, the query interface
+// (/logs/?query=<unit>&tailLines=<n>&sinceTime=<t>&untilTime=<t>) of newer kubelets and as /logs/<unit>. /logs/
+// lists the available logs. Other files in the dump are not served, they are not logs and might contain credentials.
+func nodeLogHandler(l *zap.Logger, baseDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		l := l.With(zap.String("path", r.URL.Path))
+		nodeName := vars["name"]
+
+		if _, found, err := response.ReadObject(path.Join(baseDir, "cluster-scoped-resources", "core"), "nodes", nodeName); err != nil {
+			http.Error(w, fmt.Sprintf("failed to read node %s: %v", nodeName, err), http.StatusInternalServerError)
+			return
+		} else if !found {
+			http.Error(w, fmt.Sprintf("nodes %q not found", nodeName), http.StatusNotFound)
+			return
+		}
+
+		sources, err := nodeLogSources(baseDir, nodeName)
+		if err != nil {
+			l.Error("failed to find node logs", zap.Error(err))
+			http.Error(w, fmt.Sprintf("failed to find node logs: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		query := r.URL.Query()
+		logPath := strings.Trim(vars["path"], "/")
+		var units []string
+		var tailRaw string
+		var since, until time.Time
+		switch {
+		case logPath == "journal":
+			units, tailRaw = query["unit"], query.Get("tail")
+		case logPath == "" && query.Has("query"):
+			units, tailRaw = query["query"], query.Get("tailLines")
+			for name, target := range map[string]*time.Time{"sinceTime": &since, "untilTime": &until} {
+				if raw := query.Get(name); raw != "" {
+					if *target, err = time.Parse(time.RFC3339, raw); err != nil {
+						http.Error(w, fmt.Sprintf("%s query arg must be a RFC3339 timestamp: %v", name, err), http.StatusBadRequest)
+						return
+					}
+				}
+			}
+		case logPath == "":
+			writeNodeLogIndex(l, w, sources)
+			return
+		default:
+			unit := strings.TrimSuffix(logPath, ".service")
+			if _, found := sources[unit]; !found {
+				http.Error(w, fmt.Sprintf("node %s has no log %q in the dump", nodeName, logPath), http.StatusNotFound)
+				return
+			}
+			units = []string{unit}
+		}
+
+		tail := -1
+		if tailRaw != "" {
+			tail, err = strconv.Atoi(tailRaw)
+			if err != nil {
+				http.Error(w, "tail query arg must be an integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		lines, err := nodeJournal(sources, nodeName, units, dumpTime(l, baseDir), since, until)
+		if err != nil {
+			l.Error("failed to read node journal", zap.Error(err))
+			http.Error(w, fmt.Sprintf("failed to read journal: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if tail >= 0 && tail < len(lines) {
+			lines = lines[len(lines)-tail:]
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range lines {
+			if _, err := w.Write(append(line, '\n')); err != nil {
+				l.Error("failed to write journal", zap.Error(err))
+				return
+			}
+		}
+	}
+}
+
+// writeNodeLogIndex lists the logs of a node the same way the kubelet lists the files in /var/log.
+func writeNodeLogIndex(l *zap.Logger, w http.ResponseWriter, sources map[string]journalSource) {
+	names := append([]string{"journal"}, sets.StringKeySet(sources).List()...)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	index := &bytes.Buffer{}
+	index.WriteString("<pre>\n")
+	for _, name := range names {
+		fmt.Fprintf(index, "<a href=\"%s\">%s</a>\n", url.PathEscape(name), html.EscapeString(name))
+	}
+	index.WriteString("</pre>\n")
+	if _, err := w.Write(index.Bytes()); err != nil {
+		l.Error("failed to write node log index", zap.Error(err))
+	}
+}
+
+type journalSource struct {
+	path string
+	// filterHost is set if the file contains the journal of multiple hosts
+	filterHost bool
+}
+
+// nodeLogSources returns the files that contain the journal of the units of a node, keyed by unit.
+func nodeLogSources(baseDir, nodeName string) (map[string]journalSource, error) {
+	sources := map[string]journalSource{}
+
+	nodeDir := filepath.Join(baseDir, "nodes", nodeName)
+	nodeFiles, err := filepath.Glob(filepath.Join(nodeDir, nodeName+"_logs_*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob for node logs: %w", err)
+	}
+	for _, file := range nodeFiles {
+		unit := strings.TrimPrefix(filepath.Base(file), nodeName+"_logs_")
+		unit = strings.TrimSuffix(strings.TrimSuffix(unit, ".gz"), ".log")
+		sources[unit] = journalSource{path: file}
+	}
+
+	hostServiceFiles, err := filepath.Glob(filepath.Join(baseDir, "host_service_logs", "*", "*_service.log"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob for host service logs: %w", err)
+	}
+	for _, file := range hostServiceFiles {
+		unit := strings.TrimSuffix(filepath.Base(file), "_service.log")
+		// The per-node file is more specific, prefer it
+		if _, exists := sources[unit]; exists {
+			continue
+		}
+		sources[unit] = journalSource{path: file, filterHost: true}
+	}
+
+	return sources, nil
+}
+
+// nodeJournal returns the journal lines of the given units on the given node ordered by time. If no
+// units are passed, all units found in the dump are returned. The journal has no year in its timestamps,
+// it is taken from the time of the dump or, if that is unknown, the modification time of the file. Lines
+// before since or after until are omitted, unless they are zero.
+func nodeJournal(sources map[string]journalSource, nodeName string, units []string, dumpTime *time.Time, since, until time.Time) ([][]byte, error) {
+	requested := sets.NewString()
+	for _, unit := range units {
+		requested.Insert(strings.TrimSuffix(unit, ".service"))
+	}
+
+	var lines []journalLine
+	for unit, source := range sources {
+		if requested.Len() > 0 && !requested.Has(unit) {
+			continue
+		}
+		fromSource, err := readJournalFile(source.path, nodeName, source.filterHost, dumpTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read journal of unit %s: %w", unit, err)
+		}
+		lines = append(lines, fromSource...)
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].ts.Before(lines[j].ts)
+	})
+
+	result := make([][]byte, 0, len(lines))
+	for _, line := range lines {
+		if (!since.IsZero() && line.ts.Before(since)) || (!until.IsZero() && line.ts.After(until)) {
+			continue
+		}
+		result = append(result, line.content)
+	}
+
+	return result, nil
+}
+
+type journalLine struct {
+	ts      time.Time
+	content []byte
+}
+
+// readJournalFile reads a journal in the short output format of journalctl (<Mmm dd hh:mm:ss> <host> <ident>: <msg>).
+// Lines that can't be parsed inherit the timestamp and host of the previous line. The year of the timestamps is the
+// one of the reference time, which is the modification time of the file if nil.
+func readJournalFile(path, nodeName string, filterHost bool, reference *time.Time) ([]journalLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if reference == nil {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		modTime := info.ModTime()
+		reference = &modTime
+	}
+
+	var reader io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		data, err := readGzip(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	var result []journalLine
+	var lastTS time.Time
+	lastMatches := !filterHost
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if ts, host, ok := parseJournalLine(line, *reference); ok {
+			lastTS = ts
+			lastMatches = !filterHost || journalHostMatches(host, nodeName)
+		}
+		if !lastMatches {
+			continue
+		}
+		result = append(result, journalLine{ts: lastTS, content: append([]byte(nil), line...)})
+	}
+
+	return result, scanner.Err()
+}
+
+// parseJournalLine parses the timestamp and host of a journal line. The timestamp gets the year of the reference
+// time, unless that would make it later than the reference time, in which case the line is from the previous year.
+func parseJournalLine(line []byte, reference time.Time) (time.Time, string, bool) {
+	if len(line) < len(time.Stamp)+1 {
+		return time.Time{}, "", false
+	}
+	ts, err := time.Parse(time.Stamp, string(line[:len(time.Stamp)]))
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	ts = time.Date(reference.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), 0, time.UTC)
+	// The journal uses the local time of the node, allow for its offset to the reference time
+	if ts.After(reference.Add(24 * time.Hour)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	fields := strings.Fields(string(line[len(time.Stamp):]))
+	if len(fields) == 0 {
+		return time.Time{}, "", false
+	}
+
+	return ts, fields[0], true
+}
+
+// journalHostMatches checks if the host of a journal line is the node. The journal usually contains the short
+// hostname while node names are often fully qualified.
+func journalHostMatches(host, nodeName string) bool {
+	return host == nodeName || strings.HasPrefix(nodeName, host+".")
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/alvaroaleman/static-kas/pkg/hypershift"
+	"github.com/alvaroaleman/static-kas/pkg/timeline"
+)
+
+// Server serves a context of a kubeconfig for multiple dumps.
+type Server struct {
+	// Name is the name of the cluster and context in the kubeconfig. It is the base dir of a dump or the cluster id
+	// of a timeline.
+	Name string
+	// Aliases are the names of additional contexts for the cluster.
+	Aliases []string
+	// New constructs the handler. Discovering the resources of a dump can take a while, so this is not done upfront.
+	New func() (http.Handler, error)
+}
+
+// Servers finds all dumps below baseDir and returns a server for each of them, sorted by base dir. Hosted clusters
+// get the control plane namespace from the dump of their management cluster. Multiple dumps of the same cluster are
+// additionally served as timeline, after the dumps and sorted by cluster id. Each dump of a timeline can also be
+// selected with a context named after the cluster id and the time of the dump.
+func Servers(l *zap.Logger, baseDir string, opts ...Option) ([]Server, error) {
+	baseDirs := sets.NewString()
+	if err := filepath.WalkDir(baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || d.Name() != "namespaces" {
+			return nil
+		}
+		l.Info("Found dump", zap.String("path", filepath.Dir(path)))
+		baseDirs.Insert(filepath.Dir(path))
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to walk to find dumps: %w", err)
+	}
+	if baseDirs.Len() == 0 {
+		return nil, fmt.Errorf("found no dumps in %s", baseDir)
+	}
+
+	// baseDir -> namespaces that are served from the dump of the management cluster
+	baseDirNamespaceDirs := map[string]map[string]string{}
+	for _, baseDir := range baseDirs.List() {
+		hostedClusters, err := hypershift.HostedClusters(baseDir)
+		if err != nil {
+			l.Warn("failed to find hosted clusters", zap.String("baseDir", baseDir), zap.Error(err))
+			continue
+		}
+		for _, hostedCluster := range hostedClusters {
+			l.Info("Found hosted cluster",
+				zap.String("namespace", hostedCluster.Namespace),
+				zap.String("name", hostedCluster.Name),
+				zap.String("path", hostedCluster.GuestDir),
+			)
+			if !baseDirs.Has(hostedCluster.GuestDir) {
+				l.Warn("Hosted cluster has no dump of its own, only its control plane will be served", zap.String("name", hostedCluster.Name))
+				baseDirs.Insert(hostedCluster.GuestDir)
+			}
+			baseDirNamespaceDirs[hostedCluster.GuestDir] = hostedCluster.NamespaceDirs()
+		}
+	}
+	optsFor := func(baseDir string) []Option {
+		if namespaceDirs, found := baseDirNamespaceDirs[baseDir]; found {
+			return append(opts[:len(opts):len(opts)], WithNamespaceDirs(namespaceDirs))
+		}
+		return opts
+	}
+
+	timelines, err := timeline.Group(baseDirs.List())
+	if err != nil {
+		l.Warn("encountered errors grouping dumps by cluster", zap.Error(err))
+	}
+	aliases := map[string][]string{}
+	var clusterIDs []string
+	for clusterID, snapshots := range timelines {
+		if len(snapshots) < 2 {
+			continue
+		}
+		clusterIDs = append(clusterIDs, clusterID)
+		for _, snapshot := range snapshots {
+			aliases[snapshot.BaseDir] = append(aliases[snapshot.BaseDir], snapshot.Name())
+		}
+	}
+	sort.Strings(clusterIDs)
+
+	var result []Server
+	for _, baseDir := range baseDirs.List() {
+		baseDir := baseDir
+		l := l.With(zap.String("baseDir", baseDir))
+		result = append(result, Server{Name: baseDir, Aliases: aliases[baseDir], New: func() (http.Handler, error) {
+			return New(l, baseDir, optsFor(baseDir)...)
+		}})
+	}
+	for _, clusterID := range clusterIDs {
+		snapshots := timelines[clusterID]
+		l := l.With(zap.String("clusterID", clusterID))
+		l.Info("Found multiple dumps of cluster, serving them as timeline", zap.Int("snapshots", len(snapshots)))
+		result = append(result, Server{Name: clusterID, New: func() (http.Handler, error) {
+			handlers := make([]http.Handler, 0, len(snapshots))
+			for _, snapshot := range snapshots {
+				router, err := New(l.With(zap.String("baseDir", snapshot.BaseDir)), snapshot.BaseDir, optsFor(snapshot.BaseDir)...)
+				if err != nil {
+					return nil, err
+				}
+				handlers = append(handlers, router)
+			}
+			return timeline.NewHandler(l, snapshots, handlers), nil
+		}})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+
+	"github.com/alvaroaleman/static-kas/pkg/discovery"
+	"github.com/alvaroaleman/static-kas/pkg/response"
+	"github.com/alvaroaleman/static-kas/pkg/transform"
+)
+
+// subresourceHandler serves the status and scale subresources. The status subresource is the object itself, the
+// scale subresource is constructed from the replica and selector paths of the resource. Dry-run updates and patches
+// of the scale subresource, as sent by kubectl scale --dry-run=server, return the updated scale.
+func subresourceHandler(
+	l *zap.Logger,
+	dirs dumpDirs,
+	crds map[string]*apiextensionsv1.CustomResourceDefinition,
+	tableTransform func(transform.TransformEntryKey, string, transform.TableOptions) transform.TransformFunc,
+	allNamespaces *unstructured.UnstructuredList,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		l := l.With(zap.String("path", r.URL.Path))
+
+		group, groupVersion := "core", "v1"
+		if vars["group"] != "" {
+			group, groupVersion = vars["group"], vars["group"]+"/"+vars["version"]
+		}
+		parentDir := filepath.Join(dirs.baseDir, "cluster-scoped-resources", group)
+		if vars["namespace"] != "" {
+			parentDir = filepath.Join(dirs.namespaceDir(vars["namespace"]), group)
+		}
+
+		if r.Method != http.MethodGet && (vars["subresource"] != "scale" || !isDryRun(r)) {
+			writeStatus(l, w, r, &apierrors.StatusError{ErrStatus: metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusMethodNotAllowed,
+				Reason:  metav1.StatusReasonMethodNotAllowed,
+				Message: fmt.Sprintf("%v, only dry-run updates of the scale subresource are supported", errStaticSnapshot),
+			}})
+			return
+		}
+
+		if vars["subresource"] == "status" {
+			transformFunc, err := transformFor(r, tableTransform, transformKey(vars, transform.VerbGet))
+			if err != nil {
+				writeStatus(l, w, r, err)
+				return
+			}
+			var staticFallBack *unstructured.Unstructured
+			if group == "core" && vars["namespace"] == "" && vars["resource"] == "namespaces" {
+				staticFallBack = findByName(allNamespaces, vars["name"])
+			}
+			if err := response.NewGetResponse(r, w, parentDir, vars["resource"], vars["name"], staticFallBack, transformFunc); err != nil {
+				l.Error("failed to respond", zap.Error(err))
+			}
+			return
+		}
+
+		groupResource := schema.GroupResource{Group: vars["group"], Resource: vars["resource"]}
+		scalePaths, scalable := discovery.ScaleSubresource(vars["resource"], groupVersion, crds)
+		if !scalable {
+			writeStatus(l, w, r, apierrors.NewNotFound(groupResource, vars["name"]+"/scale"))
+			return
+		}
+		object, found, err := response.ReadObject(parentDir, vars["resource"], vars["name"])
+		if err != nil {
+			writeStatus(l, w, r, fmt.Errorf("failed to read: %w", err))
+			return
+		}
+		if !found {
+			writeStatus(l, w, r, apierrors.NewNotFound(groupResource, vars["name"]))
+			return
+		}
+		scale, err := scaleFor(object, scalePaths)
+		if err != nil {
+			l.Error("failed to construct scale", zap.Error(err))
+			writeStatus(l, w, r, fmt.Errorf("failed to construct scale: %w", err))
+			return
+		}
+		if r.Method != http.MethodGet {
+			if err := updateScale(r, scale); err != nil {
+				writeStatus(l, w, r, err)
+				return
+			}
+		}
+
+		transformFunc, err := transformFor(r, tableTransform, transform.TransformEntryKey{ResourceName: "scale", GroupName: "autoscaling", Version: "v1", Verb: transform.VerbGet})
+		if err != nil {
+			writeStatus(l, w, r, err)
+			return
+		}
+		if err := response.NewObjectResponse(r, w, scale, transformFunc); err != nil {
+			l.Error("failed to respond", zap.Error(err))
+		}
+	}
+}
+
+// scaleFor constructs the autoscaling/v1 Scale of an object.
+func scaleFor(object *unstructured.Unstructured, paths *apiextensionsv1.CustomResourceSubresourceScale) (*unstructured.Unstructured, error) {
+	scale := &autoscalingv1.Scale{
+		TypeMeta: metav1.TypeMeta{APIVersion: "autoscaling/v1", Kind: "Scale"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              object.GetName(),
+			Namespace:         object.GetNamespace(),
+			UID:               object.GetUID(),
+			ResourceVersion:   object.GetResourceVersion(),
+			CreationTimestamp: object.GetCreationTimestamp(),
+		},
+	}
+
+	specReplicas, err := replicasAt(object, paths.SpecReplicasPath)
+	if err != nil {
+		return nil, err
+	}
+	scale.Spec.Replicas = specReplicas
+	statusReplicas, err := replicasAt(object, paths.StatusReplicasPath)
+	if err != nil {
+		return nil, err
+	}
+	scale.Status.Replicas = statusReplicas
+	if paths.LabelSelectorPath != nil {
+		selector, err := selectorAt(object, *paths.LabelSelectorPath)
+		if err != nil {
+			return nil, err
+		}
+		scale.Status.Selector = selector
+	}
+
+	serialized, err := runtime.DefaultUnstructuredConverter.ToUnstructured(scale)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert scale to unstructured: %w", err)
+	}
+
+	return &unstructured.Unstructured{Object: serialized}, nil
+}
+
+// isDryRun returns true if the request asks for all stages to be run in dry-run mode.
+func isDryRun(r *http.Request) bool {
+	for _, dryRun := range r.URL.Query()["dryRun"] {
+		if dryRun == metav1.DryRunAll {
+			return true
+		}
+	}
+	return false
+}
+
+// maxScaleBodySize is the maximum size of the body of an update or patch of the scale subresource.
+const maxScaleBodySize = 1 << 20
+
+// updateScale applies the update or patch in the body of the request to scale. Only the replicas of the spec are
+// taken from the result, everything else of a scale is derived from its object.
+func updateScale(r *http.Request, scale *unstructured.Unstructured) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxScaleBodySize))
+	if err != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("failed to read body: %v", err))
+	}
+	current, err := json.Marshal(scale.Object)
+	if err != nil {
+		return fmt.Errorf("failed to serialize scale: %w", err)
+	}
+
+	updated := body
+	if r.Method == http.MethodPatch {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch types.PatchType(mediaType) {
+		case types.JSONPatchType:
+			var patch jsonpatch.Patch
+			if patch, err = jsonpatch.DecodePatch(body); err == nil {
+				updated, err = patch.Apply(current)
+			}
+		case types.MergePatchType:
+			updated, err = jsonpatch.MergePatch(current, body)
+		case types.StrategicMergePatchType:
+			updated, err = strategicpatch.StrategicMergePatch(current, body, &autoscalingv1.Scale{})
+		default:
+			return &apierrors.StatusError{ErrStatus: metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusUnsupportedMediaType,
+				Reason:  metav1.StatusReasonUnsupportedMediaType,
+				Message: fmt.Sprintf("patch type %q is not supported, supported are %s, %s and %s", mediaType, types.JSONPatchType, types.MergePatchType, types.StrategicMergePatchType),
+			}}
+		}
+		if err != nil {
+			return apierrors.NewBadRequest(fmt.Sprintf("failed to apply patch: %v", err))
+		}
+	}
+
+	updatedScale := &autoscalingv1.Scale{}
+	if err := json.Unmarshal(updated, updatedScale); err != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("failed to decode scale: %v", err))
+	}
+	if updatedScale.Name != "" && updatedScale.Name != scale.GetName() {
+		return apierrors.NewBadRequest(fmt.Sprintf("the name of the scale %q does not match the name of the object %q", updatedScale.Name, scale.GetName()))
+	}
+	if updatedScale.Spec.Replicas < 0 {
+		return apierrors.NewBadRequest(fmt.Sprintf("spec.replicas must be greater than or equal to 0, was %d", updatedScale.Spec.Replicas))
+	}
+
+	return unstructured.SetNestedField(scale.Object, int64(updatedScale.Spec.Replicas), "spec", "replicas")
+}
+
+func replicasAt(object *unstructured.Unstructured, jsonPath string) (int32, error) {
+	val, found, err := unstructured.NestedFieldNoCopy(object.Object, splitJSONPath(jsonPath)...)
+	if err != nil || !found {
+		return 0, err
+	}
+	switch val := val.(type) {
+	case int64:
+		return int32(val), nil
+	case float64:
+		return int32(val), nil
+	default:
+		return 0, fmt.Errorf("expected %s to be a number, was %T", jsonPath, val)
+	}
+}
+
+// selectorAt returns the label selector at the given path as string. It can be a string, a metav1.LabelSelector
+// or, for ReplicationControllers, a plain map of labels.
+func selectorAt(object *unstructured.Unstructured, jsonPath string) (string, error) {
+	val, found, err := unstructured.NestedFieldNoCopy(object.Object, splitJSONPath(jsonPath)...)
+	if err != nil || !found {
+		return "", err
+	}
+	switch val := val.(type) {
+	case string:
+		return val, nil
+	case map[string]interface{}:
+		_, hasMatchLabels := val["matchLabels"]
+		_, hasMatchExpressions := val["matchExpressions"]
+		if hasMatchLabels || hasMatchExpressions {
+			labelSelector := &metav1.LabelSelector{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(val, labelSelector); err != nil {
+				return "", fmt.Errorf("failed to convert %s to a label selector: %w", jsonPath, err)
+			}
+			selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+			if err != nil {
+				return "", fmt.Errorf("%s is not a valid label selector: %w", jsonPath, err)
+			}
+			return selector.String(), nil
+		}
+		set := labels.Set{}
+		for k, v := range val {
+			set[k] = fmt.Sprint(v)
+		}
+		return labels.SelectorFromSet(set).String(), nil
+	default:
+		return "", fmt.Errorf("expected %s to be a label selector, was %T", jsonPath, val)
+	}
+}
+
+func splitJSONPath(jsonPath string) []string {
+	return strings.Split(strings.TrimPrefix(jsonPath, "."), ".")
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+
+	"github.com/alvaroaleman/static-kas/pkg/rbac"
+)
+
+// whoCanResponse is the response of the who-can endpoint.
+type whoCanResponse struct {
+	Grants []rbac.Grant `json:"grants"`
+}
+
+// whoCanHandler returns all subjects that are allowed to do what the verb, group, resource, subresource, name and
+// namespace or nonResourceURL query args describe, together with the binding, role and rule that allow it.
+func whoCanHandler(l *zap.Logger, a *rbac.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := l.With(zap.String("path", r.URL.Path))
+		query := r.URL.Query()
+		attributes := authorizer.AttributesRecord{
+			Verb:            query.Get("verb"),
+			Namespace:       query.Get("namespace"),
+			APIGroup:        query.Get("group"),
+			Resource:        query.Get("resource"),
+			Subresource:     query.Get("subresource"),
+			Name:            query.Get("name"),
+			Path:            query.Get("nonResourceURL"),
+			ResourceRequest: query.Get("nonResourceURL") == "",
+		}
+		if attributes.Verb == "" {
+			http.Error(w, "the verb query arg is mandatory", http.StatusBadRequest)
+			return
+		}
+		if (attributes.Resource == "") == (attributes.Path == "") {
+			http.Error(w, "exactly one of the resource or nonResourceURL query args must be set", http.StatusBadRequest)
+			return
+		}
+		if attributes.Path != "" && !strings.HasPrefix(attributes.Path, "/") {
+			http.Error(w, "nonResourceURL must start with a slash", http.StatusBadRequest)
+			return
+		}
+
+		serializeAndWrite(l, w, whoCanResponse{Grants: a.WhoCan(attributes)})
+	}
+}
@@ -0,0 +1,79 @@
+package hypershift
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alvaroaleman/static-kas/pkg/response"
+)
+
+// HostedCluster is a hosted cluster found in the dump of a management cluster.
+type HostedCluster struct {
+	Namespace string
+	Name      string
+	// ControlPlaneNamespace is the namespace of the management cluster the control plane of the hosted cluster
+	// runs in.
+	ControlPlaneNamespace string
+	// ControlPlaneDir is the directory of the control plane namespace in the dump of the management cluster.
+	ControlPlaneDir string
+	// GuestDir is the directory of the dump of the hosted cluster itself. It doesn't exist if only the management
+	// cluster was dumped.
+	GuestDir string
+}
+
+// NamespaceDirs returns the control plane namespace mapped to its directory in the dump of the management cluster.
+func (hc HostedCluster) NamespaceDirs() map[string]string {
+	return map[string]string{hc.ControlPlaneNamespace: hc.ControlPlaneDir}
+}
+
+// HostedClusters returns the hosted clusters in the dump of a management cluster, sorted by namespace and name.
+// Hosted clusters whose control plane namespace is not part of the dump are omitted.
+func HostedClusters(baseDir string) ([]HostedCluster, error) {
+	namespaces, err := os.ReadDir(filepath.Join(baseDir, "namespaces"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	var result []HostedCluster
+	for _, namespace := range namespaces {
+		if !namespace.IsDir() {
+			continue
+		}
+		hostedClusters, err := response.ReadAndDeserializeList(filepath.Join(baseDir, "namespaces", namespace.Name(), "hypershift.openshift.io"), "hostedclusters")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read hostedclusters in namespace %s: %w", namespace.Name(), err)
+		}
+		for _, hostedCluster := range hostedClusters.Items {
+			hc := HostedCluster{
+				Namespace:             namespace.Name(),
+				Name:                  hostedCluster.GetName(),
+				ControlPlaneNamespace: controlPlaneNamespace(namespace.Name(), hostedCluster.GetName()),
+				GuestDir:              filepath.Join(baseDir, "hostedcluster-"+hostedCluster.GetName()),
+			}
+			hc.ControlPlaneDir = filepath.Join(baseDir, "namespaces", hc.ControlPlaneNamespace)
+			if _, err := os.Stat(hc.ControlPlaneDir); err != nil {
+				continue
+			}
+			result = append(result, hc)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// controlPlaneNamespace returns the namespace hypershift runs the control plane of a hosted cluster in.
+func controlPlaneNamespace(namespace, name string) string {
+	return namespace + "-" + strings.ReplaceAll(name, ".", "-")
+}
@@ -0,0 +1,378 @@
+package layout
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alvaroaleman/static-kas/pkg/discovery"
+)
+
+// Normalize returns the base dir of the must-gather layout for path, which everything else expects:
+//   - A must-gather or `oc adm inspect` dump is used as-is
+//   - A directory that contains multiple dumps, like the must-gathers of multiple clusters, is used as-is
+//   - A directory that only contains a single dump, like the must-gather.local.<id> and inspect.local.<id>
+//     directories, is replaced by the dump
+//   - A file or a directory of files with objects of any kind, like the output of `kubectl get -o yaml`, is
+//     converted into the must-gather layout in a temporary directory that is removed by calling cleanup
+func Normalize(path string) (baseDir string, cleanup func(), err error) {
+	cleanup = func() {}
+	baseDir, files, err := resolve(path)
+	if err != nil {
+		return "", cleanup, err
+	}
+	if len(files) == 0 {
+		return baseDir, cleanup, nil
+	}
+	var objects []unstructured.Unstructured
+	for _, file := range files {
+		fromFile, err := readObjects(file)
+		if err != nil {
+			return "", cleanup, err
+		}
+		objects = append(objects, fromFile...)
+	}
+
+	return FromObjects(objects)
+}
+
+// Diagnose reports the problems of the dump at path like discovery.Diagnose. Files that Normalize converts are
+// diagnosed as they are rather than their converted copy, so problems refer to them and the lines in them.
+func Diagnose(path string) (*discovery.Report, error) {
+	baseDir, files, err := resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return discovery.Diagnose(baseDir, nil)
+	}
+	root := path
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		root = filepath.Dir(path)
+	}
+
+	return discovery.DiagnoseManifests(root, files, readObjects), nil
+}
+
+// resolve returns the base dir of the dump at path that can be used as-is or the manifests that need to be
+// converted.
+func resolve(path string) (baseDir string, files []string, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if info.IsDir() {
+		for {
+			if isDump(path) {
+				return path, nil, nil
+			}
+			nested, ok, err := singleSubdirectory(path)
+			if err != nil {
+				return "", nil, err
+			}
+			if !ok {
+				break
+			}
+			path = nested
+		}
+		containsDumps, err := containsDumps(path)
+		if err != nil {
+			return "", nil, err
+		}
+		if containsDumps {
+			return path, nil, nil
+		}
+	}
+
+	// Without manifests there is nothing to convert, the caller complains about the missing dump
+	files, err = manifestFiles(path)
+	return path, files, err
+}
+
+// FromObjects writes objects of any kind in the must-gather layout to a temporary directory that is removed by
+// calling cleanup.
+func FromObjects(objects []unstructured.Unstructured) (baseDir string, cleanup func(), err error) {
+	baseDir, err = os.MkdirTemp("", "static-kas-")
+	if err != nil {
+		return "", func() {}, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	cleanup = func() { os.RemoveAll(baseDir) }
+	if err := convert(objects, baseDir); err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+
+	return baseDir, cleanup, nil
+}
+
+// isDump returns true if dir contains a dump in the must-gather layout.
+func isDump(dir string) bool {
+	for _, name := range []string{"namespaces", "cluster-scoped-resources"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// containsDumps returns true if there is a dump in the must-gather layout anywhere below dir.
+func containsDumps(dir string) (bool, error) {
+	var result bool
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != dir && isDump(path) {
+			result = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to search for dumps in %s: %w", dir, err)
+	}
+
+	return result, nil
+}
+
+// singleSubdirectory returns the only subdirectory of dir if dir contains no manifests next to it.
+func singleSubdirectory(dir string) (string, bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	var subdirectories []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			subdirectories = append(subdirectories, entry.Name())
+		} else if isManifest(entry.Name()) {
+			return "", false, nil
+		}
+	}
+	if len(subdirectories) != 1 {
+		return "", false, nil
+	}
+
+	return filepath.Join(dir, subdirectories[0]), true, nil
+}
+
+func isManifest(name string) bool {
+	for _, suffix := range []string{".yaml", ".yml", ".json"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestFiles returns path if it is a file or all manifests below it if it is a directory.
+func manifestFiles(root string) ([]string, error) {
+	var result []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && (path == root || isManifest(d.Name())) {
+			result = append(result, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find manifests in %s: %w", root, err)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// convert writes objects in the must-gather layout to baseDir, grouped by their resource and namespace.
+func convert(objects []unstructured.Unstructured, baseDir string) error {
+	crds := map[string]*apiextensionsv1.CustomResourceDefinition{}
+	for _, object := range objects {
+		if object.GroupVersionKind() != apiextensionsv1.SchemeGroupVersion.WithKind("CustomResourceDefinition") {
+			continue
+		}
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, crd); err != nil {
+			return fmt.Errorf("failed to convert crd %s: %w", object.GetName(), err)
+		}
+		crds[crd.Name] = crd
+	}
+
+	// path of the list file -> items
+	lists := map[string][]unstructured.Unstructured{}
+	namespaces := map[string]*unstructured.Unstructured{}
+	for i := range objects {
+		object := objects[i]
+		gvk := object.GroupVersionKind()
+		if gvk.Group == "" && gvk.Kind == "Namespace" {
+			namespaces[object.GetName()] = &object
+			continue
+		}
+		resource, namespaced, known := discovery.ResourceFor(gvk, crds)
+		if !known {
+			namespaced = object.GetNamespace() != ""
+		}
+		group := gvk.Group
+		if group == "" {
+			group = "core"
+		}
+		dir := filepath.Join(baseDir, "cluster-scoped-resources", group)
+		if namespaced {
+			if object.GetNamespace() == "" {
+				object.SetNamespace("default")
+			}
+			if _, found := namespaces[object.GetNamespace()]; !found {
+				namespaces[object.GetNamespace()] = nil
+			}
+			dir = filepath.Join(baseDir, "namespaces", object.GetNamespace(), group)
+		}
+		path := filepath.Join(dir, resource+".yaml")
+		lists[path] = append(lists[path], object)
+	}
+
+	for path, items := range lists {
+		list := &unstructured.UnstructuredList{Items: items}
+		list.SetAPIVersion(items[0].GetAPIVersion())
+		list.SetKind(items[0].GetKind() + "List")
+		if err := writeYAML(path, list); err != nil {
+			return err
+		}
+	}
+	// Like must-gather, every namespace has a directory with the namespace itself in it
+	for name, namespace := range namespaces {
+		dir := filepath.Join(baseDir, "namespaces", name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory for namespace %s: %w", name, err)
+		}
+		if namespace == nil {
+			continue
+		}
+		if err := writeYAML(filepath.Join(dir, name+".yaml"), namespace); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readObjects reads all objects from a file that contains any number of YAML or JSON documents. Lists are split
+// into their items. Parse errors name the line of the file.
+func readObjects(file string) ([]unstructured.Unstructured, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	var result []unstructured.Unstructured
+	documents, firstLines := yamlDocuments(data)
+	for i, document := range documents {
+		decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(document), 4096)
+		for {
+			object := map[string]interface{}{}
+			if err := decoder.Decode(&object); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, fmt.Errorf("failed to decode %s: %w", file, fileLineError(err, firstLines[i]))
+			}
+			if len(object) == 0 {
+				continue
+			}
+			u := unstructured.Unstructured{Object: object}
+			if !u.IsList() {
+				result = append(result, u)
+				continue
+			}
+			list, err := u.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode list in %s at line %d: %w", file, firstLines[i], err)
+			}
+			for _, item := range list.Items {
+				// Items of typed lists may omit their kind and apiVersion
+				if item.GetKind() == "" && u.GetKind() != "List" {
+					item.SetKind(strings.TrimSuffix(u.GetKind(), "List"))
+				}
+				if item.GetAPIVersion() == "" {
+					item.SetAPIVersion(u.GetAPIVersion())
+				}
+				if item.GetKind() == "" {
+					return nil, fmt.Errorf("item %s in the list at line %d of %s has no kind", item.GetName(), firstLines[i], file)
+				}
+				result = append(result, item)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// yamlDocuments splits data at the `---` lines that separate YAML documents and returns the documents with the line
+// of the file each of them starts at. JSON contains no separators and is returned as a single document.
+func yamlDocuments(data []byte) (documents [][]byte, firstLines []int) {
+	start, firstLine := 0, 1
+	for offset, line := 0, 1; offset < len(data); line++ {
+		end := len(data)
+		if newline := bytes.IndexByte(data[offset:], '\n'); newline >= 0 {
+			end = offset + newline + 1
+		}
+		if isDocumentSeparator(data[offset:end]) {
+			documents, firstLines = append(documents, data[start:offset]), append(firstLines, firstLine)
+			start, firstLine = end, line+1
+		}
+		offset = end
+	}
+
+	return append(documents, data[start:]), append(firstLines, firstLine)
+}
+
+// isDocumentSeparator returns true if the line separates YAML documents, which it does if it starts with `---`
+// followed by nothing but a comment.
+func isDocumentSeparator(line []byte) bool {
+	if !bytes.HasPrefix(line, []byte("---")) {
+		return false
+	}
+	rest := bytes.TrimSpace(line[3:])
+	return len(rest) == 0 || rest[0] == '#'
+}
+
+var yamlLineRegex = regexp.MustCompile(`line (\d+):`)
+
+// fileLineError returns err with the line it names, which counts from the start of the document, counted from the
+// start of the file instead. Errors without a line get the one the document starts at.
+func fileLineError(err error, firstLine int) error {
+	message := err.Error()
+	match := yamlLineRegex.FindStringSubmatchIndex(message)
+	if match == nil {
+		return fmt.Errorf("document at line %d: %w", firstLine, err)
+	}
+	line, _ := strconv.Atoi(message[match[2]:match[3]])
+	return errors.New(message[:match[2]] + strconv.Itoa(firstLine+line-1) + message[match[3]:])
+}
+
+func writeYAML(path string, object interface{}) error {
+	serialized, err := yaml.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, serialized, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
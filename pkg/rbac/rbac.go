@@ -0,0 +1,281 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
+
+	"github.com/alvaroaleman/static-kas/pkg/response"
+)
+
+const group = "rbac.authorization.k8s.io"
+
+// Authorizer evaluates requests against the Roles, ClusterRoles, RoleBindings and ClusterRoleBindings of a dump.
+type Authorizer struct {
+	snapshot *snapshot
+	rbac     *rbacauthorizer.RBACAuthorizer
+}
+
+// NewAuthorizer reads the RBAC objects of the dump in baseDir. namespaceDirs maps namespaces that are served from
+// outside of the dump to their directory. Objects that fail to be read are skipped and reported through the returned
+// error, the Authorizer is usable regardless.
+func NewAuthorizer(baseDir string, namespaceDirs map[string]string) (*Authorizer, error) {
+	s, err := readSnapshot(baseDir, namespaceDirs)
+	return &Authorizer{
+		snapshot: s,
+		rbac:     rbacauthorizer.New(s, s, s, s),
+	}, err
+}
+
+// Authorize checks if the request described by attributes is allowed. Like in the kube-apiserver, members of
+// the system:masters group are allowed everything.
+func (a *Authorizer) Authorize(ctx context.Context, attributes authorizer.Attributes) (authorizer.Decision, string, error) {
+	if isPrivileged(attributes.GetUser()) {
+		return authorizer.DecisionAllow, "", nil
+	}
+	return a.rbac.Authorize(ctx, attributes)
+}
+
+// RulesFor returns the rules the user has in the given namespace.
+func (a *Authorizer) RulesFor(u user.Info, namespace string) ([]authorizer.ResourceRuleInfo, []authorizer.NonResourceRuleInfo, bool, error) {
+	if isPrivileged(u) {
+		return []authorizer.ResourceRuleInfo{&authorizer.DefaultResourceRuleInfo{
+			Verbs:     []string{"*"},
+			APIGroups: []string{"*"},
+			Resources: []string{"*"},
+		}}, []authorizer.NonResourceRuleInfo{&authorizer.DefaultNonResourceRuleInfo{
+			Verbs:           []string{"*"},
+			NonResourceURLs: []string{"*"},
+		}}, false, nil
+	}
+	return a.rbac.RulesFor(u, namespace)
+}
+
+func isPrivileged(u user.Info) bool {
+	for _, group := range u.GetGroups() {
+		if group == user.SystemPrivilegedGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// Attributes constructs the authorizer attributes of an access review.
+func Attributes(u user.Info, resourceAttributes *authorizationv1.ResourceAttributes, nonResourceAttributes *authorizationv1.NonResourceAttributes) authorizer.Attributes {
+	if resourceAttributes != nil {
+		return authorizer.AttributesRecord{
+			User:            u,
+			Verb:            resourceAttributes.Verb,
+			Namespace:       resourceAttributes.Namespace,
+			APIGroup:        resourceAttributes.Group,
+			APIVersion:      resourceAttributes.Version,
+			Resource:        resourceAttributes.Resource,
+			Subresource:     resourceAttributes.Subresource,
+			Name:            resourceAttributes.Name,
+			ResourceRequest: true,
+		}
+	}
+	if nonResourceAttributes != nil {
+		return authorizer.AttributesRecord{
+			User: u,
+			Verb: nonResourceAttributes.Verb,
+			Path: nonResourceAttributes.Path,
+		}
+	}
+
+	return authorizer.AttributesRecord{User: u}
+}
+
+// snapshot contains the RBAC objects of a dump. It implements the getters and listers the rbac authorizer needs.
+type snapshot struct {
+	roles               map[string]map[string]*rbacv1.Role
+	roleBindings        map[string][]*rbacv1.RoleBinding
+	clusterRoles        map[string]*rbacv1.ClusterRole
+	clusterRoleBindings []*rbacv1.ClusterRoleBinding
+}
+
+func (s *snapshot) GetRole(namespace, name string) (*rbacv1.Role, error) {
+	if role, found := s.roles[namespace][name]; found {
+		return role, nil
+	}
+	return nil, apierrors.NewNotFound(rbacv1.Resource("roles"), name)
+}
+
+func (s *snapshot) ListRoleBindings(namespace string) ([]*rbacv1.RoleBinding, error) {
+	return s.roleBindings[namespace], nil
+}
+
+func (s *snapshot) GetClusterRole(name string) (*rbacv1.ClusterRole, error) {
+	if clusterRole, found := s.clusterRoles[name]; found {
+		return clusterRole, nil
+	}
+	return nil, apierrors.NewNotFound(rbacv1.Resource("clusterroles"), name)
+}
+
+func (s *snapshot) ListClusterRoleBindings() ([]*rbacv1.ClusterRoleBinding, error) {
+	return s.clusterRoleBindings, nil
+}
+
+func readSnapshot(baseDir string, namespaceDirs map[string]string) (*snapshot, error) {
+	s := &snapshot{
+		roles:        map[string]map[string]*rbacv1.Role{},
+		roleBindings: map[string][]*rbacv1.RoleBinding{},
+		clusterRoles: map[string]*rbacv1.ClusterRole{},
+	}
+	var errs []error
+
+	clusterScopedDir := filepath.Join(baseDir, "cluster-scoped-resources", group)
+	errs = append(errs, readEach(clusterScopedDir, "clusterroles", func(u map[string]interface{}) error {
+		clusterRole := &rbacv1.ClusterRole{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u, clusterRole); err != nil {
+			return err
+		}
+		s.clusterRoles[clusterRole.Name] = clusterRole
+		return nil
+	})...)
+	errs = append(errs, readEach(clusterScopedDir, "clusterrolebindings", func(u map[string]interface{}) error {
+		binding := &rbacv1.ClusterRoleBinding{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u, binding); err != nil {
+			return err
+		}
+		s.clusterRoleBindings = append(s.clusterRoleBindings, binding)
+		return nil
+	})...)
+
+	entries, err := os.ReadDir(filepath.Join(baseDir, "namespaces"))
+	if err != nil && !os.IsNotExist(err) {
+		errs = append(errs, fmt.Errorf("failed to list namespaces: %w", err))
+	}
+	dirs := make(map[string]string, len(entries)+len(namespaceDirs))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs[entry.Name()] = filepath.Join(baseDir, "namespaces", entry.Name())
+		}
+	}
+	for namespace, dir := range namespaceDirs {
+		dirs[namespace] = dir
+	}
+	namespaces := make([]string, 0, len(dirs))
+	for namespace := range dirs {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		namespace := namespace
+		namespacedDir := filepath.Join(dirs[namespace], group)
+		errs = append(errs, readEach(namespacedDir, "roles", func(u map[string]interface{}) error {
+			role := &rbacv1.Role{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u, role); err != nil {
+				return err
+			}
+			role.Namespace = namespace
+			if s.roles[namespace] == nil {
+				s.roles[namespace] = map[string]*rbacv1.Role{}
+			}
+			s.roles[namespace][role.Name] = role
+			return nil
+		})...)
+		errs = append(errs, readEach(namespacedDir, "rolebindings", func(u map[string]interface{}) error {
+			binding := &rbacv1.RoleBinding{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u, binding); err != nil {
+				return err
+			}
+			binding.Namespace = namespace
+			s.roleBindings[namespace] = append(s.roleBindings[namespace], binding)
+			return nil
+		})...)
+	}
+
+	if err := s.aggregateClusterRoles(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return s, utilerrors.NewAggregate(errs)
+}
+
+// aggregateClusterRoles sets the rules of ClusterRoles with an aggregationRule like the clusterrole-aggregation
+// controller does. This is usually already the case in the dump, but not if it was taken before the controller
+// caught up or if the aggregated ClusterRoles were edited manually. It is repeated until nothing changes to account
+// for ClusterRoles that aggregate other aggregated ClusterRoles.
+func (s *snapshot) aggregateClusterRoles() error {
+	names := make([]string, 0, len(s.clusterRoles))
+	for name := range s.clusterRoles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for changed, iterations := true, 0; changed && iterations <= len(names); iterations++ {
+		changed = false
+		for _, name := range names {
+			aggregated := s.clusterRoles[name]
+			if aggregated.AggregationRule == nil {
+				continue
+			}
+			var rules []rbacv1.PolicyRule
+			for _, labelSelector := range aggregated.AggregationRule.ClusterRoleSelectors {
+				selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+				if err != nil {
+					return fmt.Errorf("clusterrole %s has an invalid aggregationRule: %w", name, err)
+				}
+				for _, candidateName := range names {
+					candidate := s.clusterRoles[candidateName]
+					if candidateName == name || !selector.Matches(labels.Set(candidate.Labels)) {
+						continue
+					}
+					for _, rule := range candidate.Rules {
+						if !ruleExists(rules, rule) {
+							rules = append(rules, rule)
+						}
+					}
+				}
+			}
+			// Nothing matching most likely means the aggregated ClusterRoles are missing from the dump, keep what it has
+			if len(rules) > 0 && !equality.Semantic.DeepEqual(rules, aggregated.Rules) {
+				aggregated.Rules = rules
+				changed = true
+			}
+		}
+	}
+
+	return nil
+}
+
+func ruleExists(haystack []rbacv1.PolicyRule, needle rbacv1.PolicyRule) bool {
+	for _, rule := range haystack {
+		if equality.Semantic.DeepEqual(rule, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// readEach reads all objects of the given resource in parentDir and calls add for each of them.
+func readEach(parentDir, resource string, add func(map[string]interface{}) error) []error {
+	list, err := response.ReadAndDeserializeList(parentDir, resource)
+	if err != nil {
+		return []error{fmt.Errorf("failed to read %s in %s: %w", resource, parentDir, err)}
+	}
+
+	var errs []error
+	for _, item := range list.Items {
+		if err := add(item.Object); err != nil {
+			errs = append(errs, fmt.Errorf("failed to convert %s %s in %s: %w", resource, item.GetName(), parentDir, err))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,94 @@
+package rbac
+
+import (
+	"sort"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	rbacauthorizer "k8s.io/kubernetes/plugin/pkg/auth/authorizer/rbac"
+)
+
+// Grant is a subject that is allowed to do something together with the binding, role and rule that allow it.
+type Grant struct {
+	Subject rbacv1.Subject     `json:"subject"`
+	Binding *Reference         `json:"binding,omitempty"`
+	Role    *Reference         `json:"role,omitempty"`
+	Rule    *rbacv1.PolicyRule `json:"rule,omitempty"`
+}
+
+// Reference references a binding or a role.
+type Reference struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// WhoCan returns all subjects that are allowed to do what the attributes describe, the user of the attributes
+// is ignored. A subject is returned once for each rule that allows it. Bindings that reference roles that are
+// not part of the dump are skipped.
+func (a *Authorizer) WhoCan(attributes authorizer.Attributes) []Grant {
+	result := []Grant{{
+		Subject: rbacv1.Subject{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: user.SystemPrivilegedGroup},
+	}}
+
+	for _, binding := range a.snapshot.clusterRoleBindings {
+		ref := &Reference{Kind: "ClusterRoleBinding", Name: binding.Name}
+		result = append(result, a.snapshot.grantsFor(attributes, ref, binding.RoleRef, "", binding.Subjects)...)
+	}
+	if namespace := attributes.GetNamespace(); namespace != "" {
+		for _, binding := range a.snapshot.roleBindings[namespace] {
+			ref := &Reference{Kind: "RoleBinding", Namespace: namespace, Name: binding.Name}
+			result = append(result, a.snapshot.grantsFor(attributes, ref, binding.RoleRef, namespace, binding.Subjects)...)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Subject.Kind != result[j].Subject.Kind {
+			return result[i].Subject.Kind < result[j].Subject.Kind
+		}
+		if result[i].Subject.Namespace != result[j].Subject.Namespace {
+			return result[i].Subject.Namespace < result[j].Subject.Namespace
+		}
+		return result[i].Subject.Name < result[j].Subject.Name
+	})
+
+	return result
+}
+
+func (s *snapshot) grantsFor(attributes authorizer.Attributes, binding *Reference, roleRef rbacv1.RoleRef, bindingNamespace string, subjects []rbacv1.Subject) []Grant {
+	var rules []rbacv1.PolicyRule
+	role := &Reference{Kind: roleRef.Kind, Name: roleRef.Name}
+	switch roleRef.Kind {
+	case "ClusterRole":
+		clusterRole, err := s.GetClusterRole(roleRef.Name)
+		if err != nil {
+			return nil
+		}
+		rules = clusterRole.Rules
+	case "Role":
+		r, err := s.GetRole(bindingNamespace, roleRef.Name)
+		if err != nil {
+			return nil
+		}
+		role.Namespace = bindingNamespace
+		rules = r.Rules
+	default:
+		return nil
+	}
+
+	var result []Grant
+	for i := range rules {
+		if !rbacauthorizer.RuleAllows(attributes, &rules[i]) {
+			continue
+		}
+		for _, subject := range subjects {
+			if subject.Kind == rbacv1.ServiceAccountKind && subject.Namespace == "" {
+				subject.Namespace = bindingNamespace
+			}
+			result = append(result, Grant{Subject: subject, Binding: binding, Role: role, Rule: &rules[i]})
+		}
+	}
+
+	return result
+}
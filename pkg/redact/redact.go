@@ -0,0 +1,226 @@
+package redact
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Rules configure what gets redacted. Patterns are regular expressions that are matched against the whole
+// name or value.
+type Rules struct {
+	// Replacement is what redacted values get replaced with. Base64 encoded fields get the base64 encoded
+	// replacement.
+	Replacement string `json:"replacement,omitempty"`
+	// SecretData makes the data and stringData of all Secrets get redacted.
+	SecretData bool `json:"secretData"`
+	// EnvNamePatterns makes the value of all env vars whose name matches one of them get redacted.
+	EnvNamePatterns []string `json:"envNamePatterns,omitempty"`
+	// EnvValuePatterns makes the value of all env vars whose value matches one of them get redacted.
+	EnvValuePatterns []string `json:"envValuePatterns,omitempty"`
+	// ConfigMapKeyPatterns makes all ConfigMap keys that match one of them get redacted.
+	ConfigMapKeyPatterns []string `json:"configMapKeyPatterns,omitempty"`
+	// KubeconfigConfigMapValues makes all ConfigMap values that look like a kubeconfig with credentials
+	// get redacted.
+	KubeconfigConfigMapValues bool `json:"kubeconfigConfigMapValues"`
+	// AnnotationPatterns makes all annotations whose key matches one of them get redacted.
+	AnnotationPatterns []string `json:"annotationPatterns,omitempty"`
+}
+
+// DefaultRules returns the rules that are used if no rules file is configured.
+func DefaultRules() Rules {
+	return Rules{
+		Replacement:               "REDACTED",
+		SecretData:                true,
+		EnvNamePatterns:           []string{`(?i).*(password|passwd|secret|token|credential|api_?key|private_?key).*`},
+		ConfigMapKeyPatterns:      []string{`(?i).*kubeconfig.*`},
+		KubeconfigConfigMapValues: true,
+		AnnotationPatterns:        []string{`kubectl\.kubernetes\.io/last-applied-configuration`},
+	}
+}
+
+// LoadRules reads rules from a YAML file. Fields that are not set in the file keep their default.
+func LoadRules(path string) (Rules, error) {
+	rules := DefaultRules()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return rules, fmt.Errorf("failed to unmarshal %s: %w", path, err)
+	}
+
+	return rules, nil
+}
+
+// Redactor redacts objects according to its rules.
+type Redactor struct {
+	replacement               string
+	secretData                bool
+	envNamePatterns           []*regexp.Regexp
+	envValuePatterns          []*regexp.Regexp
+	configMapKeyPatterns      []*regexp.Regexp
+	kubeconfigConfigMapValues bool
+	annotationPatterns        []*regexp.Regexp
+}
+
+// New constructs a Redactor. It errors if one of the patterns is invalid.
+func New(rules Rules) (*Redactor, error) {
+	r := &Redactor{
+		replacement:               rules.Replacement,
+		secretData:                rules.SecretData,
+		kubeconfigConfigMapValues: rules.KubeconfigConfigMapValues,
+	}
+	if r.replacement == "" {
+		r.replacement = DefaultRules().Replacement
+	}
+
+	var err error
+	if r.envNamePatterns, err = compile(rules.EnvNamePatterns); err != nil {
+		return nil, fmt.Errorf("invalid envNamePatterns: %w", err)
+	}
+	if r.envValuePatterns, err = compile(rules.EnvValuePatterns); err != nil {
+		return nil, fmt.Errorf("invalid envValuePatterns: %w", err)
+	}
+	if r.configMapKeyPatterns, err = compile(rules.ConfigMapKeyPatterns); err != nil {
+		return nil, fmt.Errorf("invalid configMapKeyPatterns: %w", err)
+	}
+	if r.annotationPatterns, err = compile(rules.AnnotationPatterns); err != nil {
+		return nil, fmt.Errorf("invalid annotationPatterns: %w", err)
+	}
+
+	return r, nil
+}
+
+func compile(patterns []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, re)
+	}
+
+	return result, nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Redact redacts the object in place.
+func (r *Redactor) Redact(u *unstructured.Unstructured) {
+	isCore := u.GetAPIVersion() == "v1"
+	switch {
+	case isCore && u.GetKind() == "Secret" && r.secretData:
+		r.redactValues(u.Object, "data", base64.StdEncoding.EncodeToString([]byte(r.replacement)), nil)
+		r.redactValues(u.Object, "stringData", r.replacement, nil)
+	case isCore && u.GetKind() == "ConfigMap":
+		r.redactConfigMap(u.Object)
+	}
+
+	r.redactEnv(u.Object)
+
+	if annotations := u.GetAnnotations(); len(annotations) > 0 {
+		var changed bool
+		for key := range annotations {
+			if matchesAny(r.annotationPatterns, key) {
+				annotations[key] = r.replacement
+				changed = true
+			}
+		}
+		if changed {
+			u.SetAnnotations(annotations)
+		}
+	}
+}
+
+// redactValues replaces all values of the map in the given field for whose key shouldRedact returns true or
+// all of them if shouldRedact is nil.
+func (r *Redactor) redactValues(object map[string]interface{}, field, replacement string, shouldRedact func(key, value string) bool) {
+	values, ok := object[field].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for key, value := range values {
+		stringValue, _ := value.(string)
+		if shouldRedact == nil || shouldRedact(key, stringValue) {
+			values[key] = replacement
+		}
+	}
+}
+
+func (r *Redactor) redactConfigMap(object map[string]interface{}) {
+	r.redactValues(object, "data", r.replacement, func(key, value string) bool {
+		return matchesAny(r.configMapKeyPatterns, key) || (r.kubeconfigConfigMapValues && looksLikeKubeconfig(value))
+	})
+	r.redactValues(object, "binaryData", base64.StdEncoding.EncodeToString([]byte(r.replacement)), func(key, value string) bool {
+		if matchesAny(r.configMapKeyPatterns, key) {
+			return true
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		return err == nil && r.kubeconfigConfigMapValues && looksLikeKubeconfig(string(decoded))
+	})
+}
+
+// looksLikeKubeconfig returns true for values that look like a kubeconfig that contains credentials.
+func looksLikeKubeconfig(value string) bool {
+	if !strings.Contains(value, "kind: Config") && !strings.Contains(value, `"kind": "Config"`) && !strings.Contains(value, `"kind":"Config"`) {
+		return false
+	}
+	for _, credentialField := range []string{"client-key-data", "client-key", "token", "password", "auth-provider", "exec"} {
+		if strings.Contains(value, credentialField) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactEnv walks the whole object and redacts the value of all env vars matching the rules. This makes it
+// work for all objects that embed a pod template, including custom resources.
+func (r *Redactor) redactEnv(object interface{}) {
+	switch o := object.(type) {
+	case map[string]interface{}:
+		for key, value := range o {
+			if env, ok := value.([]interface{}); ok && key == "env" {
+				r.redactEnvVars(env)
+				continue
+			}
+			r.redactEnv(value)
+		}
+	case []interface{}:
+		for _, item := range o {
+			r.redactEnv(item)
+		}
+	}
+}
+
+func (r *Redactor) redactEnvVars(env []interface{}) {
+	for _, item := range env {
+		envVar, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := envVar["name"].(string)
+		value, hasValue := envVar["value"].(string)
+		if !hasValue {
+			continue
+		}
+		if matchesAny(r.envNamePatterns, name) || matchesAny(r.envValuePatterns, value) {
+			envVar["value"] = r.replacement
+		}
+	}
+}
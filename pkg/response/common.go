@@ -1,18 +1,22 @@
 package response
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
 
 	"github.com/alvaroaleman/static-kas/pkg/transform"
 )
 
-func transformIfNeeded(object runtime.Object, transform transform.TransformFunc) (interface{}, error) {
+func transformIfNeeded(object runtime.Object, transform transform.TransformFunc) (runtime.Object, error) {
 	if transform == nil {
 		return object, nil
 	}
@@ -24,13 +28,80 @@ func writeJSON(data interface{}, w http.ResponseWriter) error {
 	return json.NewEncoder(w).Encode(data)
 }
 
+// write serializes data in the media type of the request and writes it with the given status code.
+func write(r *http.Request, w http.ResponseWriter, statusCode int, data interface{}) error {
+	return writeOrdered(r, w, statusCode, data, nil)
+}
+
+// writeOrdered is like write, but YAML keeps the field orders of the files the objects were read from if they are
+// passed.
+func writeOrdered(r *http.Request, w http.ResponseWriter, statusCode int, data interface{}, orders map[string]fieldOrder) error {
+	contentType, serialize := runtime.ContentTypeJSON, json.Marshal
+	if mediaTypeFrom(r) == runtime.ContentTypeYAML {
+		contentType, serialize = runtime.ContentTypeYAML, yaml.Marshal
+		if orders != nil {
+			serialize = func(data interface{}) ([]byte, error) {
+				return orderedYAML(data, orders)
+			}
+		}
+	}
+	serialized, err := serialize(data)
+	if err != nil {
+		return fmt.Errorf("failed to serialize: %w", err)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	_, err = w.Write(serialized)
+	return err
+}
+
+// NewStatusResponse responds with the Status of an error. Errors that are not an APIStatus result in an
+// InternalError.
+func NewStatusResponse(r *http.Request, w http.ResponseWriter, err error) error {
+	var apiStatus apierrors.APIStatus
+	if !errors.As(err, &apiStatus) {
+		apiStatus = apierrors.NewInternalError(err)
+	}
+	status := apiStatus.Status()
+	status.Kind, status.APIVersion = "Status", "v1"
+
+	return write(r, w, int(status.Code), status)
+}
+
 func isWatch(r *http.Request) bool {
 	return r.URL.Query().Get("watch") == "true"
 }
 
-func respondToWatch(r *http.Request, w http.ResponseWriter, objects ...runtime.Object) error {
-	for _, item := range objects {
-		if err := writeJSON(&metav1.WatchEvent{Type: "ADDED", Object: runtime.RawExtension{Object: item}}, w); err != nil {
+// respondToWatch sends an ADDED event for each object, transformed individually if a transform is passed,
+// and then blocks until the request is done. If the request has a WatchReplayer, the events it returns are sent
+// instead.
+func respondToWatch(r *http.Request, w http.ResponseWriter, transform transform.TransformFunc, objects ...runtime.Object) error {
+	events := make([]WatchEvent, 0, len(objects))
+	for _, object := range objects {
+		events = append(events, WatchEvent{Type: "ADDED", Object: object})
+	}
+	if replayer, ok := r.Context().Value(watchReplayerKey{}).(WatchReplayer); ok {
+		events = replayer(objects)
+		// The objects were already mutated
+		mutated := make(map[runtime.Object]bool, len(objects))
+		for _, object := range objects {
+			mutated[object] = true
+		}
+		for _, event := range events {
+			if !mutated[event.Object] {
+				mutateObjects(r, event.Object)
+			}
+		}
+	}
+
+	for _, event := range events {
+		transformed, err := transformIfNeeded(event.Object, transform)
+		if err != nil {
+			err = fmt.Errorf("transform failed: %w", err)
+			NewStatusResponse(r, w, err)
+			return err
+		}
+		if err := writeJSON(&metav1.WatchEvent{Type: event.Type, Object: runtime.RawExtension{Object: transformed}}, w); err != nil {
 			return fmt.Errorf("failed to write watch item: %w", err)
 		}
 	}
@@ -50,3 +121,69 @@ func unstructuredListItemsToRuntimeObjects(l *unstructured.UnstructuredList) []r
 
 	return result
 }
+
+// WatchEvent is an event that is sent to a watch.
+type WatchEvent struct {
+	Type   string
+	Object runtime.Object
+}
+
+// WatchReplayer returns the events to send to a watch for the objects it would otherwise send an ADDED event
+// for, for example to play back their history.
+type WatchReplayer func(objects []runtime.Object) []WatchEvent
+
+type watchReplayerKey struct{}
+
+// WithWatchReplayer returns a context that makes all watches that are served for requests with it send the
+// events the replayer returns.
+func WithWatchReplayer(ctx context.Context, replayer WatchReplayer) context.Context {
+	return context.WithValue(ctx, watchReplayerKey{}, replayer)
+}
+
+// HasWatchReplayer returns whether watches that are served for requests with the context send the events of a
+// WatchReplayer.
+func HasWatchReplayer(ctx context.Context) bool {
+	_, ok := ctx.Value(watchReplayerKey{}).(WatchReplayer)
+	return ok
+}
+
+// ObjectMutator modifies objects before they are returned, for example to redact sensitive data.
+type ObjectMutator func(*unstructured.Unstructured)
+
+type objectMutatorKey struct{}
+
+// WithObjectMutator returns a context that makes all responses that are constructed for requests with it
+// apply the mutator to the objects they return.
+func WithObjectMutator(ctx context.Context, mutator ObjectMutator) context.Context {
+	return context.WithValue(ctx, objectMutatorKey{}, mutator)
+}
+
+func hasObjectMutator(r *http.Request) bool {
+	_, ok := r.Context().Value(objectMutatorKey{}).(ObjectMutator)
+	return ok
+}
+
+func mutateObjects(r *http.Request, objects ...runtime.Object) {
+	mutator, ok := r.Context().Value(objectMutatorKey{}).(ObjectMutator)
+	if !ok {
+		return
+	}
+	for _, object := range objects {
+		if u, ok := object.(*unstructured.Unstructured); ok {
+			mutator(u)
+		}
+	}
+}
+
+type mediaTypeKey struct{}
+
+// WithMediaType returns a context that makes all responses that are constructed for requests with it use the
+// media type, which is either application/json or application/yaml. Watch events are always JSON.
+func WithMediaType(ctx context.Context, mediaType string) context.Context {
+	return context.WithValue(ctx, mediaTypeKey{}, mediaType)
+}
+
+func mediaTypeFrom(r *http.Request) string {
+	mediaType, _ := r.Context().Value(mediaTypeKey{}).(string)
+	return mediaType
+}
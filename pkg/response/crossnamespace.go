@@ -2,7 +2,6 @@ package response
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"path"
 
@@ -15,16 +14,16 @@ import (
 func NewCrossNamespaceListResponse(
 	r *http.Request,
 	w http.ResponseWriter,
-	parentDir string,
+	namespaceDirs []string,
 	group string,
 	resource string,
 	transform transform.TransformFunc,
 	filter ...filter.Filter,
 ) error {
-	result, err := readAndDeserializeForAllNamespaces(parentDir, group, resource)
+	result, err := readAndDeserializeForAllNamespaces(namespaceDirs, group, resource)
 	if err != nil {
 		err = fmt.Errorf("failed to get %s from all namespaces: %w", resource, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		NewStatusResponse(r, w, err)
 		return err
 	}
 
@@ -32,37 +31,34 @@ func NewCrossNamespaceListResponse(
 		result, err = filter(result)
 		if err != nil {
 			err = fmt.Errorf("filter failed: %w", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			NewStatusResponse(r, w, err)
 			return err
 		}
 	}
 
+	mutateObjects(r, unstructuredListItemsToRuntimeObjects(result)...)
 	if isWatch(r) {
-		return respondToWatch(r, w, unstructuredListItemsToRuntimeObjects(result)...)
+		return respondToWatch(r, w, transform, unstructuredListItemsToRuntimeObjects(result)...)
 	}
 
 	transformed, err := transformIfNeeded(result, transform)
 	if err != nil {
 		err = fmt.Errorf("failed to transform: %w", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		NewStatusResponse(r, w, err)
 		return err
 	}
 
-	return writeJSON(transformed, w)
+	return write(r, w, http.StatusOK, transformed)
 }
 
-func readAndDeserializeForAllNamespaces(parentDir, group, resource string) (*unstructured.UnstructuredList, error) {
-	namespaces, err := ioutil.ReadDir(parentDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list namespaces: %w", err)
-	}
+func readAndDeserializeForAllNamespaces(namespaceDirs []string, group, resource string) (*unstructured.UnstructuredList, error) {
 	result := &unstructured.UnstructuredList{}
 	result.SetAPIVersion("v1")
 	result.SetKind("List")
-	for _, namespace := range namespaces {
-		fromNamespace, err := ReadAndDeserializeList(path.Join(parentDir, namespace.Name(), group), resource)
+	for _, namespaceDir := range namespaceDirs {
+		fromNamespace, err := ReadAndDeserializeList(path.Join(namespaceDir, group), resource)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read from namespace %s: %w", namespace.Name(), err)
+			return nil, fmt.Errorf("failed to read from namespace %s: %w", path.Base(namespaceDir), err)
 		}
 		result.Items = append(result.Items, fromNamespace.Items...)
 	}
@@ -6,8 +6,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/yaml"
 
 	"github.com/alvaroaleman/static-kas/pkg/transform"
@@ -44,49 +49,142 @@ type getResponse struct {
 }
 
 func (g *getResponse) run() error {
-	object, found, err := g.read()
+	object, raw, found, err := readObject(g.parentDir, g.resourceName, g.objectName)
 	if err != nil {
 		err = fmt.Errorf("failed to read: %w", err)
-		http.Error(g.w, err.Error(), http.StatusInternalServerError)
+		NewStatusResponse(g.r, g.w, err)
 		return err
 	}
 	if !found {
 		if g.staticFallBack == nil {
-			g.w.WriteHeader(404)
-			return nil
+			return NewStatusResponse(g.r, g.w, apierrors.NewNotFound(schema.GroupResource{Resource: g.resourceName}, g.objectName))
 		}
 		object = g.staticFallBack.DeepCopy()
 	}
 
-	if isWatch(g.r) {
-		return respondToWatch(g.r, g.w, object)
+	// YAML keeps the field order of the file the object was read from. Objects that have their own file are served
+	// as-is if possible.
+	var orders map[string]fieldOrder
+	if found && g.transform == nil && !isWatch(g.r) && mediaTypeFrom(g.r) == runtime.ContentTypeYAML {
+		if raw != nil && !hasObjectMutator(g.r) {
+			g.w.Header().Set("Content-Type", runtime.ContentTypeYAML)
+			_, err := g.w.Write(raw)
+			return err
+		}
+		if raw != nil {
+			orders, err = readFieldOrders(raw)
+		} else {
+			orders, err = listItems.fieldOrders(filepath.Join(g.parentDir, g.resourceName+".yaml"))
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to read field order: %w", err)
+			NewStatusResponse(g.r, g.w, err)
+			return err
+		}
+	}
+
+	return newObjectResponse(g.r, g.w, object, g.transform, orders)
+}
+
+// NewObjectResponse responds with an object that was already read, for example because it had to be
+// modified first.
+func NewObjectResponse(r *http.Request, w http.ResponseWriter, object runtime.Object, transform transform.TransformFunc) error {
+	return newObjectResponse(r, w, object, transform, nil)
+}
+
+func newObjectResponse(r *http.Request, w http.ResponseWriter, object runtime.Object, transform transform.TransformFunc, orders map[string]fieldOrder) error {
+	mutateObjects(r, object)
+	if isWatch(r) {
+		return respondToWatch(r, w, transform, object)
 	}
 
-	transformed, err := transformIfNeeded(object, g.transform)
+	transformed, err := transformIfNeeded(object, transform)
 	if err != nil {
 		err = fmt.Errorf("transform failed: %w", err)
-		http.Error(g.w, err.Error(), http.StatusInternalServerError)
+		NewStatusResponse(r, w, err)
 		return err
 	}
 
-	return writeJSON(transformed, g.w)
+	return writeOrdered(r, w, http.StatusOK, transformed, orders)
 }
 
-func (g *getResponse) read() (*unstructured.Unstructured, bool, error) {
-	data, err := ioutil.ReadFile(filepath.Join(g.parentDir, g.resourceName, g.objectName+".yaml"))
-	if err != nil {
-		if os.IsNotExist(err) {
-			return g.readFromList()
+// ReadObject reads a single object from parentDir. It looks at all representations ReadAndDeserializeList
+// looks at and returns the one with the newest resourceVersion, preferring individual files on ties.
+func ReadObject(parentDir, resourceName, objectName string) (*unstructured.Unstructured, bool, error) {
+	object, _, found, err := readObject(parentDir, resourceName, objectName)
+	return object, found, err
+}
+
+// readObject is like ReadObject, but additionally returns the content of the file if the object has its own.
+func readObject(parentDir, resourceName, objectName string) (*unstructured.Unstructured, []byte, bool, error) {
+	paths := []string{filepath.Join(parentDir, resourceName, objectName+".yaml")}
+	if filepath.Base(parentDir) == "core" {
+		paths = append(paths, filepath.Join(filepath.Dir(parentDir), resourceName, objectName, objectName+".yaml"))
+	}
+
+	var result *unstructured.Unstructured
+	var raw []byte
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, false, err
+		}
+		object := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(data, object); err != nil {
+			return nil, nil, false, err
+		}
+		if result == nil || IsNewer(object, result) {
+			result, raw = object, data
 		}
-		return nil, false, err
 	}
 
-	result := &unstructured.Unstructured{}
-	return result, true, yaml.Unmarshal(data, result)
+	fromList, found, err := readObjectFromList(parentDir, resourceName, objectName)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if found && (result == nil || IsNewer(fromList, result)) {
+		result, raw = fromList, nil
+	}
+
+	return result, raw, result != nil, nil
+}
+
+func readObjectFromList(parentDir, resourceName, objectName string) (*unstructured.Unstructured, bool, error) {
+	return listItems.get(filepath.Join(parentDir, resourceName+".yaml"), objectName)
 }
 
-func (g *getResponse) readFromList() (*unstructured.Unstructured, bool, error) {
-	data, err := ioutil.ReadFile(filepath.Join(g.parentDir, g.resourceName+".yaml"))
+// maxCachedLists is the number of list files whose items are kept in memory.
+const maxCachedLists = 64
+
+// listItems caches the items of list files by name, so getting an object that is in a list doesn't parse the whole
+// list on every request.
+var listItems = &listItemCache{entries: map[string]*cachedList{}}
+
+type listItemCache struct {
+	lock    sync.Mutex
+	entries map[string]*cachedList
+	// paths contains the paths of the entries, oldest first
+	paths []string
+}
+
+type cachedList struct {
+	modTime time.Time
+	size    int64
+	items   map[string]*unstructured.Unstructured
+
+	// orders are the field orders of the items, they are only read for YAML responses
+	ordersOnce sync.Once
+	orders     map[string]fieldOrder
+	ordersErr  error
+}
+
+// get returns a copy of the item with the given name of the list in path. The cached items are read again if the
+// file changed.
+func (c *listItemCache) get(path, objectName string) (*unstructured.Unstructured, bool, error) {
+	info, err := os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, false, nil
@@ -94,15 +192,75 @@ func (g *getResponse) readFromList() (*unstructured.Unstructured, bool, error) {
 		return nil, false, err
 	}
 
+	c.lock.Lock()
+	list, found := c.entries[path]
+	c.lock.Unlock()
+	if !found || !list.modTime.Equal(info.ModTime()) || list.size != info.Size() {
+		if list, err = readCachedList(path, info); err != nil {
+			return nil, false, err
+		}
+		c.add(path, list)
+	}
+
+	item, found := list.items[objectName]
+	if !found {
+		return nil, false, nil
+	}
+	return item.DeepCopy(), true, nil
+}
+
+// fieldOrders returns the field orders of the items of the list in path, keyed by namespace and name.
+func (c *listItemCache) fieldOrders(path string) (map[string]fieldOrder, error) {
+	c.lock.Lock()
+	list, found := c.entries[path]
+	c.lock.Unlock()
+	if !found {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return readFieldOrders(data)
+	}
+
+	list.ordersOnce.Do(func() {
+		var data []byte
+		if data, list.ordersErr = ioutil.ReadFile(path); list.ordersErr == nil {
+			list.orders, list.ordersErr = readFieldOrders(data)
+		}
+	})
+	return list.orders, list.ordersErr
+}
+
+func (c *listItemCache) add(path string, list *cachedList) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, found := c.entries[path]; !found {
+		c.paths = append(c.paths, path)
+	}
+	c.entries[path] = list
+	for len(c.paths) > maxCachedLists {
+		delete(c.entries, c.paths[0])
+		c.paths = c.paths[1:]
+	}
+}
+
+func readCachedList(path string, info os.FileInfo) (*cachedList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 	list := &unstructured.UnstructuredList{}
 	if err := yaml.Unmarshal(data, list); err != nil {
-		return nil, false, err
+		return nil, err
 	}
-	for _, item := range list.Items {
-		if item.GetName() == g.objectName {
-			return &item, true, nil
+
+	result := &cachedList{modTime: info.ModTime(), size: info.Size(), items: make(map[string]*unstructured.Unstructured, len(list.Items))}
+	for i := range list.Items {
+		item := &list.Items[i]
+		if existing, found := result.items[item.GetName()]; !found || IsNewer(item, existing) {
+			result.items[item.GetName()] = item
 		}
 	}
 
-	return nil, false, nil
+	return result, nil
 }
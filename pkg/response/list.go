@@ -7,10 +7,12 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"sigs.k8s.io/yaml"
 
@@ -49,10 +51,12 @@ type listResponse struct {
 }
 
 func (l *listResponse) run() error {
-	list, err := l.readAndDeserialize()
+	// Tables and watch events are not read from files, everything else keeps the field order of the files in YAML
+	withFieldOrders := mediaTypeFrom(l.r) == runtime.ContentTypeYAML && l.transform == nil && !isWatch(l.r)
+	list, orders, err := readAndDeserializeList(l.parentDir, l.resourceName, withFieldOrders)
 	if err != nil {
 		err = fmt.Errorf("failed to read and deserialize: %w", err)
-		http.Error(l.w, err.Error(), http.StatusInternalServerError)
+		NewStatusResponse(l.r, l.w, err)
 		return err
 	}
 	if len(list.Items) == 0 && l.staticFallBack != nil {
@@ -63,7 +67,7 @@ func (l *listResponse) run() error {
 		list, err = filter(list)
 		if err != nil {
 			err = fmt.Errorf("filter failed: %w", err)
-			http.Error(l.w, err.Error(), http.StatusInternalServerError)
+			NewStatusResponse(l.r, l.w, err)
 			return err
 		}
 	}
@@ -75,93 +79,163 @@ func (l *listResponse) run() error {
 		return list.Items[a].GetName() < list.Items[b].GetName()
 	})
 
+	mutateObjects(l.r, unstructuredListItemsToRuntimeObjects(list)...)
 	if isWatch(l.r) {
-		return respondToWatch(l.r, l.w, unstructuredListItemsToRuntimeObjects(list)...)
+		return respondToWatch(l.r, l.w, l.transform, unstructuredListItemsToRuntimeObjects(list)...)
 	}
 
 	transformed, err := transformIfNeeded(list, l.transform)
 	if err != nil {
 		err = fmt.Errorf("failed to transform: %w", err)
-		http.Error(l.w, err.Error(), http.StatusInternalServerError)
+		NewStatusResponse(l.r, l.w, err)
 		return err
 	}
 
-	return writeJSON(transformed, l.w)
+	return writeOrdered(l.r, l.w, http.StatusOK, transformed, orders)
 }
 
-func (l *listResponse) readAndDeserialize() (*unstructured.UnstructuredList, error) {
-	return ReadAndDeserializeList(l.parentDir, l.resourceName)
+// ReadAndDeserializeList reads all representations of a resource in parentDir: A resourceName.yaml that contains
+// a list or a single object, individual resourceName/$name.yaml files and for core resources the
+// resourceName/$name/$name.yaml files next to the core folder. Objects that are contained in more than one of them
+// are de-duplicated by namespace and name, the one with the newest resourceVersion wins.
+func ReadAndDeserializeList(parentDir, resourceName string) (*unstructured.UnstructuredList, error) {
+	list, _, err := readAndDeserializeList(parentDir, resourceName, false)
+	return list, err
 }
 
-func ReadAndDeserializeList(parenDir, resourceName string) (*unstructured.UnstructuredList, error) {
-	fileContents, err := readList(parenDir, resourceName)
+// readAndDeserializeList is like ReadAndDeserializeList, but can additionally return the field order of the objects
+// in the files, keyed by namespace and name.
+func readAndDeserializeList(parentDir, resourceName string, withFieldOrders bool) (*unstructured.UnstructuredList, map[string]fieldOrder, error) {
+	fileContents, err := readList(parentDir, resourceName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	var orders map[string]fieldOrder
+	if withFieldOrders {
+		orders = map[string]fieldOrder{}
+		for _, fileContent := range fileContents {
+			fileOrders, err := readFieldOrders(fileContent)
+			if err != nil {
+				return nil, nil, err
+			}
+			for key, order := range fileOrders {
+				if _, found := orders[key]; !found {
+					orders[key] = order
+				}
+			}
+		}
 	}
 
 	result := &unstructured.UnstructuredList{}
 	result.SetAPIVersion("v1")
 	result.SetKind("List")
 
-	switch len(fileContents) {
-	case 0:
-		return result, nil
-	// Could be a list or a single item
-	case 1:
+	var items []unstructured.Unstructured
+	for _, fileContent := range fileContents {
+		if len(fileContent) == 0 {
+			continue
+		}
 		// Unmarshal into an unstructured first, because that is guaranteed
 		// to not cause issues even if we get a list, as it doesn't make any
 		// assumptions about structure (a list assumes there is a list under
 		// the .items field).
 		target := &unstructured.Unstructured{}
-		if err := yaml.Unmarshal(fileContents[0], target); err != nil {
-			return nil, err
+		if err := yaml.Unmarshal(fileContent, target); err != nil {
+			return nil, nil, err
 		}
 		if !strings.HasSuffix(target.GetKind(), "List") {
-			result.Items = []unstructured.Unstructured{*target}
-			result.SetAPIVersion(result.Items[0].GetAPIVersion())
-			result.SetKind(result.Items[0].GetKind() + "List")
-			return result, nil
+			items = append(items, *target)
+			continue
 		}
-		if err := yaml.Unmarshal(fileContents[0], result); err != nil {
-			return nil, err
+		list := &unstructured.UnstructuredList{}
+		if err := yaml.Unmarshal(fileContent, list); err != nil {
+			return nil, nil, err
 		}
-		if len(result.Items) > 0 {
-			result.SetAPIVersion(result.Items[0].GetAPIVersion())
-			result.SetKind(result.Items[0].GetKind() + "List")
+		// Keep the kind of empty lists
+		if len(fileContents) == 1 {
+			result.SetAPIVersion(list.GetAPIVersion())
+			result.SetKind(list.GetKind())
 		}
-		return result, nil
-	default:
-		for _, fileContent := range fileContents {
-			target := &unstructured.Unstructured{}
-			if err := yaml.Unmarshal(fileContent, target); err != nil {
-				return nil, err
-			}
-			result.Items = append(result.Items, *target)
+		items = append(items, list.Items...)
+	}
+
+	result.Items = deduplicate(items)
+	if len(result.Items) > 0 {
+		result.SetAPIVersion(result.Items[0].GetAPIVersion())
+		result.SetKind(result.Items[0].GetKind() + "List")
+	}
+	return result, orders, nil
+}
+
+// deduplicate removes all but the newest of the objects that have the same namespace and name, keeping the order.
+func deduplicate(items []unstructured.Unstructured) []unstructured.Unstructured {
+	indexByKey := make(map[string]int, len(items))
+	result := make([]unstructured.Unstructured, 0, len(items))
+	for _, item := range items {
+		key := item.GetNamespace() + "/" + item.GetName()
+		idx, duplicate := indexByKey[key]
+		if !duplicate {
+			indexByKey[key] = len(result)
+			result = append(result, item)
+			continue
+		}
+		if IsNewer(&item, &result[idx]) {
+			result[idx] = item
 		}
+	}
 
-		if len(result.Items) > 0 {
-			result.SetAPIVersion(result.Items[0].GetAPIVersion())
-			result.SetKind(result.Items[0].GetKind() + "List")
+	return result
+}
+
+// IsNewer returns true if a is a newer representation of an object than b. If their UIDs differ, they are different
+// incarnations of an object that was deleted and created again and the one that was created later is newer.
+// Otherwise, or if they were created at the same time, the one with the newer resourceVersion is newer.
+// ResourceVersions are opaque, but in practice numbers, so they are compared as such and only if that is not
+// possible as strings.
+func IsNewer(a, b *unstructured.Unstructured) bool {
+	if a.GetUID() != "" && b.GetUID() != "" && a.GetUID() != b.GetUID() {
+		aCreated, bCreated := a.GetCreationTimestamp(), b.GetCreationTimestamp()
+		if !aCreated.Equal(&bCreated) {
+			return bCreated.Before(&aCreated)
 		}
-		return result, nil
 	}
 
+	aResourceVersion, aErr := strconv.ParseUint(a.GetResourceVersion(), 10, 64)
+	bResourceVersion, bErr := strconv.ParseUint(b.GetResourceVersion(), 10, 64)
+	if aErr == nil && bErr == nil {
+		return aResourceVersion > bResourceVersion
+	}
+
+	return a.GetResourceVersion() > b.GetResourceVersion()
 }
 
+// readList returns the content of all files that contain a representation of the resource, individual files first.
 func readList(parentDir, resourceName string) ([][]byte, error) {
+	result, err := readIndividualObjects(filepath.Join(parentDir, resourceName))
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Base(parentDir) == "core" {
+		nested, err := readNestedObjects(filepath.Join(filepath.Dir(parentDir), resourceName))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, nested...)
+	}
+
 	data, err := ioutil.ReadFile(filepath.Join(parentDir, resourceName+".yaml"))
 	if err != nil {
 		if os.IsNotExist(err) {
-			return readIndividualObjects(parentDir, resourceName)
+			return result, nil
 		}
 		return nil, err
 	}
 
-	return [][]byte{data}, nil
+	return append(result, data), nil
 }
 
-func readIndividualObjects(parentDir, resourceName string) ([][]byte, error) {
-	dirPath := filepath.Join(parentDir, resourceName)
+// readIndividualObjects reads all yaml files in dirPath, ordered by name.
+func readIndividualObjects(dirPath string) ([][]byte, error) {
 	entries, err := ioutil.ReadDir(dirPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -170,23 +244,57 @@ func readIndividualObjects(parentDir, resourceName string) ([][]byte, error) {
 		return nil, err
 	}
 
-	var result [][]byte
-	var errs []error
-	var lock sync.Mutex
-	var wg sync.WaitGroup
+	var paths []string
 	for _, entry := range entries {
 		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yaml") {
 			continue
 		}
-		entry := entry
+		paths = append(paths, filepath.Join(dirPath, entry.Name()))
+	}
+
+	return readFiles(paths)
+}
+
+// readNestedObjects reads all $name/$name.yaml files in dirPath, the way must-gather stores pods next to the
+// core folder.
+func readNestedObjects(dirPath string) ([][]byte, error) {
+	entries, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dirPath, entry.Name(), entry.Name()+".yaml")
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	return readFiles(paths)
+}
+
+// readFiles reads the files concurrently and returns their content in the order of the paths.
+func readFiles(paths []string) ([][]byte, error) {
+	result := make([][]byte, len(paths))
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for idx, path := range paths {
+		idx, path := idx, path
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			data, err := ioutil.ReadFile(filepath.Join(dirPath, entry.Name()))
-			lock.Lock()
-			defer lock.Unlock()
-			result = append(result, data)
-			errs = append(errs, err)
+			result[idx], errs[idx] = ioutil.ReadFile(path)
 		}()
 	}
 	wg.Wait()
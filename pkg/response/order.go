@@ -0,0 +1,117 @@
+package response
+
+import (
+	"sort"
+
+	yamlv2 "gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// fieldOrder is the field order of an object in the file it was read from, a yamlv2.MapSlice that contains
+// MapSlices and slices of them.
+type fieldOrder = yamlv2.MapSlice
+
+// readFieldOrders returns the field order of the objects in a file that contains an object or a list, keyed by
+// namespace and name.
+func readFieldOrders(data []byte) (map[string]fieldOrder, error) {
+	document := fieldOrder{}
+	if err := yamlv2.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	objects := []interface{}{document}
+	if items, found := mapSliceValue(document, "items"); found {
+		objects, _ = items.([]interface{})
+	}
+
+	result := make(map[string]fieldOrder, len(objects))
+	for _, object := range objects {
+		object, ok := object.(yamlv2.MapSlice)
+		if !ok {
+			continue
+		}
+		metadata, _ := mapSliceValue(object, "metadata")
+		metadataMap, _ := metadata.(yamlv2.MapSlice)
+		namespace, _ := mapSliceValue(metadataMap, "namespace")
+		name, _ := mapSliceValue(metadataMap, "name")
+		namespaceString, _ := namespace.(string)
+		nameString, _ := name.(string)
+		if _, found := result[namespaceString+"/"+nameString]; !found {
+			result[namespaceString+"/"+nameString] = object
+		}
+	}
+
+	return result, nil
+}
+
+func mapSliceValue(m yamlv2.MapSlice, key string) (interface{}, bool) {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// orderedYAML serializes an object or list to YAML with the fields of each object in the order of the file it was
+// read from. Fields that are not in the file, for example because a mutator added them, follow sorted.
+func orderedYAML(object interface{}, orders map[string]fieldOrder) ([]byte, error) {
+	switch object := object.(type) {
+	case *unstructured.Unstructured:
+		return yamlv2.Marshal(ordered(object.Object, orders[object.GetNamespace()+"/"+object.GetName()]))
+	case *unstructured.UnstructuredList:
+		content := object.UnstructuredContent()
+		items := make([]interface{}, 0, len(object.Items))
+		for _, item := range object.Items {
+			items = append(items, ordered(item.Object, orders[item.GetNamespace()+"/"+item.GetName()]))
+		}
+		content["items"] = items
+		return yamlv2.Marshal(content)
+	default:
+		return yaml.Marshal(object)
+	}
+}
+
+// ordered returns the value with all maps converted to MapSlices in the order of the template.
+func ordered(value interface{}, template interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		templateMap, _ := template.(yamlv2.MapSlice)
+		result := make(yamlv2.MapSlice, 0, len(value))
+		seen := make(map[string]bool, len(value))
+		for _, item := range templateMap {
+			key, ok := item.Key.(string)
+			if !ok || seen[key] {
+				continue
+			}
+			if fieldValue, found := value[key]; found {
+				seen[key] = true
+				result = append(result, yamlv2.MapItem{Key: key, Value: ordered(fieldValue, item.Value)})
+			}
+		}
+		var remaining []string
+		for key := range value {
+			if !seen[key] {
+				remaining = append(remaining, key)
+			}
+		}
+		sort.Strings(remaining)
+		for _, key := range remaining {
+			result = append(result, yamlv2.MapItem{Key: key, Value: ordered(value[key], nil)})
+		}
+		return result
+	case []interface{}:
+		templateSlice, _ := template.([]interface{})
+		result := make([]interface{}, 0, len(value))
+		for i, item := range value {
+			var itemTemplate interface{}
+			if i < len(templateSlice) {
+				itemTemplate = templateSlice[i]
+			}
+			result = append(result, ordered(item, itemTemplate))
+		}
+		return result
+	default:
+		return value
+	}
+}
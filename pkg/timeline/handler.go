@@ -0,0 +1,192 @@
+package timeline
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/alvaroaleman/static-kas/pkg/response"
+)
+
+// watchParameters are the query parameters of a watch that have to be removed to list the objects it watches.
+var watchParameters = []string{"watch", "resourceVersion", "resourceVersionMatch", "allowWatchBookmarks", "timeoutSeconds", "sendInitialEvents", "asOf"}
+
+// NewHandler serves a timeline of snapshots, oldest first, each of which is served by the handler with the same index.
+// By default the newest snapshot is served, the asOf query parameter selects the newest snapshot that was taken at or
+// before the given RFC3339 time. Watches replay the transitions between consecutive snapshots up to the selected one
+// as ADDED, MODIFIED and DELETED events with synthetic resourceVersions.
+func NewHandler(l *zap.Logger, snapshots []Snapshot, handlers []http.Handler) http.Handler {
+	return &timelineHandler{l: l, snapshots: snapshots, handlers: handlers, lists: map[string][]unstructured.Unstructured{}}
+}
+
+// maxCachedLists is the number of lists of snapshots that are kept in memory.
+const maxCachedLists = 64
+
+type timelineHandler struct {
+	l         *zap.Logger
+	snapshots []Snapshot
+	handlers  []http.Handler
+
+	// lists caches the objects of the snapshots by snapshot and list request, so every watch doesn't list all
+	// snapshots again. Snapshots don't change, so entries are only evicted, oldest first.
+	listsLock sync.Mutex
+	lists     map[string][]unstructured.Unstructured
+	listKeys  []string
+}
+
+func (t *timelineHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	idx := len(t.snapshots) - 1
+	if asOf := r.URL.Query().Get("asOf"); asOf != "" {
+		asOfTime, err := time.Parse(time.RFC3339, asOf)
+		if err != nil {
+			t.writeStatus(w, r, apierrors.NewBadRequest(fmt.Sprintf("asOf must be a RFC3339 time: %v", err)))
+			return
+		}
+		for idx >= 0 && t.snapshots[idx].Time.After(asOfTime) {
+			idx--
+		}
+		if idx < 0 {
+			t.writeStatus(w, r, apierrors.NewBadRequest(fmt.Sprintf("the first snapshot was taken at %s, after %s", t.snapshots[0].Time.Format(time.RFC3339), asOf)))
+			return
+		}
+	}
+	if r.URL.Query().Get("watch") == "true" && idx > 0 {
+		r = r.WithContext(response.WithWatchReplayer(r.Context(), func([]runtime.Object) []response.WatchEvent {
+			return t.replay(r, idx)
+		}))
+	}
+
+	t.handlers[idx].ServeHTTP(w, r)
+}
+
+func (t *timelineHandler) writeStatus(w http.ResponseWriter, r *http.Request, err error) {
+	if err := response.NewStatusResponse(r, w, err); err != nil {
+		t.l.Error("failed to write response", zap.Error(err))
+	}
+}
+
+// replay returns the events that transition from nothing to the first snapshot and then from each snapshot to the
+// next, up to the snapshot with the given index.
+func (t *timelineHandler) replay(r *http.Request, idx int) []response.WatchEvent {
+	var result []response.WatchEvent
+	var resourceVersion int
+	event := func(eventType string, object *unstructured.Unstructured) {
+		resourceVersion++
+		object = object.DeepCopy()
+		object.SetResourceVersion(strconv.Itoa(resourceVersion))
+		result = append(result, response.WatchEvent{Type: eventType, Object: object})
+	}
+
+	var previous []unstructured.Unstructured
+	for i := 0; i <= idx; i++ {
+		current, err := t.list(r, i)
+		if err != nil {
+			t.l.Error("failed to list snapshot, skipping it", zap.String("snapshot", t.snapshots[i].Name()), zap.Error(err))
+			continue
+		}
+		previousByKey := make(map[string]*unstructured.Unstructured, len(previous))
+		for i := range previous {
+			previousByKey[objectKey(&previous[i])] = &previous[i]
+		}
+		currentKeys := make(map[string]bool, len(current))
+		for i := range current {
+			key := objectKey(&current[i])
+			currentKeys[key] = true
+			before, existed := previousByKey[key]
+			switch {
+			case !existed:
+				event("ADDED", &current[i])
+			case !reflect.DeepEqual(before.Object, current[i].Object):
+				event("MODIFIED", &current[i])
+			}
+		}
+		for i := range previous {
+			if !currentKeys[objectKey(&previous[i])] {
+				event("DELETED", &previous[i])
+			}
+		}
+		previous = current
+	}
+
+	return result
+}
+
+// list lists the objects of a watch request in the snapshot with the given index. A resource that doesn't
+// exist in the snapshot has no objects. The returned objects must not be modified.
+func (t *timelineHandler) list(r *http.Request, idx int) ([]unstructured.Unstructured, error) {
+	listURL := *r.URL
+	query := listURL.Query()
+	for _, parameter := range watchParameters {
+		query.Del(parameter)
+	}
+	listURL.RawQuery = query.Encode()
+	key := strconv.Itoa(idx) + " " + listURL.RequestURI()
+
+	t.listsLock.Lock()
+	items, found := t.lists[key]
+	t.listsLock.Unlock()
+	if found {
+		return items, nil
+	}
+	items, err := t.listUncached(r, idx, &listURL)
+	if err != nil {
+		return nil, err
+	}
+
+	t.listsLock.Lock()
+	defer t.listsLock.Unlock()
+	if _, found := t.lists[key]; !found {
+		t.listKeys = append(t.listKeys, key)
+	}
+	t.lists[key] = items
+	for len(t.listKeys) > maxCachedLists {
+		delete(t.lists, t.listKeys[0])
+		t.listKeys = t.listKeys[1:]
+	}
+
+	return items, nil
+}
+
+func (t *timelineHandler) listUncached(r *http.Request, idx int, listURL *url.URL) ([]unstructured.Unstructured, error) {
+	listRequest := r.Clone(r.Context())
+	listRequest.URL = listURL
+	listRequest.RequestURI = listURL.RequestURI()
+	listRequest.Header.Set("Accept", runtime.ContentTypeJSON)
+
+	recorder := httptest.NewRecorder()
+	t.handlers[idx].ServeHTTP(recorder, listRequest)
+	if recorder.Code == http.StatusNotFound {
+		return nil, nil
+	}
+	if recorder.Code != http.StatusOK {
+		return nil, fmt.Errorf("listing returned status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	decoded, _, err := unstructured.UnstructuredJSONScheme.Decode(recorder.Body.Bytes(), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode list: %w", err)
+	}
+	switch decoded := decoded.(type) {
+	case *unstructured.UnstructuredList:
+		return decoded.Items, nil
+	case *unstructured.Unstructured:
+		// Watches of a single object
+		return []unstructured.Unstructured{*decoded}, nil
+	default:
+		return nil, fmt.Errorf("got unexpected %T", decoded)
+	}
+}
+
+func objectKey(u *unstructured.Unstructured) string {
+	return u.GetNamespace() + "/" + u.GetName()
+}
@@ -0,0 +1,126 @@
+package timeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alvaroaleman/static-kas/pkg/response"
+)
+
+// Snapshot is a dump of a cluster at a point in time.
+type Snapshot struct {
+	BaseDir   string
+	ClusterID string
+	Time      time.Time
+}
+
+// Name returns a name for the snapshot that is unique within all dumps of all clusters.
+func (s Snapshot) Name() string {
+	return s.ClusterID + "@" + s.Time.UTC().Format(time.RFC3339)
+}
+
+// Group groups dumps by the cluster they were taken from into timelines, oldest snapshot first. Dumps whose cluster
+// can not be identified are omitted.
+func Group(baseDirs []string) (map[string][]Snapshot, error) {
+	result := map[string][]Snapshot{}
+	var errs []error
+	for _, baseDir := range baseDirs {
+		clusterID, err := ClusterID(baseDir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get cluster id of %s: %w", baseDir, err))
+			continue
+		}
+		if clusterID == "" {
+			continue
+		}
+		snapshotTime, err := DumpTime(baseDir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get time of %s: %w", baseDir, err))
+		}
+		if snapshotTime == nil {
+			// Dumps without a timestamp file are ordered by when they were written
+			info, err := os.Stat(baseDir)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			modTime := info.ModTime()
+			snapshotTime = &modTime
+		}
+		result[clusterID] = append(result[clusterID], Snapshot{BaseDir: baseDir, ClusterID: clusterID, Time: *snapshotTime})
+	}
+	for _, snapshots := range result {
+		sort.Slice(snapshots, func(i, j int) bool {
+			return snapshots[i].Time.Before(snapshots[j].Time)
+		})
+	}
+
+	return result, utilerrors.NewAggregate(errs)
+}
+
+// ClusterID returns the id of the cluster a dump was taken from. This is the id from the ClusterVersion on OpenShift
+// and the UID of the kube-system namespace otherwise. It is empty if the dump contains neither.
+func ClusterID(baseDir string) (string, error) {
+	clusterVersion, found, err := response.ReadObject(filepath.Join(baseDir, "cluster-scoped-resources", "config.openshift.io"), "clusterversions", "version")
+	if err != nil {
+		return "", fmt.Errorf("failed to read clusterversion: %w", err)
+	}
+	if found {
+		if clusterID, _, _ := unstructured.NestedString(clusterVersion.Object, "spec", "clusterID"); clusterID != "" {
+			return clusterID, nil
+		}
+	}
+
+	raw, err := os.ReadFile(filepath.Join(baseDir, "namespaces", "kube-system", "kube-system.yaml"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read kube-system namespace: %w", err)
+	}
+	var namespace struct {
+		Metadata struct {
+			UID string `json:"uid"`
+		} `json:"metadata"`
+	}
+	if err := yaml.Unmarshal(raw, &namespace); err != nil {
+		return "", fmt.Errorf("failed to decode kube-system namespace: %w", err)
+	}
+
+	return namespace.Metadata.UID, nil
+}
+
+// DumpTime returns the time from the timestamp file must-gather writes, which contains the start and end time of
+// the dump. The last time that can be parsed is returned, nil if there is no timestamp file.
+func DumpTime(baseDir string) (*time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(baseDir, "timestamp"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read timestamp file: %w", err)
+	}
+
+	var result *time.Time
+	var errs []error
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// Strip the monotonic clock reading
+		line = strings.TrimSpace(strings.Split(line, " m=")[0])
+		ts, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse line %q in timestamp file: %w", line, err))
+			continue
+		}
+		result = &ts
+	}
+
+	return result, utilerrors.NewAggregate(errs)
+}
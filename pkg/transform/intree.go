@@ -105,9 +105,9 @@ func (ph *printHandler) TableHandler(columns []metav1.TableColumnDefinition, pri
 	return nil
 }
 
-func (ph *printHandler) transformFunc(tableVersion string, fallback TransformFunc) TransformFunc {
-	return func(o runtime.Object) (*metav1.Table, error) {
-		res, err := ph.printInternal(tableVersion, o)
+func (ph *printHandler) transformFunc(tableVersion string, opts TableOptions, fallback TransformFunc) TransformFunc {
+	return func(o runtime.Object) (runtime.Object, error) {
+		res, err := ph.printInternal(tableVersion, opts, o)
 		if err != nil {
 			ph.log.Error("Internal printer errored", zap.Error(err))
 			return fallback(o)
@@ -131,7 +131,7 @@ func (ph *printHandler) transformFunc(tableVersion string, fallback TransformFun
 //     the way, because:
 //   - Kubectl will refuse the entire list if any of the object keys does not have GVK set
 //   - Kubectl infers the namespace in case of namespaced objects from the embedded object, so we can not just omit it
-func (ph *printHandler) printInternal(tableVersion string, o runtime.Object) (*metav1.Table, error) {
+func (ph *printHandler) printInternal(tableVersion string, opts TableOptions, o runtime.Object) (*metav1.Table, error) {
 	internalVersion, err := legacyscheme.Scheme.New(schema.GroupVersionKind{Group: o.GetObjectKind().GroupVersionKind().Group, Kind: o.GetObjectKind().GroupVersionKind().Kind, Version: runtime.APIVersionInternal})
 	if err != nil {
 		return nil, nil
@@ -146,7 +146,7 @@ func (ph *printHandler) printInternal(tableVersion string, o runtime.Object) (*m
 		return nil, fmt.Errorf("failed to convert to internal version: %w", err)
 	}
 
-	generateOpts := printers.GenerateOptions{Wide: true}
+	generateOpts := printers.GenerateOptions{Wide: opts.Wide}
 	var result []reflect.Value
 	switch argCount := reflect.TypeOf(handler.printFunc.Interface()).NumIn(); argCount {
 	case 2:
@@ -195,14 +195,19 @@ func (ph *printHandler) printInternal(tableVersion string, o runtime.Object) (*m
 		externalVersion.(gvkSetter).SetGroupVersionKind(gvk)
 		rows[idx].Object = runtime.RawExtension{Object: externalVersion}
 	}
-	return &metav1.Table{
+	table := &metav1.Table{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "meta.k8s.io/" + tableVersion,
 			Kind:       "Table",
 		},
 		ColumnDefinitions: handler.columnDefinitions,
 		Rows:              rows,
-	}, nil
+	}
+	if err := applyTableOptions(table, tableVersion, opts); err != nil {
+		return nil, err
+	}
+
+	return table, nil
 }
 
 type gvkSetter interface {
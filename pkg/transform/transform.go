@@ -8,6 +8,7 @@ import (
 	"go.uber.org/zap"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apiextensions-apiserver/pkg/registry/customresource/tableconvertor"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -26,11 +27,28 @@ const (
 	VerbGet  = "get"
 )
 
-type TransformFunc func(r runtime.Object) (*metav1.Table, error)
+// TransformFunc transforms an object or list into a different representation, for example a Table.
+type TransformFunc func(r runtime.Object) (runtime.Object, error)
+
+// TableOptions configure how tables are generated.
+type TableOptions struct {
+	// NoHeaders omits the column definitions.
+	NoHeaders bool
+	// IncludeObject configures if rows embed nothing, the PartialObjectMetadata or the full object.
+	IncludeObject metav1.IncludeObjectPolicy
+	// Wide includes columns with a priority greater than zero, which kubectl only shows with -o wide.
+	Wide bool
+}
+
+// DefaultTableOptions returns the TableOptions that are used if a request doesn't specify any. They match
+// what a kube-apiserver does.
+func DefaultTableOptions() TableOptions {
+	return TableOptions{IncludeObject: metav1.IncludeMetadata, Wide: true}
+}
 
 func transform(header []metav1.TableColumnDefinition, body func([]byte) ([]metav1.TableRow, error)) func(string) TransformFunc {
 	return func(tableVersion string) TransformFunc {
-		return func(o runtime.Object) (*metav1.Table, error) {
+		return func(o runtime.Object) (runtime.Object, error) {
 			serialized, err := json.Marshal(o)
 			if err != nil {
 				return nil, fmt.Errorf("failed to serialize: %w", err)
@@ -52,11 +70,11 @@ func transform(header []metav1.TableColumnDefinition, body func([]byte) ([]metav
 	}
 }
 
-func NewTableTransformMap(log *zap.Logger, crds map[string]*apiextensionsv1.CustomResourceDefinition) func(TransformEntryKey, string) TransformFunc {
+func NewTableTransformMap(log *zap.Logger, crds map[string]*apiextensionsv1.CustomResourceDefinition) func(TransformEntryKey, string, TableOptions) TransformFunc {
 	inTreeHandler := newInTreeHandler(log)
 	defaultConvertor := registryrest.NewDefaultTableConvertor(schema.GroupResource{})
-	return func(key TransformEntryKey, tableVersion string) TransformFunc {
-		fallBackHandler := func(r runtime.Object) (*metav1.Table, error) {
+	return func(key TransformEntryKey, tableVersion string, opts TableOptions) TransformFunc {
+		fallBackHandler := func(r runtime.Object) (runtime.Object, error) {
 			var convertor func(ctx context.Context, object runtime.Object, tableOptions runtime.Object) (*metav1.Table, error)
 
 			additionalPrinterColums := additionalPrinterColumsForCRD(key, crds)
@@ -70,12 +88,13 @@ func NewTableTransformMap(log *zap.Logger, crds map[string]*apiextensionsv1.Cust
 				}
 				convertor = converter.ConvertToTable
 			}
+			// The options get applied afterwards, as the convertors only support a subset of them
 			table, err := convertor(context.Background(), r, &metav1.TableOptions{})
 			if err != nil {
 				return nil, err
 			}
-			if err := makeTableObjectsPartialObjectMetadata(table); err != nil {
-				return nil, fmt.Errorf("failed to convert table objects to partialObjectMetadata: %w", err)
+			if err := applyTableOptions(table, tableVersion, opts); err != nil {
+				return nil, err
 			}
 			table.Kind = "Table"
 			// There is a v1 and a v1beta1 and they both look the same, but clients might be unable to decode
@@ -84,7 +103,7 @@ func NewTableTransformMap(log *zap.Logger, crds map[string]*apiextensionsv1.Cust
 			return table, nil
 		}
 
-		return inTreeHandler.transformFunc(tableVersion, fallBackHandler)
+		return inTreeHandler.transformFunc(tableVersion, opts, fallBackHandler)
 	}
 }
 
@@ -103,20 +122,96 @@ func additionalPrinterColumsForCRD(key TransformEntryKey, crds map[string]*apiex
 	return nil
 }
 
-func makeTableObjectsPartialObjectMetadata(t *metav1.Table) error {
+// applyTableOptions strips the column definitions, the columns that are only shown in wide mode and the
+// embedded objects from a table, as configured by the options.
+func applyTableOptions(t *metav1.Table, tableVersion string, opts TableOptions) error {
+	if !opts.Wide {
+		var keep []int
+		var columnDefinitions []metav1.TableColumnDefinition
+		for idx, column := range t.ColumnDefinitions {
+			if column.Priority == 0 {
+				keep = append(keep, idx)
+				columnDefinitions = append(columnDefinitions, column)
+			}
+		}
+		for rowIdx := range t.Rows {
+			// Printers might have already omitted the wide cells
+			if len(t.Rows[rowIdx].Cells) != len(t.ColumnDefinitions) {
+				continue
+			}
+			cells := make([]interface{}, 0, len(keep))
+			for _, idx := range keep {
+				cells = append(cells, t.Rows[rowIdx].Cells[idx])
+			}
+			t.Rows[rowIdx].Cells = cells
+		}
+		t.ColumnDefinitions = columnDefinitions
+	}
+	if opts.NoHeaders {
+		t.ColumnDefinitions = nil
+	}
+
 	for idx := range t.Rows {
-		serialized, err := json.Marshal(t.Rows[idx].Object)
+		switch opts.IncludeObject {
+		case metav1.IncludeNone:
+			t.Rows[idx].Object = runtime.RawExtension{}
+		case metav1.IncludeObject:
+		default:
+			m, err := toPartialObjectMetadata(t.Rows[idx].Object, tableVersion)
+			if err != nil {
+				return fmt.Errorf("failed to convert table objects to partialObjectMetadata: %w", err)
+			}
+			t.Rows[idx].Object = runtime.RawExtension{Object: m}
+		}
+	}
+
+	return nil
+}
+
+// PartialObjectMetadata returns a TransformFunc that converts objects into a PartialObjectMetadata and lists into
+// a PartialObjectMetadataList of the given meta.k8s.io version, as requested by metadata-only clients.
+func PartialObjectMetadata(version string) TransformFunc {
+	return func(o runtime.Object) (runtime.Object, error) {
+		if !apimeta.IsListType(o) {
+			return toPartialObjectMetadata(o, version)
+		}
+
+		listMeta, err := apimeta.ListAccessor(o)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("failed to get list metadata: %w", err)
 		}
-		m := &metav1.PartialObjectMetadata{}
-		if err := json.Unmarshal(serialized, m); err != nil {
-			return err
+		items, err := apimeta.ExtractList(o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract list items: %w", err)
+		}
+		result := &metav1.PartialObjectMetadataList{
+			TypeMeta: metav1.TypeMeta{Kind: "PartialObjectMetadataList", APIVersion: "meta.k8s.io/" + version},
+			ListMeta: metav1.ListMeta{ResourceVersion: listMeta.GetResourceVersion(), Continue: listMeta.GetContinue()},
+			Items:    make([]metav1.PartialObjectMetadata, 0, len(items)),
 		}
-		m.Kind = "PartialObjectMetadata"
-		m.APIVersion = "meta.k8s.io/v1beta1"
-		t.Rows[idx].Object = runtime.RawExtension{Object: m}
+		for _, item := range items {
+			m, err := toPartialObjectMetadata(item, version)
+			if err != nil {
+				return nil, err
+			}
+			result.Items = append(result.Items, *m)
+		}
+
+		return result, nil
+	}
+}
+
+func toPartialObjectMetadata(o interface{}, version string) (*metav1.PartialObjectMetadata, error) {
+	serialized, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
 	}
+	m := &metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(serialized, m); err != nil {
+		return nil, err
+	}
+	m.Kind = "PartialObjectMetadata"
+	m.APIVersion = "meta.k8s.io/" + version
 
-	return nil
+	return m, nil
 }